@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fiurgeist/journey/internal/cache"
+	"fiurgeist/journey/internal/config"
+	"fiurgeist/journey/internal/export"
+	"fiurgeist/journey/internal/geojson"
+	"fiurgeist/journey/internal/geometry"
+	"fiurgeist/journey/internal/seed"
+	"fiurgeist/journey/internal/store"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+)
+
+type command struct {
+	usage string
+	run   func(name string, args []string) error
+}
+
+var commands = map[string]command{
+	"requeue-dead-letters": {
+		usage: "write the dead letters of the store again, letters failing again are dead lettered anew",
+		run:   requeueDeadLetters,
+	},
+	"export": {
+		usage: "write a table of the store to stdout: export [flags] <journeys|locations> <csv|ndjson> [collinear|rdp [tolerance]]",
+		run:   exportTable,
+	},
+	"import": {
+		usage: "validate route files (.csv, .ndjson, .geojson) and write their journeys to the store: import [flags] [-world id] <file>...",
+		run:   importRoutes,
+	},
+	"export-geojson": {
+		usage: "write the journeys of the store as GeoJSON FeatureCollection to stdout: export-geojson [flags] [collinear|rdp [tolerance]]",
+		run:   exportGeoJSON,
+	},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	// the commands share the config of the server
+	err := cmd.run(os.Args[0]+" "+os.Args[1], os.Args[2:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n    \t%s\n", name, commands[name].usage)
+	}
+}
+
+// requirePersistent fails for the in-memory store, which is gone once the command exits
+func requirePersistent(conf config.Config) error {
+	if conf.Store.Driver == "ramsql" {
+		return fmt.Errorf("Store driver %s isn't persistent", conf.Store.Driver)
+	}
+	return nil
+}
+
+func requeueDeadLetters(name string, args []string) error {
+	conf, err := config.Load(name, args, os.Getenv)
+	if err != nil {
+		return err
+	}
+	if conf.Store.DeadLetterFile == "" {
+		return errors.New("No dead letter file configured")
+	}
+	if err := requirePersistent(conf); err != nil {
+		return err
+	}
+
+	// move the letters aside, the store appends the ones failing again to the original file.
+	// A pending file is left over by an interrupted run and is requeued first.
+	pending := conf.Store.DeadLetterFile + ".requeue"
+	if _, err := os.Stat(pending); os.IsNotExist(err) {
+		err := os.Rename(conf.Store.DeadLetterFile, pending)
+		if os.IsNotExist(err) {
+			// nothing failed since the last run
+			log.Println("Requeued 0 dead letters")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	letters, err := store.ReadDeadLetters(pending)
+	if err != nil {
+		return err
+	}
+
+	s, err := store.Open(conf.Store.Config)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	failed := 0
+	for _, letter := range letters {
+		msg, err := letter.Decode()
+		if err != nil {
+			return fmt.Errorf("Failed to decode dead letter: %s", err)
+		}
+		if err := s.Write(msg); err != nil {
+			failed++
+		}
+	}
+	log.Printf("Requeued %d dead letters, %d failed again\n", len(letters)-failed, failed)
+
+	return os.Remove(pending)
+}
+
+func exportGeoJSON(name string, args []string) error {
+	conf, args, err := config.LoadArgs(name, args, os.Getenv)
+	if err != nil {
+		return err
+	}
+	simplify, err := simplifierArgs(args)
+	if err != nil {
+		return err
+	}
+
+	s, err := store.Open(conf.Store.Config)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	journeys, err := s.LoadJourneys()
+	if err != nil {
+		return err
+	}
+	if simplify != nil {
+		for i := range journeys {
+			journeys[i].Points = simplify(journeys[i].Points)
+		}
+	}
+	transform := geojson.Identity
+	if conf.Server.GeoJSON != nil {
+		transform = *conf.Server.GeoJSON
+	}
+	return geojson.Write(os.Stdout, journeys, transform)
+}
+
+func exportTable(name string, args []string) error {
+	conf, args, err := config.LoadArgs(name, args, os.Getenv)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 {
+		return errors.New("Expected the dataset (journeys, locations) and the format (csv, ndjson)")
+	}
+	simplify, err := simplifierArgs(args[2:])
+	if err != nil {
+		return err
+	}
+
+	s, err := store.Open(conf.Store.Config)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	var source export.Source = s
+	if simplify != nil {
+		source = export.Simplified(source, simplify)
+	}
+	out := bufio.NewWriter(os.Stdout)
+	if err := export.Export(out, source, args[0], args[1]); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// simplifierArgs reads the optional simplification and its tolerance, nil keeps all points
+func simplifierArgs(args []string) (geometry.Simplifier, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	if len(args) > 2 {
+		return nil, fmt.Errorf("Unexpected arguments %v", args[2:])
+	}
+	tolerance := geometry.DEFAULT_TOLERANCE
+	if len(args) == 2 {
+		var err error
+		if tolerance, err = strconv.ParseFloat(args[1], 64); err != nil {
+			return nil, fmt.Errorf("Invalid tolerance %q", args[1])
+		}
+	}
+	return geometry.NewSimplifier(args[0], tolerance)
+}
+
+func importRoutes(name string, args []string) error {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	worldId := flags.String("world", cache.DEFAULT_WORLD, "world of the imported journeys")
+	conf, files, err := config.LoadFlags(flags, args, os.Getenv)
+	if err != nil {
+		return err
+	}
+	world, ok := conf.World(*worldId)
+	if !ok {
+		return fmt.Errorf("Unknown world %q", *worldId)
+	}
+	if err := requirePersistent(conf); err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("No route files given")
+	}
+	transform := geojson.Identity
+	if conf.Server.GeoJSON != nil {
+		transform = *conf.Server.GeoJSON
+	}
+
+	// all files are read and validated before anything is written
+	var journeys []cache.Journey
+	for _, file := range files {
+		read, err := readRoutes(file, transform)
+		if err != nil {
+			return err
+		}
+		journeys = append(journeys, read...)
+	}
+	for i := range journeys {
+		journeys[i].World = cache.MessageWorld(world.Id)
+	}
+	if err := seed.Validate(journeys, world); err != nil {
+		return err
+	}
+
+	s, err := store.Open(conf.Store.Config)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	// like warming up the cache, journeys known already are kept as they are
+	existing := make(map[string]bool)
+	err = s.EachJourney(func(row export.JourneyRow) error {
+		existing[row.Id] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	imported, skipped, failed := 0, 0, 0
+	for _, journey := range journeys {
+		if existing[store.JourneyId(journey.World, journey.StartId, journey.DestinationId)] {
+			skipped++
+			continue
+		}
+		failed += seed.Write(s, journey)
+		imported++
+	}
+	log.Printf("Imported %d journeys, skipped %d existing; %d messages failed\n", imported, skipped, failed)
+	return nil
+}
+
+func readRoutes(path string, transform geojson.Transform) ([]cache.Journey, error) {
+	format, err := seed.FormatOf(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	journeys, err := seed.Read(f, format, transform)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s: %s", path, err)
+	}
+	return journeys, nil
+}
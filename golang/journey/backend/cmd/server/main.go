@@ -2,46 +2,132 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fiurgeist/journey/internal/cache"
+	"fiurgeist/journey/internal/config"
+	"fiurgeist/journey/internal/export"
+	"fiurgeist/journey/internal/health"
+	"fiurgeist/journey/internal/heatmap"
 	"fiurgeist/journey/internal/metrics"
 	"fiurgeist/journey/internal/queue"
 	"fiurgeist/journey/internal/server"
 	"fiurgeist/journey/internal/store"
+	"fiurgeist/journey/internal/stream"
+	"flag"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
-	"time"
 )
 
 func main() {
+	conf, err := config.Load(os.Args[0], os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+
 	log.Println("Starting server...")
 	// Capture SIGINT to for graceful shutdown
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt)
 
-	queue := queue.NewQueue()
-	defer queue.Close()
+	metrics := metrics.NewMetrics(conf.Metrics.Interval)
+	defer metrics.Close()
 
-	s, err := store.NewStore(queue)
-	if err != nil {
-		log.Printf("Error creating store: %v\n", err)
+	msgQueue := queue.NewQueue(conf.Queue.Size)
+	defer msgQueue.Close()
+
+	// the live stream sees every message the caches send to the store, the heatmaps the messages of
+	// their world
+	hub := stream.NewHub(conf.Server.StreamHistory, conf.Server.StreamBuffer)
+	// caches and heatmaps by the world of their messages, unbounded worlds have no heatmap
+	caches := make(map[string]cache.Cache)
+	heatmaps := make(map[string]*heatmap.Heatmap)
+	worlds := make(map[string]cache.Cache)
+	worldHeatmaps := make(map[string]*heatmap.Heatmap)
+	for _, world := range conf.Worlds {
+		worldConfig := conf.Cache
+		worldConfig.World = world
+		observers := []func(msg interface{}){hub.Publish}
+		if world.Bounded() {
+			worldHeatmap := heatmap.New(world.Bounds)
+			heatmaps[cache.MessageWorld(world.Id)] = worldHeatmap
+			worldHeatmaps[world.Id] = worldHeatmap
+			observers = append(observers, worldHeatmap.Observe)
+		}
+		caches[cache.MessageWorld(world.Id)] = cache.NewCache(worldConfig, metrics, queue.NewObservedQueue(msgQueue, observers...))
+		if world.Id != cache.DEFAULT_WORLD {
+			worlds[world.Id] = caches[cache.MessageWorld(world.Id)]
+		}
 	}
-	defer s.Close()
+	cache := caches[""]
 
-	metrics := metrics.NewMetrics()
-	defer metrics.Close()
+	checker := health.NewChecker()
+	checker.Register("queue", health.QueueFill(msgQueue, conf.Health.QueueThreshold))
+	checker.Register("cache", func() error {
+		for _, worldCache := range caches {
+			if !worldCache.IsWarmedUp() {
+				return errors.New("Cache is warming up")
+			}
+		}
+		return nil
+	})
+
+	// stays nil without store
+	var exporter export.Source
+	s, err := store.NewStore(conf.Store.Config, msgQueue)
+	if err != nil {
+		if conf.Store.OnFailure != config.STORE_FAILURE_DEGRADED {
+			log.Fatalf("Error creating store: %v\n", err)
+		}
+
+		// degraded: serve from the cache only, nobody would read the queue otherwise
+		log.Printf("Error creating store, running without persistence: %v\n", err)
+		go queue.Drain(msgQueue, func(interface{}) { metrics.LogDiscarded() })
+		storeErr := fmt.Errorf("Store unavailable: %s", err)
+		checker.Register("store", func() error { return storeErr })
+		for _, worldCache := range caches {
+			worldCache.WarmUp(nil)
+		}
+	} else {
+		defer s.Close()
+		exporter = s
+		checker.Register("store", s.Ping)
+		checker.Register("storeConsumer", func() error { return s.CheckConsumer(conf.Health.ConsumerTimeout) })
 
-	cache := cache.NewCache(metrics, queue)
+		go func() {
+			journeys, err := loadJourneys(s, conf.Cache.Undirected)
+			if err != nil {
+				if conf.Store.OnFailure != config.STORE_FAILURE_DEGRADED {
+					log.Fatalf("Error loading journeys: %v\n", err)
+				}
 
-	config := server.Config{
-		Addr:        ":8083",
-		PublicDir:   "../public",
-		PublicJsDir: "../public/static/js",
+				// degraded: accept movements without the stored journeys, which may be mapped again
+				log.Printf("Error loading journeys, warming up without them: %v\n", err)
+				storeErr := fmt.Errorf("Loading journeys failed: %s", err)
+				checker.Register("store", func() error { return storeErr })
+			}
+			warmUp(caches, journeys, heatmaps)
+			log.Printf("Cache warmed up with %d journeys\n", len(journeys))
+		}()
 	}
-	srv := server.NewHTTPServer(config, metrics, cache)
+
+	srv := server.NewHTTPServer(conf.Server, server.Dependencies{
+		Metrics:  metrics,
+		Cache:    cache,
+		Hub:      hub,
+		Checker:  checker,
+		Exporter: exporter,
+		Heatmaps: worldHeatmaps,
+		Worlds:   worlds,
+	})
 	go func() {
 		if err := srv.ListenAndServe(); err != nil {
-			if err.Error() == "http: Server closed" {
+			if errors.Is(err, http.ErrServerClosed) {
 				log.Println("Server shut down")
 			} else {
 				log.Printf("Error while ListenAndServe: %v\n", err)
@@ -53,9 +139,53 @@ func main() {
 	// Wait for SIGINT
 	<-stop
 
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), conf.ShutdownTimeout)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Printf("Error during server shutdown: %v\n", err)
 	}
 }
+
+type journeyStore interface {
+	MergeReverseJourneys() (int, error)
+	LoadJourneys() ([]cache.Journey, error)
+}
+
+// loadJourneys reads the journeys to warm up the caches, merging reverse journeys first for
+// undirected caches
+func loadJourneys(s journeyStore, undirected bool) ([]cache.Journey, error) {
+	if undirected {
+		merged, err := s.MergeReverseJourneys()
+		if err != nil {
+			return nil, fmt.Errorf("merging reverse journeys: %w", err)
+		}
+		if merged > 0 {
+			log.Printf("Merged %d reverse journeys\n", merged)
+		}
+	}
+	return s.LoadJourneys()
+}
+
+// warmUp passes the loaded journeys to the cache of their world
+func warmUp(caches map[string]cache.Cache, journeys []cache.Journey, heatmaps map[string]*heatmap.Heatmap) {
+	byWorld := make(map[string][]cache.Journey)
+	for _, journey := range journeys {
+		if _, ok := caches[journey.World]; !ok {
+			log.Printf("Skipping journey %s of unknown world %q\n", journey.Id, journey.World)
+			continue
+		}
+		byWorld[journey.World] = append(byWorld[journey.World], journey)
+	}
+
+	// no movements are accepted before, so the heatmaps haven't seen any of the points
+	for world, worldCache := range caches {
+		worldCache.WarmUp(byWorld[world])
+		if worldHeatmap := heatmaps[world]; worldHeatmap != nil {
+			for _, journey := range byWorld[world] {
+				for _, point := range journey.Points {
+					worldHeatmap.Add(point)
+				}
+			}
+		}
+	}
+}
@@ -2,14 +2,18 @@ module fiurgeist/journey
 
 go 1.17
 
+require (
+	github.com/gorilla/mux v1.8.0
+	github.com/gorilla/websocket v1.4.2
+	github.com/mattn/go-sqlite3 v1.14.8
+	github.com/proullon/ramsql v0.0.0-20210730175921-2692f3496a21
+	github.com/stretchr/testify v1.7.0
+	github.com/undefinedlabs/go-mpatch v1.0.6
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
+)
+
 require (
 	github.com/davecgh/go-spew v1.1.0 // indirect
-	github.com/gorilla/mux v1.8.0 // indirect
-	github.com/mattn/go-sqlite3 v1.14.8 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/proullon/ramsql v0.0.0-20210730175921-2692f3496a21 // indirect
 	github.com/stretchr/objx v0.1.0 // indirect
-	github.com/stretchr/testify v1.7.0 // indirect
-	github.com/undefinedlabs/go-mpatch v1.0.6 // indirect
-	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
 )
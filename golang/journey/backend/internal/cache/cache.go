@@ -5,12 +5,44 @@ import (
 	"fiurgeist/journey/internal/queue"
 	"fmt"
 	"log"
+	"math"
+	"sort"
 	"sync"
+	"time"
+)
+
+const (
+	SortById           = "id"
+	SortByPointCount   = "pointCount"
+	SortByDiscoveredAt = "discoveredAt"
 )
 
 type Journey struct {
-	Id     string  `json:"id"`
-	Points []Point `json:"data"`
+	Id            string    `json:"id"`
+	Points        []Point   `json:"data"`
+	StartId       uint16    `json:"-"`
+	DestinationId uint16    `json:"-"`
+	FullyMapped   bool      `json:"-"`
+	DiscoveredAt  time.Time `json:"-"`
+	Stats         Stats     `json:"-"`
+	// Undirected journeys are also walked from the destination to the start, see Reversed
+	Undirected bool `json:"-"`
+	// FirstSeq is the seq of the first point, it is negative if walks in reverse direction
+	// prepended points
+	FirstSeq int `json:"-"`
+	// World of the journey, empty for the default world
+	World string `json:"-"`
+}
+
+// Config of the cache
+type Config struct {
+	// Undirected maps the journeys between two locations in both directions as one route,
+	// starting at the lower location id
+	Undirected   bool               `yaml:"undirected"`
+	Plausibility PlausibilityConfig `yaml:"plausibility"`
+	// World of the cache, set for each configured world. Movements outside of its bounds are
+	// rejected, zero bounds don't limit them.
+	World World `yaml:"-"`
 }
 
 type Point struct {
@@ -18,11 +50,49 @@ type Point struct {
 	Y uint16 `json:"y"`
 }
 
+// Stats are maintained while the points of a journey arrive
+type Stats struct {
+	// Length is the walked distance in grid units
+	Length      float64     `json:"length"`
+	PointCount  int         `json:"pointCount"`
+	BoundingBox BoundingBox `json:"boundingBox"`
+	// Directness is the straight-line distance between the first and the last point divided
+	// by the walked distance, 0 without any distance walked
+	Directness float64 `json:"directness"`
+	// Characters is the number of characters which contributed points
+	Characters int `json:"characters"`
+}
+
+// TimedPoint is a movement at the time the client took it, zero if the client didn't send one
+type TimedPoint struct {
+	Point
+	At time.Time
+}
+
+type BoundingBox struct {
+	MinX uint16 `json:"minX" yaml:"minX"`
+	MinY uint16 `json:"minY" yaml:"minY"`
+	MaxX uint16 `json:"maxX" yaml:"maxX"`
+	MaxY uint16 `json:"maxY" yaml:"maxY"`
+}
+
 type Cache interface {
 	GetUniqueJourneys() []Journey
 	StartJourney(characterId string, startId, destinationId uint16)
 	Movement(characterId string, x, y uint16) error
+	Movements(characterId string, points []Point) []error
+	TimedMovement(characterId string, x, y uint16, at time.Time) error
+	TimedMovements(characterId string, movements []TimedPoint) []error
 	ReachedDestination(characterId string, destinationId uint16) error
+	WarmUp(journeys []Journey)
+	IsWarmedUp() bool
+	GetWorld() World
+	GetAnomalies() []CharacterAnomalies
+	GetTraversals(startId, destinationId uint16) (Traversals, bool)
+	FindJourneys(area Area) []JourneyMatch
+	GetCharacters() []Character
+	GetCharacter(characterId string) (Character, bool)
+	GetProgress(characterId string) (Progress, error)
 }
 
 type characterJourney struct {
@@ -32,10 +102,24 @@ type characterJourney struct {
 }
 
 type journey struct {
-	startId          uint16
-	destinationId    uint16
-	points           []Point
-	isFullyMapped    bool
+	startId       uint16
+	destinationId uint16
+	points        []Point
+	isFullyMapped bool
+	discoveredAt  time.Time
+	stats         Stats
+	// characters which contributed points since the start, the stats also count the ones
+	// of previous runs
+	characters map[string]bool
+	// seq of the first point, decremented by every prepended point
+	firstSeq int
+	// points walked by each character since the start, until the journey is fully mapped
+	traversals map[string]*walk
+	// points loaded from the store while the journey isn't fully mapped, they take part in the
+	// consensus like the walk of a character
+	stored *walk
+	// medoid of the walks, nil without any
+	consensus []Point
 }
 
 type cache struct {
@@ -44,18 +128,62 @@ type cache struct {
 	journeys          map[string]*journey
 	msgQueue          queue.Queue
 	metrics           metrics.Metrics
+	warmedUp          bool
+	undirected        bool
+	plausibility      PlausibilityConfig
+	// last plausible position of every character
+	positions map[string]position
+	anomalies map[string]*CharacterAnomalies
+	// last known state of every character
+	characters map[string]*Character
+	index      *spatialIndex
+	world      World
+	// world of the messages, empty for the default world
+	messageWorld string
 }
 
-func NewCache(metrics metrics.Metrics, msgQueue queue.Queue) *cache {
+func NewCache(config Config, metrics metrics.Metrics, msgQueue queue.Queue) *cache {
 	r := &cache{
 		characterJourneys: make(map[string]*characterJourney),
 		journeys:          make(map[string]*journey),
 		msgQueue:          msgQueue,
 		metrics:           metrics,
+		undirected:        config.Undirected,
+		plausibility:      config.Plausibility,
+		positions:         make(map[string]position),
+		anomalies:         make(map[string]*CharacterAnomalies),
+		characters:        make(map[string]*Character),
+		index:             newSpatialIndex(),
+		world:             config.World,
+		messageWorld:      MessageWorld(config.World.Id),
 	}
 	return r
 }
 
+// JourneyId is the id of the journey between two locations as used in the API
+func JourneyId(startId, destinationId uint16) string {
+	return fmt.Sprintf("%d->%d", startId, destinationId)
+}
+
+// Reversed returns the journey walked from the destination to the start
+func (j Journey) Reversed() Journey {
+	reversed := j
+	reversed.Id = JourneyId(j.DestinationId, j.StartId)
+	reversed.StartId, reversed.DestinationId = j.DestinationId, j.StartId
+	reversed.Points = reversePoints(j.Points)
+	return reversed
+}
+
+// route returns the ids of the route between two locations, which is reversed in undirected
+// mode if the start has the higher id
+func (c *cache) route(startId, destinationId uint16) (uint16, uint16, bool) {
+	if c.undirected && startId > destinationId {
+		return destinationId, startId, true
+	}
+	return startId, destinationId, false
+}
+
+// GetUniqueJourneys returns the journeys with their consensus as points while they are mapped
 func (c *cache) GetUniqueJourneys() []Journey {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -63,12 +191,52 @@ func (c *cache) GetUniqueJourneys() []Journey {
 	routes := make([]Journey, len(c.journeys))
 	index := 0
 	for id, route := range c.journeys {
-		routes[index] = Journey{Id: id, Points: route.points}
+		routes[index] = Journey{
+			Id:            id,
+			Points:        route.path(),
+			StartId:       route.startId,
+			DestinationId: route.destinationId,
+			FullyMapped:   route.isFullyMapped,
+			DiscoveredAt:  route.discoveredAt,
+			Stats:         route.stats,
+			Undirected:    c.undirected,
+			FirstSeq:      route.firstSeq,
+			World:         c.messageWorld,
+		}
 		index++
 	}
+	// map iteration is random, keep the output stable in order of discovery
+	SortJourneys(routes, SortByDiscoveredAt)
 	return routes
 }
 
+// SortJourneys sorts the journeys in place, ties are broken by the journey id
+func SortJourneys(journeys []Journey, by string) error {
+	var less func(a, b *Journey) bool
+	switch by {
+	case SortById:
+		less = func(a, b *Journey) bool { return a.Id < b.Id }
+	case SortByPointCount:
+		less = func(a, b *Journey) bool { return len(a.Points) < len(b.Points) }
+	case SortByDiscoveredAt:
+		less = func(a, b *Journey) bool { return a.DiscoveredAt.Before(b.DiscoveredAt) }
+	default:
+		return fmt.Errorf("Unknown sort order %q", by)
+	}
+
+	sort.SliceStable(journeys, func(i, j int) bool {
+		a, b := &journeys[i], &journeys[j]
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return a.Id < b.Id
+	})
+	return nil
+}
+
 func (c *cache) StartJourney(characterId string, startId, destinationId uint16) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -78,18 +246,64 @@ func (c *cache) StartJourney(characterId string, startId, destinationId uint16)
 		startId:       startId,
 		destinationId: destinationId,
 	}
-	if c.checkJourney(startId, destinationId) {
+	character := c.seen(characterId)
+	character.Journey = c.characterJourneys[characterId].active(false)
+	character.recent = nil
+	// a new journey may start anywhere, e.g. after a respawn
+	delete(c.positions, characterId)
+	routeStart, routeDestination, _ := c.route(startId, destinationId)
+	if c.checkJourney(routeStart, routeDestination) {
 		c.msgQueue.Push(queue.NewJourney{
-			StartId:       startId,
-			DestinationId: destinationId,
+			World:         c.messageWorld,
+			StartId:       routeStart,
+			DestinationId: routeDestination,
+			DiscoveredAt:  c.journeys[JourneyId(routeStart, routeDestination)].discoveredAt,
 		})
+	} else if route := c.journeys[JourneyId(routeStart, routeDestination)]; !route.isFullyMapped {
+		// only the latest walk of a character counts
+		route.removeTraversal(characterId)
 	}
 }
 
 func (c *cache) Movement(characterId string, x, y uint16) error {
+	return c.TimedMovement(characterId, x, y, time.Time{})
+}
+
+// TimedMovement applies a movement taken by the client at the given time, which its speed is
+// checked with. Without a time the movement is timed when the cache receives it.
+func (c *cache) TimedMovement(characterId string, x, y uint16, at time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.movement(characterId, x, y, at, false)
+}
+
+// Movements applies all points of one character in order under a single lock, returning an
+// error (or nil) per point. The points after the first arrive at once, so they aren't timed.
+func (c *cache) Movements(characterId string, points []Point) []error {
+	movements := make([]TimedPoint, len(points))
+	for i, point := range points {
+		movements[i].Point = point
+	}
+	return c.TimedMovements(characterId, movements)
+}
+
+// TimedMovements applies a batch like Movements, the points with the time of the client are
+// timed with it
+func (c *cache) TimedMovements(characterId string, movements []TimedPoint) []error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	errs := make([]error, len(movements))
+	for i, movement := range movements {
+		errs[i] = c.movement(characterId, movement.X, movement.Y, movement.At, i > 0)
+	}
+	return errs
+}
+
+// movement applies a single point, at is the time of the client if known and batched is set
+// for the points after the first of a batch
+func (c *cache) movement(characterId string, x, y uint16, at time.Time, batched bool) error {
 	characterJourney := c.characterJourneys[characterId]
 	if characterJourney == nil {
 		err := fmt.Errorf("No active characterJourney for character %s", characterId)
@@ -97,7 +311,8 @@ func (c *cache) Movement(characterId string, x, y uint16) error {
 		return err
 	}
 
-	routeKey := fmt.Sprintf("%d->%d", characterJourney.startId, characterJourney.destinationId)
+	routeStart, routeDestination, reversed := c.route(characterJourney.startId, characterJourney.destinationId)
+	routeKey := JourneyId(routeStart, routeDestination)
 	route := c.journeys[routeKey]
 	if route == nil {
 		err := fmt.Errorf(
@@ -106,13 +321,35 @@ func (c *cache) Movement(characterId string, x, y uint16) error {
 		log.Println(err.Error())
 		return err
 	}
-	if route.checkPosition(x, y) {
+	if !c.world.Contains(Point{X: x, Y: y}) {
+		err := fmt.Errorf("%w: (%d, %d) in world %s", ErrOutOfBounds, x, y, c.world.Id)
+		log.Println(err.Error())
+		return err
+	}
+	if err := c.checkPlausibility(characterId, x, y, at, batched); err != nil {
+		log.Println(err.Error())
+		return err
+	}
+	c.moved(characterId, Point{X: x, Y: y}, batched)
+	characters := route.stats.Characters
+	if route.checkPosition(characterId, x, y, reversed) {
+		c.index.add(routeKey, Point{X: x, Y: y})
+		seq := route.firstSeq + len(route.points) - 1
+		if reversed {
+			seq = route.firstSeq
+		}
 		c.msgQueue.Push(queue.NewLocation{
-			StartId:       characterJourney.startId,
-			DestinationId: characterJourney.destinationId,
+			World:         c.messageWorld,
+			StartId:       routeStart,
+			DestinationId: routeDestination,
 			X:             x,
 			Y:             y,
+			Seq:           seq,
 		})
+		// warming up derives the other stats from the points, but not the characters
+		if route.stats.Characters != characters {
+			c.pushStats(route)
+		}
 	}
 
 	return nil
@@ -129,8 +366,8 @@ func (c *cache) ReachedDestination(characterId string, destinationId uint16) err
 		return err
 	}
 
-	routeKey := fmt.Sprintf("%d->%d", characterJourney.startId, characterJourney.destinationId)
-	route := c.journeys[routeKey]
+	routeStart, routeDestination, _ := c.route(characterJourney.startId, characterJourney.destinationId)
+	route := c.journeys[JourneyId(routeStart, routeDestination)]
 	if route == nil {
 		err := fmt.Errorf(
 			"Missing journey between location %d and %d", characterJourney.startId, characterJourney.destinationId,
@@ -138,44 +375,231 @@ func (c *cache) ReachedDestination(characterId string, destinationId uint16) err
 		log.Println(err.Error())
 		return err
 	}
+	c.seen(characterId).Journey = characterJourney.active(true)
 
 	if route.isFullyMapped {
 		return nil
 	}
 
+	// the consensus of the walks replaces the interleaved points of all characters, the walks
+	// aren't needed anymore
+	final := route.finalPath()
 	route.isFullyMapped = true
+	route.traversals, route.stored, route.consensus = nil, nil, nil
 	c.metrics.LogJourney()
 	c.msgQueue.Push(queue.JourneyFullyMapped{
-		StartId:       characterJourney.startId,
-		DestinationId: characterJourney.destinationId,
+		World:         c.messageWorld,
+		StartId:       routeStart,
+		DestinationId: routeDestination,
 	})
+	if len(final) > 0 && !samePoints(final, route.points) {
+		route.setPath(final)
+		path := queue.JourneyPath{World: c.messageWorld, StartId: routeStart, DestinationId: routeDestination}
+		for _, point := range final {
+			path.Points = append(path.Points, queue.Location{X: point.X, Y: point.Y})
+		}
+		c.msgQueue.Push(path)
+	}
+	// the journey doesn't change anymore, so its stats are final
+	c.pushStats(route)
 
 	return nil
 }
 
+// WarmUp adds the journeys already known to the store. The server accepts movements only once
+// the cache is warmed up, otherwise a journey discovered before would number its points from 0
+// again; journeys already in the cache are kept as they are. In undirected mode the first of
+// two reverse journeys wins, the store merges them before loading.
+func (c *cache) WarmUp(journeys []Journey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, loaded := range journeys {
+		if _, _, reversed := c.route(loaded.StartId, loaded.DestinationId); reversed {
+			loaded = loaded.Reversed()
+			loaded.FirstSeq = 0
+		}
+		routeKey := JourneyId(loaded.StartId, loaded.DestinationId)
+		if c.journeys[routeKey] != nil {
+			continue
+		}
+		discoveredAt := loaded.DiscoveredAt
+		// journeys stored before the time was recorded
+		if discoveredAt.IsZero() {
+			discoveredAt = time.Now()
+		}
+		route := &journey{
+			startId:       loaded.StartId,
+			destinationId: loaded.DestinationId,
+			isFullyMapped: loaded.FullyMapped,
+			discoveredAt:  discoveredAt,
+			firstSeq:      loaded.FirstSeq,
+		}
+		// the stats follow from the points, except for the characters of previous runs
+		for _, point := range loaded.Points {
+			route.addPoint(point)
+			c.index.add(routeKey, point)
+		}
+		route.stats.Characters = loaded.Stats.Characters
+		if !loaded.FullyMapped && len(loaded.Points) > 0 {
+			route.stored = newWalk()
+			for _, point := range loaded.Points {
+				route.addWalkPoint(route.stored, point, false)
+			}
+			route.updateConsensus()
+		}
+		c.journeys[routeKey] = route
+		if loaded.FullyMapped {
+			c.metrics.LogJourney()
+		}
+	}
+	c.warmedUp = true
+}
+
+// GetTraversals returns the walks of the characters of a journey and their consensus, the
+// points are reversed for the reverse direction of an undirected journey
+func (c *cache) GetTraversals(startId, destinationId uint16) (Traversals, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	routeStart, routeDestination, reversed := c.route(startId, destinationId)
+	route := c.journeys[JourneyId(routeStart, routeDestination)]
+	if route == nil {
+		return Traversals{}, false
+	}
+
+	traversals := Traversals{
+		Id:         JourneyId(startId, destinationId),
+		Traversals: route.getTraversals(),
+		Consensus:  append([]Point{}, route.path()...),
+	}
+	if reversed {
+		for i := range traversals.Traversals {
+			traversals.Traversals[i].Points = reversePoints(traversals.Traversals[i].Points)
+		}
+		traversals.Consensus = reversePoints(traversals.Consensus)
+	}
+	return traversals, true
+}
+
+func (c *cache) GetWorld() World {
+	return c.world
+}
+
+func (c *cache) IsWarmedUp() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.warmedUp
+}
+
 func (c *cache) checkJourney(startId, destinationId uint16) bool {
-	routeKey := fmt.Sprintf("%d->%d", startId, destinationId)
+	routeKey := JourneyId(startId, destinationId)
 	route := c.journeys[routeKey]
 	if route == nil {
 		c.journeys[routeKey] = &journey{
 			startId:       startId,
 			destinationId: destinationId,
 			isFullyMapped: false,
+			discoveredAt:  time.Now(),
 		}
 		return true
 	}
 	return false
 }
 
-func (t *journey) checkPosition(x, y uint16) bool {
+// checkPosition adds a new point, in front of the others if the character walks the route
+// in reverse
+func (t *journey) checkPosition(characterId string, x, y uint16, reversed bool) bool {
 	if t.isFullyMapped {
 		return false
 	}
+	t.addTraversalPoint(characterId, Point{X: x, Y: y}, reversed)
 	for _, point := range t.points {
 		if point.X == x && point.Y == y {
 			return false
 		}
 	}
-	t.points = append(t.points, Point{X: x, Y: y})
+	if reversed {
+		t.prependPoint(Point{X: x, Y: y})
+	} else {
+		t.addPoint(Point{X: x, Y: y})
+	}
+	if t.characters == nil {
+		t.characters = make(map[string]bool)
+	}
+	if !t.characters[characterId] {
+		t.characters[characterId] = true
+		t.stats.Characters++
+	}
 	return true
 }
+
+// addPoint appends a point and updates the stats without walking all points again
+func (t *journey) addPoint(point Point) {
+	if len(t.points) > 0 {
+		t.stats.Length += distance(t.points[len(t.points)-1], point)
+	}
+	t.points = append(t.points, point)
+	t.updateStats(point)
+}
+
+// prependPoint adds a point in front of all others, the slice is copied as it may be shared
+// by GetUniqueJourneys
+func (t *journey) prependPoint(point Point) {
+	if len(t.points) > 0 {
+		t.stats.Length += distance(point, t.points[0])
+		t.firstSeq--
+	}
+	t.points = append([]Point{point}, t.points...)
+	t.updateStats(point)
+}
+
+// updateStats takes the new point into account after the length was updated
+func (t *journey) updateStats(point Point) {
+	if len(t.points) == 1 {
+		t.stats.BoundingBox = BoundingBox{MinX: point.X, MinY: point.Y, MaxX: point.X, MaxY: point.Y}
+	} else {
+		box := &t.stats.BoundingBox
+		if point.X < box.MinX {
+			box.MinX = point.X
+		}
+		if point.Y < box.MinY {
+			box.MinY = point.Y
+		}
+		if point.X > box.MaxX {
+			box.MaxX = point.X
+		}
+		if point.Y > box.MaxY {
+			box.MaxY = point.Y
+		}
+	}
+	t.stats.PointCount = len(t.points)
+	if t.stats.Length > 0 {
+		t.stats.Directness = distance(t.points[0], t.points[len(t.points)-1]) / t.stats.Length
+	}
+}
+
+func (c *cache) pushStats(route *journey) {
+	stats := route.statsMessage()
+	stats.World = c.messageWorld
+	c.msgQueue.Push(stats)
+}
+
+func (t *journey) statsMessage() queue.JourneyStats {
+	return queue.JourneyStats{
+		StartId:       t.startId,
+		DestinationId: t.destinationId,
+		Length:        t.stats.Length,
+		PointCount:    t.stats.PointCount,
+		MinX:          t.stats.BoundingBox.MinX,
+		MinY:          t.stats.BoundingBox.MinY,
+		MaxX:          t.stats.BoundingBox.MaxX,
+		MaxY:          t.stats.BoundingBox.MaxY,
+		Characters:    t.stats.Characters,
+	}
+}
+
+func distance(a, b Point) float64 {
+	return math.Hypot(float64(b.X)-float64(a.X), float64(b.Y)-float64(a.Y))
+}
@@ -2,6 +2,7 @@ package cache
 
 import (
 	"github.com/stretchr/testify/mock"
+	"time"
 )
 
 type MockCache struct {
@@ -22,7 +23,66 @@ func (m *MockCache) Movement(characterId string, x, y uint16) error {
 	return args.Error(0)
 }
 
+func (m *MockCache) Movements(characterId string, points []Point) []error {
+	args := m.Called(characterId, points)
+	return args.Get(0).([]error)
+}
+
+func (m *MockCache) TimedMovement(characterId string, x, y uint16, at time.Time) error {
+	args := m.Called(characterId, x, y, at)
+	return args.Error(0)
+}
+
+func (m *MockCache) TimedMovements(characterId string, movements []TimedPoint) []error {
+	args := m.Called(characterId, movements)
+	return args.Get(0).([]error)
+}
+
+func (m *MockCache) WarmUp(journeys []Journey) {
+	m.Called(journeys)
+}
+
+func (m *MockCache) GetWorld() World {
+	args := m.Called()
+	return args.Get(0).(World)
+}
+
+func (m *MockCache) IsWarmedUp() bool {
+	args := m.Called()
+	return args.Bool(0)
+}
+
 func (m *MockCache) ReachedDestination(characterId string, destinationId uint16) error {
 	args := m.Called(characterId, destinationId)
 	return args.Error(0)
 }
+
+func (m *MockCache) GetAnomalies() []CharacterAnomalies {
+	args := m.Called()
+	return args.Get(0).([]CharacterAnomalies)
+}
+
+func (m *MockCache) GetTraversals(startId, destinationId uint16) (Traversals, bool) {
+	args := m.Called(startId, destinationId)
+	return args.Get(0).(Traversals), args.Bool(1)
+}
+
+func (m *MockCache) FindJourneys(area Area) []JourneyMatch {
+	args := m.Called(area)
+	return args.Get(0).([]JourneyMatch)
+}
+
+func (m *MockCache) GetCharacters() []Character {
+	args := m.Called()
+	return args.Get(0).([]Character)
+}
+
+func (m *MockCache) GetCharacter(characterId string) (Character, bool) {
+	args := m.Called(characterId)
+	return args.Get(0).(Character), args.Bool(1)
+}
+
+func (m *MockCache) GetProgress(characterId string) (Progress, error) {
+	args := m.Called(characterId)
+	return args.Get(0).(Progress), args.Error(1)
+}
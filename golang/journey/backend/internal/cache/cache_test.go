@@ -3,12 +3,16 @@ package cache
 import (
 	"fiurgeist/journey/internal/metrics"
 	"fiurgeist/journey/internal/queue"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"github.com/undefinedlabs/go-mpatch"
+	"math"
 	"testing"
+	"time"
 )
 
 func TestGetUniqueJourneys(t *testing.T) {
-	cache := NewCache(nil, nil)
+	cache := NewCache(Config{}, nil, nil)
 
 	// test empty cache
 	require.Equal(t, []Journey{}, cache.GetUniqueJourneys())
@@ -26,19 +30,79 @@ func TestGetUniqueJourneys(t *testing.T) {
 
 	gotJourneys := cache.GetUniqueJourneys()
 	require.Equal(t, 2, len(gotJourneys))
-	require.Contains(t, gotJourneys, Journey{Id: "23->42", Points: nil})
+	require.Contains(t, gotJourneys, Journey{Id: "23->42", Points: nil, StartId: 23, DestinationId: 42})
 	require.Contains(
 		t,
 		gotJourneys,
-		Journey{Id: "42->23", Points: []Point{{X: 1, Y: 2}, {X: 2, Y: 2}}},
+		Journey{
+			Id:            "42->23",
+			Points:        []Point{{X: 1, Y: 2}, {X: 2, Y: 2}},
+			StartId:       42,
+			DestinationId: 23,
+			FullyMapped:   true,
+		},
 	)
 }
 
+func TestGetUniqueJourneysOrder(t *testing.T) {
+	cache := NewCache(Config{}, nil, nil)
+
+	discovered := mockNow()
+	cache.journeys["42->23"] = &journey{startId: 42, destinationId: 23, discoveredAt: discovered}
+	cache.journeys["13->42"] = &journey{startId: 13, destinationId: 42, discoveredAt: discovered.Add(time.Second)}
+	cache.journeys["23->42"] = &journey{startId: 23, destinationId: 42, discoveredAt: discovered}
+
+	// ordered by discovery, ties broken by id
+	for i := 0; i < 10; i++ {
+		gotJourneys := cache.GetUniqueJourneys()
+		require.Equal(t, 3, len(gotJourneys))
+		require.Equal(t, "23->42", gotJourneys[0].Id)
+		require.Equal(t, "42->23", gotJourneys[1].Id)
+		require.Equal(t, "13->42", gotJourneys[2].Id)
+	}
+}
+
+func TestSortJourneys(t *testing.T) {
+	discovered := mockNow()
+	journeys := []Journey{
+		{Id: "42->23", Points: []Point{{X: 1, Y: 2}}, DiscoveredAt: discovered.Add(time.Second)},
+		{Id: "13->42", Points: []Point{{X: 1, Y: 2}, {X: 2, Y: 2}}, DiscoveredAt: discovered},
+		{Id: "23->42", Points: nil, DiscoveredAt: discovered.Add(time.Second)},
+	}
+
+	err := SortJourneys(journeys, SortById)
+	require.NoError(t, err)
+	require.Equal(t, []string{"13->42", "23->42", "42->23"}, journeyIds(journeys))
+
+	err = SortJourneys(journeys, SortByPointCount)
+	require.NoError(t, err)
+	require.Equal(t, []string{"23->42", "42->23", "13->42"}, journeyIds(journeys))
+
+	err = SortJourneys(journeys, SortByDiscoveredAt)
+	require.NoError(t, err)
+	require.Equal(t, []string{"13->42", "23->42", "42->23"}, journeyIds(journeys))
+}
+
+func TestSortJourneysUnknownOrder(t *testing.T) {
+	journeys := []Journey{{Id: "42->23"}, {Id: "13->42"}}
+
+	err := SortJourneys(journeys, "foo")
+	require.Error(t, err)
+	require.Equal(t, "Unknown sort order \"foo\"", err.Error())
+
+	// no change
+	require.Equal(t, []string{"42->23", "13->42"}, journeyIds(journeys))
+}
+
 func TestStartJourney(t *testing.T) {
+	patchNow, err := mpatch.PatchMethod(time.Now, mockNow)
+	require.NoError(t, err)
+	defer patchNow.Unpatch()
+
 	mockQueue := &queue.MockQueue{}
-	cache := NewCache(nil, mockQueue)
+	cache := NewCache(Config{}, nil, mockQueue)
 
-	expectedMsg := queue.NewJourney{StartId: 23, DestinationId: 42}
+	expectedMsg := queue.NewJourney{StartId: 23, DestinationId: 42, DiscoveredAt: mockNow()}
 	mockQueue.On("Push", expectedMsg).Return()
 
 	// first characterJourney for the journey
@@ -47,7 +111,7 @@ func TestStartJourney(t *testing.T) {
 	// add characterJourney and journey
 	expectedCharacterJourney1 := &characterJourney{characterId: "character1", startId: 23, destinationId: 42}
 	expectedJourney1 := &journey{
-		startId: 23, destinationId: 42, points: nil, isFullyMapped: false,
+		startId: 23, destinationId: 42, points: nil, isFullyMapped: false, discoveredAt: mockNow(),
 	}
 	require.Equal(t, 1, len(cache.characterJourneys))
 	require.Equal(t, expectedCharacterJourney1, cache.characterJourneys["character1"])
@@ -70,17 +134,21 @@ func TestStartJourney(t *testing.T) {
 }
 
 func TestStartJourneySameShip(t *testing.T) {
+	patchNow, err := mpatch.PatchMethod(time.Now, mockNow)
+	require.NoError(t, err)
+	defer patchNow.Unpatch()
+
 	mockQueue := &queue.MockQueue{}
-	cache := NewCache(nil, mockQueue)
+	cache := NewCache(Config{}, nil, mockQueue)
 
 	// first characterJourney of a character
-	expectedMsg1 := queue.NewJourney{StartId: 23, DestinationId: 42}
+	expectedMsg1 := queue.NewJourney{StartId: 23, DestinationId: 42, DiscoveredAt: mockNow()}
 	mockQueue.On("Push", expectedMsg1).Return()
 	cache.StartJourney("character1", 23, 42)
 
 	expectedCharacterJourney := &characterJourney{characterId: "character1", startId: 23, destinationId: 42}
 	expectedJourney1 := &journey{
-		startId: 23, destinationId: 42, points: nil, isFullyMapped: false,
+		startId: 23, destinationId: 42, points: nil, isFullyMapped: false, discoveredAt: mockNow(),
 	}
 	require.Equal(t, 1, len(cache.characterJourneys))
 	require.Equal(t, expectedCharacterJourney, cache.characterJourneys["character1"])
@@ -88,14 +156,14 @@ func TestStartJourneySameShip(t *testing.T) {
 	require.Equal(t, expectedJourney1, cache.journeys["23->42"])
 
 	// same character starts another route
-	expectedMsg2 := queue.NewJourney{StartId: 42, DestinationId: 23}
+	expectedMsg2 := queue.NewJourney{StartId: 42, DestinationId: 23, DiscoveredAt: mockNow()}
 	mockQueue.On("Push", expectedMsg2).Return()
 	cache.StartJourney("character1", 42, 23)
 
 	// just update entry (not creating new characterJourney) and create new journey
 	expectedCharacterJourneyUpdated := &characterJourney{characterId: "character1", startId: 42, destinationId: 23}
 	expectedJourney2 := &journey{
-		startId: 42, destinationId: 23, points: nil, isFullyMapped: false,
+		startId: 42, destinationId: 23, points: nil, isFullyMapped: false, discoveredAt: mockNow(),
 	}
 	require.Equal(t, 1, len(cache.characterJourneys))
 	require.Equal(t, expectedCharacterJourneyUpdated, cache.characterJourneys["character1"])
@@ -110,7 +178,7 @@ func TestStartJourneySameShip(t *testing.T) {
 
 func TestMovement(t *testing.T) {
 	mockQueue := &queue.MockQueue{}
-	cache := NewCache(nil, mockQueue)
+	cache := NewCache(Config{}, nil, mockQueue)
 
 	cache.characterJourneys["character1"] = &characterJourney{characterId: "character1", startId: 23, destinationId: 42}
 	cache.characterJourneys["character2"] = &characterJourney{characterId: "character2", startId: 13, destinationId: 42}
@@ -121,8 +189,11 @@ func TestMovement(t *testing.T) {
 		startId: 13, destinationId: 42, points: nil, isFullyMapped: false,
 	}
 
-	expectedMsg1 := queue.NewLocation{StartId: 23, DestinationId: 42, X: 1, Y: 2}
+	expectedMsg1 := queue.NewLocation{StartId: 23, DestinationId: 42, X: 1, Y: 2, Seq: 0}
+	// the first point of a character changes the characters of the stats
+	expectedStats := queue.JourneyStats{StartId: 23, DestinationId: 42, PointCount: 1, MinX: 1, MinY: 2, MaxX: 1, MaxY: 2, Characters: 1}
 	mockQueue.On("Push", expectedMsg1).Return()
+	mockQueue.On("Push", expectedStats).Return()
 	err := cache.Movement("character1", 1, 2)
 	require.NoError(t, err)
 
@@ -130,7 +201,7 @@ func TestMovement(t *testing.T) {
 	require.Equal(t, []Point{{X: 1, Y: 2}}, cache.journeys["23->42"].points)
 	require.Nil(t, cache.journeys["13->42"].points)
 
-	expectedMsg2 := queue.NewLocation{StartId: 23, DestinationId: 42, X: 2, Y: 2}
+	expectedMsg2 := queue.NewLocation{StartId: 23, DestinationId: 42, X: 2, Y: 2, Seq: 1}
 	mockQueue.On("Push", expectedMsg2).Return()
 	err = cache.Movement("character1", 2, 2)
 	require.NoError(t, err)
@@ -139,14 +210,43 @@ func TestMovement(t *testing.T) {
 	require.Equal(t, []Point{{X: 1, Y: 2}, {X: 2, Y: 2}}, cache.journeys["23->42"].points)
 	require.Nil(t, cache.journeys["13->42"].points)
 
-	// both journeys are pushed into the queue
+	// both journeys are pushed into the queue, the stats only once
 	mockQueue.AssertCalled(t, "Push", expectedMsg1)
+	mockQueue.AssertCalled(t, "Push", expectedStats)
 	mockQueue.AssertCalled(t, "Push", expectedMsg2)
-	mockQueue.AssertNumberOfCalls(t, "Push", 2)
+	mockQueue.AssertNumberOfCalls(t, "Push", 3)
+}
+
+func TestMovements(t *testing.T) {
+	mockQueue := &queue.MockQueue{}
+	cache := NewCache(Config{}, nil, mockQueue)
+
+	cache.characterJourneys["character1"] = &characterJourney{characterId: "character1", startId: 23, destinationId: 42}
+	cache.journeys["23->42"] = &journey{
+		startId: 23, destinationId: 42, points: []Point{{X: 1, Y: 2}}, isFullyMapped: false,
+	}
+
+	expectedMsg1 := queue.NewLocation{StartId: 23, DestinationId: 42, X: 2, Y: 2, Seq: 1}
+	expectedMsg2 := queue.NewLocation{StartId: 23, DestinationId: 42, X: 3, Y: 2, Seq: 2}
+	mockQueue.On("Push", expectedMsg1).Return()
+	mockQueue.On("Push", expectedMsg2).Return()
+	mockQueue.On("Push", mock.AnythingOfType("queue.JourneyStats")).Return()
+	errs := cache.Movements("character1", []Point{{X: 2, Y: 2}, {X: 1, Y: 2}, {X: 3, Y: 2}})
+	require.Equal(t, []error{nil, nil, nil}, errs)
+
+	// points appended in order, existing point ignored
+	require.Equal(t, []Point{{X: 1, Y: 2}, {X: 2, Y: 2}, {X: 3, Y: 2}}, cache.journeys["23->42"].points)
+	mockQueue.AssertNumberOfCalls(t, "Push", 3)
+
+	// an error per point
+	errs = cache.Movements("character2", []Point{{X: 2, Y: 2}, {X: 4, Y: 2}})
+	require.Equal(t, 2, len(errs))
+	require.Equal(t, "No active characterJourney for character character2", errs[0].Error())
+	require.Equal(t, "No active characterJourney for character character2", errs[1].Error())
 }
 
 func TestMovementSamePoint(t *testing.T) {
-	cache := NewCache(nil, nil)
+	cache := NewCache(Config{}, nil, nil)
 
 	cache.characterJourneys["character1"] = &characterJourney{characterId: "character1", startId: 23, destinationId: 42}
 	expectedPoints := []Point{{X: 1, Y: 2}}
@@ -163,7 +263,7 @@ func TestMovementSamePoint(t *testing.T) {
 }
 
 func TestMovementErrorMissingVoyage(t *testing.T) {
-	cache := NewCache(nil, nil)
+	cache := NewCache(Config{}, nil, nil)
 
 	cache.characterJourneys["character1"] = &characterJourney{characterId: "character1", startId: 23, destinationId: 42}
 	expectedPoints := []Point{{X: 1, Y: 2}}
@@ -181,7 +281,7 @@ func TestMovementErrorMissingVoyage(t *testing.T) {
 }
 
 func TestMovementErrorMissingJourney(t *testing.T) {
-	cache := NewCache(nil, nil)
+	cache := NewCache(Config{}, nil, nil)
 
 	cache.characterJourneys["character1"] = &characterJourney{characterId: "character1", startId: 23, destinationId: 42}
 	expectedPoints := []Point{{X: 1, Y: 2}}
@@ -201,19 +301,23 @@ func TestMovementErrorMissingJourney(t *testing.T) {
 func TestReachedDestination(t *testing.T) {
 	mockMetrics := &metrics.MockMetrics{}
 	mockQueue := &queue.MockQueue{}
-	cache := NewCache(mockMetrics, mockQueue)
+	cache := NewCache(Config{}, mockMetrics, mockQueue)
 
 	cache.characterJourneys["character1"] = &characterJourney{characterId: "character1", startId: 23, destinationId: 42}
 	cache.characterJourneys["character2"] = &characterJourney{characterId: "character2", startId: 13, destinationId: 42}
-	cache.journeys["23->42"] = &journey{
-		startId: 23, destinationId: 42, points: []Point{{X: 1, Y: 2}}, isFullyMapped: false,
-	}
+	cache.journeys["23->42"] = &journey{startId: 23, destinationId: 42}
+	cache.journeys["23->42"].checkPosition("character1", 1, 2, false)
+	cache.journeys["23->42"].checkPosition("character1", 4, 6, false)
 	cache.journeys["13->42"] = &journey{
 		startId: 13, destinationId: 42, points: []Point{{X: 11, Y: 12}}, isFullyMapped: false,
 	}
 
 	expectedMsg := queue.JourneyFullyMapped{StartId: 23, DestinationId: 42}
 	mockQueue.On("Push", expectedMsg).Return()
+	expectedStats := queue.JourneyStats{
+		StartId: 23, DestinationId: 42, Length: 5, PointCount: 2, MinX: 1, MinY: 2, MaxX: 4, MaxY: 6, Characters: 1,
+	}
+	mockQueue.On("Push", expectedStats).Return()
 	mockMetrics.On("LogJourney").Return()
 	err := cache.ReachedDestination("character1", 42)
 	require.NoError(t, err)
@@ -229,15 +333,103 @@ func TestReachedDestination(t *testing.T) {
 	// no change
 	require.True(t, cache.journeys["23->42"].isFullyMapped)
 
-	// only one msg is pushed into the queue
+	// only one msg and the final stats are pushed into the queue
 	mockQueue.AssertCalled(t, "Push", expectedMsg)
-	mockQueue.AssertNumberOfCalls(t, "Push", 1)
+	mockQueue.AssertCalled(t, "Push", expectedStats)
+	mockQueue.AssertNumberOfCalls(t, "Push", 2)
 	// route is only once counted
 	mockMetrics.AssertNumberOfCalls(t, "LogJourney", 1)
 }
 
+func TestUndirected(t *testing.T) {
+	patchNow, err := mpatch.PatchMethod(time.Now, mockNow)
+	require.NoError(t, err)
+	defer patchNow.Unpatch()
+
+	mockMetrics := &metrics.MockMetrics{}
+	mockQueue := &queue.MockQueue{}
+	cache := NewCache(Config{Undirected: true}, mockMetrics, mockQueue)
+	mockQueue.On("Push", mock.Anything).Return()
+	mockMetrics.On("LogJourney").Return()
+
+	// both directions share the route starting at the lower location id
+	cache.StartJourney("character1", 42, 23)
+	cache.StartJourney("character2", 23, 42)
+	require.Equal(t, 1, len(cache.journeys))
+	require.NotNil(t, cache.journeys["23->42"])
+
+	// walking in reverse prepends the points
+	require.NoError(t, cache.Movement("character1", 5, 5))
+	require.NoError(t, cache.Movement("character1", 4, 4))
+	require.NoError(t, cache.Movement("character2", 6, 6))
+
+	route := cache.journeys["23->42"]
+	require.Equal(t, []Point{{X: 4, Y: 4}, {X: 5, Y: 5}, {X: 6, Y: 6}}, route.points)
+	require.Equal(t, -1, route.firstSeq)
+	require.InDelta(t, 2*math.Sqrt2, route.stats.Length, 1e-9)
+	require.Equal(t, BoundingBox{MinX: 4, MinY: 4, MaxX: 6, MaxY: 6}, route.stats.BoundingBox)
+	require.InDelta(t, 1, route.stats.Directness, 1e-9)
+	journeys := cache.GetUniqueJourneys()
+	require.Equal(t, 1, len(journeys))
+	require.True(t, journeys[0].Undirected)
+	require.Equal(t, -1, journeys[0].FirstSeq)
+
+	// the walk of character1 becomes the path
+	require.NoError(t, cache.ReachedDestination("character1", 23))
+	require.True(t, route.isFullyMapped)
+	require.Equal(t, []Point{{X: 4, Y: 4}, {X: 5, Y: 5}}, route.points)
+	require.Equal(t, 0, route.firstSeq)
+	require.InDelta(t, math.Sqrt2, route.stats.Length, 1e-9)
+	require.Equal(t, 2, route.stats.Characters)
+
+	// the messages for the store only know the route
+	require.Equal(
+		t,
+		[]interface{}{
+			queue.NewJourney{StartId: 23, DestinationId: 42, DiscoveredAt: mockNow()},
+			queue.NewLocation{StartId: 23, DestinationId: 42, X: 5, Y: 5, Seq: 0},
+			queue.JourneyStats{StartId: 23, DestinationId: 42, PointCount: 1, MinX: 5, MinY: 5, MaxX: 5, MaxY: 5, Characters: 1},
+			queue.NewLocation{StartId: 23, DestinationId: 42, X: 4, Y: 4, Seq: -1},
+			queue.NewLocation{StartId: 23, DestinationId: 42, X: 6, Y: 6, Seq: 1},
+			queue.JourneyStats{
+				StartId: 23, DestinationId: 42, Length: 2 * math.Sqrt2, PointCount: 3, MinX: 4, MinY: 4, MaxX: 6, MaxY: 6, Characters: 2,
+			},
+			queue.JourneyFullyMapped{StartId: 23, DestinationId: 42},
+			queue.JourneyPath{StartId: 23, DestinationId: 42, Points: []queue.Location{{X: 4, Y: 4}, {X: 5, Y: 5}}},
+			route.statsMessage(),
+		},
+		pushed(mockQueue),
+	)
+}
+
+func TestWarmUpUndirected(t *testing.T) {
+	cache := NewCache(Config{Undirected: true}, nil, nil)
+
+	cache.WarmUp([]Journey{
+		{StartId: 42, DestinationId: 23, Points: []Point{{X: 1, Y: 1}, {X: 2, Y: 2}}, FirstSeq: 3},
+		{StartId: 23, DestinationId: 42, Points: []Point{{X: 9, Y: 9}}},
+	})
+
+	// the first of both directions wins, reversed to start at the lower location id
+	require.Equal(t, 1, len(cache.journeys))
+	require.Equal(t, []Point{{X: 2, Y: 2}, {X: 1, Y: 1}}, cache.journeys["23->42"].points)
+	require.Equal(t, 0, cache.journeys["23->42"].firstSeq)
+}
+
+func TestJourneyReversed(t *testing.T) {
+	journey := Journey{Id: "23->42", StartId: 23, DestinationId: 42, Points: []Point{{X: 1, Y: 1}, {X: 2, Y: 2}}}
+
+	require.Equal(
+		t,
+		Journey{Id: "42->23", StartId: 42, DestinationId: 23, Points: []Point{{X: 2, Y: 2}, {X: 1, Y: 1}}},
+		journey.Reversed(),
+	)
+	// the points are copied
+	require.Equal(t, []Point{{X: 1, Y: 1}, {X: 2, Y: 2}}, journey.Points)
+}
+
 func TestReachedDestinationErrorMissingVoyage(t *testing.T) {
-	cache := NewCache(nil, nil)
+	cache := NewCache(Config{}, nil, nil)
 
 	cache.characterJourneys["character1"] = &characterJourney{characterId: "character1", startId: 23, destinationId: 42}
 	expectedPoints := []Point{{X: 1, Y: 2}}
@@ -255,7 +447,7 @@ func TestReachedDestinationErrorMissingVoyage(t *testing.T) {
 }
 
 func TestReachedDestinationErrorMissingJourney(t *testing.T) {
-	cache := NewCache(nil, nil)
+	cache := NewCache(Config{}, nil, nil)
 
 	cache.characterJourneys["character1"] = &characterJourney{characterId: "character1", startId: 23, destinationId: 42}
 	expectedPoints := []Point{{X: 1, Y: 2}}
@@ -272,8 +464,70 @@ func TestReachedDestinationErrorMissingJourney(t *testing.T) {
 	require.Equal(t, expectedPoints, cache.journeys["42->23"].points)
 }
 
+func TestWarmUp(t *testing.T) {
+	patchNow, err := mpatch.PatchMethod(time.Now, mockNow)
+	require.NoError(t, err)
+	defer patchNow.Unpatch()
+
+	mockMetrics := &metrics.MockMetrics{}
+	cache := NewCache(Config{}, mockMetrics, nil)
+	require.False(t, cache.IsWarmedUp())
+
+	// already discovered since the start
+	existing := &journey{startId: 13, destinationId: 42, points: []Point{{X: 5, Y: 5}}}
+	cache.journeys["13->42"] = existing
+
+	mockMetrics.On("LogJourney").Return()
+	cache.WarmUp([]Journey{
+		{
+			StartId:       23,
+			DestinationId: 42,
+			Points:        []Point{{X: 1, Y: 2}, {X: 4, Y: 6}},
+			FullyMapped:   true,
+			Stats:         Stats{Length: 1, Characters: 3},
+		},
+		{StartId: 42, DestinationId: 23},
+		{StartId: 13, DestinationId: 42, Points: []Point{{X: 1, Y: 1}}},
+	})
+
+	require.True(t, cache.IsWarmedUp())
+	require.Equal(t, 3, len(cache.journeys))
+	require.Equal(
+		t,
+		&journey{
+			startId:       23,
+			destinationId: 42,
+			points:        []Point{{X: 1, Y: 2}, {X: 4, Y: 6}},
+			isFullyMapped: true,
+			discoveredAt:  mockNow(),
+			// computed from the points, only the characters are loaded
+			stats: Stats{
+				Length:      5,
+				PointCount:  2,
+				BoundingBox: BoundingBox{MinX: 1, MinY: 2, MaxX: 4, MaxY: 6},
+				Directness:  1,
+				Characters:  3,
+			},
+		},
+		cache.journeys["23->42"],
+	)
+	require.Equal(
+		t,
+		&journey{startId: 42, destinationId: 23, points: nil, isFullyMapped: false, discoveredAt: mockNow()},
+		cache.journeys["42->23"],
+	)
+	require.Same(t, existing, cache.journeys["13->42"])
+
+	// fully mapped journeys are counted
+	mockMetrics.AssertNumberOfCalls(t, "LogJourney", 1)
+}
+
 func TestCheckJourneyNew(t *testing.T) {
-	cache := NewCache(nil, nil)
+	patchNow, err := mpatch.PatchMethod(time.Now, mockNow)
+	require.NoError(t, err)
+	defer patchNow.Unpatch()
+
+	cache := NewCache(Config{}, nil, nil)
 
 	cache.journeys["23->42"] = &journey{
 		startId: 23, destinationId: 42, points: nil, isFullyMapped: false,
@@ -286,13 +540,13 @@ func TestCheckJourneyNew(t *testing.T) {
 	require.Equal(t, 2, len(cache.journeys))
 	require.Equal(
 		t,
-		journey{startId: 13, destinationId: 42, points: nil, isFullyMapped: false},
+		journey{startId: 13, destinationId: 42, points: nil, isFullyMapped: false, discoveredAt: mockNow()},
 		*cache.journeys["13->42"],
 	)
 }
 
 func TestCheckJourneyExisting(t *testing.T) {
-	cache := NewCache(nil, nil)
+	cache := NewCache(Config{}, nil, nil)
 
 	cache.journeys["23->42"] = &journey{
 		startId: 23, destinationId: 42, points: nil, isFullyMapped: false,
@@ -311,20 +565,47 @@ func TestCheckPositionNew(t *testing.T) {
 	}
 
 	// new route point
-	require.True(t, route.checkPosition(2, 2))
+	require.True(t, route.checkPosition("character1", 2, 2, false))
 
 	// journey is updated
 	require.Equal(t, 2, len(route.points))
 	require.Equal(t, []Point{{X: 1, Y: 2}, {X: 2, Y: 2}}, route.points)
 }
 
+func TestCheckPositionStats(t *testing.T) {
+	route := &journey{startId: 23, destinationId: 42}
+
+	require.True(t, route.checkPosition("character1", 1, 1, false))
+	require.Equal(
+		t,
+		Stats{PointCount: 1, BoundingBox: BoundingBox{MinX: 1, MinY: 1, MaxX: 1, MaxY: 1}, Characters: 1},
+		route.stats,
+	)
+
+	// a detour east and back north-west
+	require.True(t, route.checkPosition("character1", 4, 5, false))
+	require.True(t, route.checkPosition("character2", 1, 9, false))
+	require.False(t, route.checkPosition("character3", 1, 9, false))
+	require.Equal(
+		t,
+		Stats{
+			Length:      10,
+			PointCount:  3,
+			BoundingBox: BoundingBox{MinX: 1, MinY: 1, MaxX: 4, MaxY: 9},
+			Directness:  0.8,
+			Characters:  2,
+		},
+		route.stats,
+	)
+}
+
 func TestCheckPositionExisting(t *testing.T) {
 	route := &journey{
 		startId: 23, destinationId: 42, points: []Point{{X: 1, Y: 2}}, isFullyMapped: false,
 	}
 
 	// existing route point
-	require.False(t, route.checkPosition(1, 2))
+	require.False(t, route.checkPosition("character1", 1, 2, false))
 
 	// no change
 	require.Equal(t, 1, len(route.points))
@@ -337,9 +618,32 @@ func TestCheckPositionAlreadyMapped(t *testing.T) {
 	}
 
 	// point ignored
-	require.False(t, route.checkPosition(2, 2))
+	require.False(t, route.checkPosition("character1", 2, 2, false))
 
 	// no change
 	require.Equal(t, 1, len(route.points))
 	require.Equal(t, []Point{{X: 1, Y: 2}}, route.points)
 }
+
+// pushed returns the messages pushed to a mock queue in order
+func pushed(mockQueue *queue.MockQueue) []interface{} {
+	var msgs []interface{}
+	for _, call := range mockQueue.Calls {
+		if call.Method == "Push" {
+			msgs = append(msgs, call.Arguments.Get(0))
+		}
+	}
+	return msgs
+}
+
+func mockNow() time.Time {
+	return time.Date(2021, 01, 01, 00, 00, 00, 0, time.UTC)
+}
+
+func journeyIds(journeys []Journey) []string {
+	ids := make([]string, len(journeys))
+	for i, journey := range journeys {
+		ids[i] = journey.Id
+	}
+	return ids
+}
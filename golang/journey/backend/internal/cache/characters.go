@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"sort"
+	"time"
+)
+
+// Character is the last known state of a character
+type Character struct {
+	Id string `json:"id"`
+	// Position of the last accepted movement, nil before the first one
+	Position *Point `json:"position"`
+	// Journey last started by the character, nil before the first start
+	Journey  *ActiveJourney `json:"journey"`
+	LastSeen time.Time      `json:"lastSeen"`
+	// recent accepted movements on the journey for the progress, see RECENT_MOVEMENTS
+	recent []timedPoint
+}
+
+type timedPoint struct {
+	point Point
+	at    time.Time
+}
+
+// ActiveJourney is the journey of a character as walked, also in undirected mode
+type ActiveJourney struct {
+	Id            string `json:"id"`
+	StartId       uint16 `json:"startId"`
+	DestinationId uint16 `json:"destinationId"`
+	// Arrived is set once the character reached the destination
+	Arrived bool `json:"arrived"`
+}
+
+func (j *characterJourney) active(arrived bool) *ActiveJourney {
+	return &ActiveJourney{
+		Id:            JourneyId(j.startId, j.destinationId),
+		StartId:       j.startId,
+		DestinationId: j.destinationId,
+		Arrived:       arrived,
+	}
+}
+
+// seen returns the state of the character, updating when it was last seen
+func (c *cache) seen(characterId string) *Character {
+	character := c.characters[characterId]
+	if character == nil {
+		character = &Character{Id: characterId}
+		c.characters[characterId] = character
+	}
+	character.LastSeen = time.Now()
+	return character
+}
+
+// moved records an accepted movement of the character. Batched points keep the time of the
+// previous one, they were walked before the batch arrived.
+func (c *cache) moved(characterId string, point Point, batched bool) {
+	character := c.seen(characterId)
+	character.Position = &point
+	at := character.LastSeen
+	if batched && len(character.recent) > 0 {
+		at = character.recent[len(character.recent)-1].at
+	}
+	character.recent = append(character.recent, timedPoint{point: point, at: at})
+	if len(character.recent) > RECENT_MOVEMENTS {
+		character.recent = character.recent[1:]
+	}
+}
+
+// copy keeps the state of the cache out of reach of the caller
+func (character *Character) copy() Character {
+	copied := *character
+	copied.recent = nil
+	if character.Position != nil {
+		position := *character.Position
+		copied.Position = &position
+	}
+	if character.Journey != nil {
+		journey := *character.Journey
+		copied.Journey = &journey
+	}
+	return copied
+}
+
+// GetCharacters returns all characters seen since the start ordered by id
+func (c *cache) GetCharacters() []Character {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	characters := make([]Character, 0, len(c.characters))
+	for _, character := range c.characters {
+		characters = append(characters, character.copy())
+	}
+	sort.Slice(characters, func(i, j int) bool { return characters[i].Id < characters[j].Id })
+	return characters
+}
+
+func (c *cache) GetCharacter(characterId string) (Character, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	character := c.characters[characterId]
+	if character == nil {
+		return Character{}, false
+	}
+	return character.copy(), true
+}
@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"fiurgeist/journey/internal/metrics"
+	"fiurgeist/journey/internal/queue"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/undefinedlabs/go-mpatch"
+	"testing"
+	"time"
+)
+
+func TestCharacters(t *testing.T) {
+	patchNow, err := mpatch.PatchMethod(time.Now, mockNow)
+	require.NoError(t, err)
+	defer patchNow.Unpatch()
+
+	mockQueue := &queue.MockQueue{}
+	mockQueue.On("Push", mock.Anything).Return()
+	mockMetrics := &metrics.MockMetrics{}
+	mockMetrics.On("LogJourney").Return()
+	cache := NewCache(Config{World: World{Id: "default", Bounds: BoundingBox{MaxX: 99, MaxY: 99}}}, mockMetrics, mockQueue)
+
+	_, ok := cache.GetCharacter("character1")
+	require.False(t, ok)
+	require.Equal(t, []Character{}, cache.GetCharacters())
+
+	cache.StartJourney("character2", 42, 23)
+	cache.StartJourney("character1", 23, 42)
+	require.NoError(t, cache.Movement("character1", 1, 2))
+	// rejected movements don't change the position
+	require.Error(t, cache.Movement("character1", 100, 2))
+
+	character, ok := cache.GetCharacter("character1")
+	require.True(t, ok)
+	require.Equal(
+		t,
+		Character{
+			Id:       "character1",
+			Position: &Point{X: 1, Y: 2},
+			Journey:  &ActiveJourney{Id: "23->42", StartId: 23, DestinationId: 42},
+			LastSeen: mockNow(),
+		},
+		character,
+	)
+
+	require.NoError(t, cache.ReachedDestination("character1", 42))
+	characters := cache.GetCharacters()
+	require.Equal(t, 2, len(characters))
+	require.Equal(t, "character1", characters[0].Id)
+	require.True(t, characters[0].Journey.Arrived)
+	require.Equal(t, Character{Id: "character2", Journey: &ActiveJourney{Id: "42->23", StartId: 42, DestinationId: 23}, LastSeen: mockNow()}, characters[1])
+
+	// the caller gets a copy
+	characters[0].Position.X = 5
+	character, _ = cache.GetCharacter("character1")
+	require.Equal(t, &Point{X: 1, Y: 2}, character.Position)
+}
@@ -0,0 +1,260 @@
+package cache
+
+import (
+	"math"
+	"sort"
+)
+
+// Traversal are the points of a journey walked by a single character, ordered from the start
+// to the destination of the journey
+type Traversal struct {
+	CharacterId string  `json:"characterId"`
+	Points      []Point `json:"points"`
+}
+
+// Traversals of a journey and the consensus derived from them
+type Traversals struct {
+	Id         string      `json:"id"`
+	Traversals []Traversal `json:"traversals"`
+	// Consensus is the medoid of the traversals and the points stored by previous runs while the
+	// journey is mapped, the points of the journey once it is fully mapped
+	Consensus []Point `json:"consensus"`
+}
+
+// walk holds the points of a traversal together with the distance of each point to the closest
+// point of every other walk of the journey. The distances are updated as the points arrive, so
+// a new point costs a pass over the points of the other walks instead of comparing all walks.
+type walk struct {
+	points []Point
+	// distance of each point to the closest point of another walk, in the order of the points
+	closest map[*walk][]float64
+	// sum of the closest distances to another walk
+	sums map[*walk]float64
+}
+
+func newWalk() *walk {
+	return &walk{closest: make(map[*walk][]float64), sums: make(map[*walk]float64)}
+}
+
+// consensusEpsilon keeps the ties of the medoid stable against rounding of the updated sums
+const consensusEpsilon = 1e-9
+
+// addTraversalPoint records the point of a character, in front if walking in reverse
+func (t *journey) addTraversalPoint(characterId string, point Point, reversed bool) {
+	if t.traversals == nil {
+		t.traversals = make(map[string]*walk)
+	}
+	w := t.traversals[characterId]
+	if w == nil {
+		w = newWalk()
+		t.traversals[characterId] = w
+	}
+	for _, known := range w.points {
+		if known == point {
+			return
+		}
+	}
+	t.addWalkPoint(w, point, reversed)
+	t.updateConsensus()
+}
+
+func (t *journey) removeTraversal(characterId string) {
+	w, ok := t.traversals[characterId]
+	if !ok {
+		return
+	}
+	delete(t.traversals, characterId)
+	for _, other := range t.walks() {
+		delete(other.closest, w)
+		delete(other.sums, w)
+	}
+	t.updateConsensus()
+}
+
+// walks returns the stored points followed by the traversals ordered by character id
+func (t *journey) walks() []*walk {
+	ids := make([]string, 0, len(t.traversals))
+	for characterId := range t.traversals {
+		ids = append(ids, characterId)
+	}
+	sort.Strings(ids)
+
+	walks := make([]*walk, 0, len(ids)+1)
+	if t.stored != nil {
+		walks = append(walks, t.stored)
+	}
+	for _, characterId := range ids {
+		walks = append(walks, t.traversals[characterId])
+	}
+	return walks
+}
+
+// addWalkPoint adds a point to the walk and updates the distances between the walk and the
+// others of the journey
+func (t *journey) addWalkPoint(w *walk, point Point, front bool) {
+	for _, other := range t.walks() {
+		if other == w || len(other.points) == 0 {
+			continue
+		}
+		closest := math.Inf(1)
+		for _, q := range other.points {
+			closest = math.Min(closest, distance(point, q))
+		}
+		if front {
+			w.closest[other] = append([]float64{closest}, w.closest[other]...)
+		} else {
+			w.closest[other] = append(w.closest[other], closest)
+		}
+		w.sums[other] += closest
+
+		// the points of the other walk may be closer to the new point
+		if len(w.points) == 0 {
+			distances := make([]float64, len(other.points))
+			sum := 0.0
+			for i, q := range other.points {
+				distances[i] = distance(q, point)
+				sum += distances[i]
+			}
+			other.closest[w], other.sums[w] = distances, sum
+			continue
+		}
+		distances := other.closest[w]
+		for i, q := range other.points {
+			if d := distance(q, point); d < distances[i] {
+				other.sums[w] -= distances[i] - d
+				distances[i] = d
+			}
+		}
+	}
+	if front {
+		w.points = append([]Point{point}, w.points...)
+	} else {
+		w.points = append(w.points, point)
+	}
+}
+
+// updateConsensus picks the medoid of the walks like Medoid, from the kept distances
+func (t *journey) updateConsensus() {
+	t.consensus = nil
+	best := math.Inf(1)
+	walks := t.walks()
+	for _, candidate := range walks {
+		if len(candidate.points) == 0 {
+			continue
+		}
+		total := 0.0
+		for _, other := range walks {
+			if other != candidate && len(other.points) > 0 {
+				total += other.sums[candidate] / float64(len(other.points))
+			}
+		}
+		if total < best-consensusEpsilon {
+			best = total
+			t.consensus = candidate.points
+		}
+	}
+}
+
+// path returns the points to serve for the journey: the consensus while it is mapped, the
+// points once it is fully mapped or nobody walked it
+func (t *journey) path() []Point {
+	if t.isFullyMapped || t.consensus == nil {
+		return t.points
+	}
+	return t.consensus
+}
+
+// finalPath is the path of the journey once it is fully mapped: the consensus, unless it has
+// fewer points than the ones stored by previous runs, whose walks are gone
+func (t *journey) finalPath() []Point {
+	path := t.path()
+	if t.stored != nil && len(path) < len(t.stored.points) {
+		return t.stored.points
+	}
+	return path
+}
+
+// setPath replaces the points of the journey, the stats follow from the new points except
+// for the characters
+func (t *journey) setPath(points []Point) {
+	characters := t.stats.Characters
+	t.points = nil
+	t.stats = Stats{}
+	t.firstSeq = 0
+	for _, point := range points {
+		t.addPoint(point)
+	}
+	t.stats.Characters = characters
+}
+
+// getTraversals copies the traversals ordered by character id
+func (t *journey) getTraversals() []Traversal {
+	traversals := make([]Traversal, 0, len(t.traversals))
+	for characterId, w := range t.traversals {
+		traversals = append(traversals, Traversal{CharacterId: characterId, Points: append([]Point(nil), w.points...)})
+	}
+	sort.Slice(traversals, func(i, j int) bool { return traversals[i].CharacterId < traversals[j].CharacterId })
+	return traversals
+}
+
+// Medoid returns the points of the traversal with the least total distance of all others to
+// it. The distance of a traversal to a candidate is the mean distance of its points to the
+// closest point of the candidate, so it doesn't depend on how densely they were recorded. A
+// walk in progress covers only the start of the route: a complete candidate explains it well,
+// while its few points don't explain the complete one. Ties go to the first.
+func Medoid(traversals []Traversal) []Point {
+	var medoid []Point
+	best := math.Inf(1)
+	for i, candidate := range traversals {
+		if len(candidate.Points) == 0 {
+			continue
+		}
+		total := 0.0
+		for j, other := range traversals {
+			if i != j && len(other.Points) > 0 {
+				total += meanClosest(other.Points, candidate.Points)
+			}
+		}
+		if total < best-consensusEpsilon {
+			best = total
+			medoid = candidate.Points
+		}
+	}
+	return medoid
+}
+
+// meanClosest is the mean distance of the points of a to their closest point of b
+func meanClosest(a, b []Point) float64 {
+	sum := 0.0
+	for _, p := range a {
+		closest := math.Inf(1)
+		for _, q := range b {
+			closest = math.Min(closest, distance(p, q))
+		}
+		sum += closest
+	}
+	return sum / float64(len(a))
+}
+
+func samePoints(a, b []Point) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func reversePoints(points []Point) []Point {
+	if points == nil {
+		return nil
+	}
+	reversed := make([]Point, len(points))
+	for i, point := range points {
+		reversed[len(points)-1-i] = point
+	}
+	return reversed
+}
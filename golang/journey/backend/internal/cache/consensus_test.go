@@ -0,0 +1,223 @@
+package cache
+
+import (
+	"fiurgeist/journey/internal/metrics"
+	"fiurgeist/journey/internal/queue"
+	"fmt"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"testing"
+)
+
+func TestMedoid(t *testing.T) {
+	straight := Traversal{CharacterId: "a", Points: []Point{{X: 0, Y: 0}, {X: 5, Y: 0}, {X: 10, Y: 0}}}
+	// between both others
+	near := Traversal{CharacterId: "b", Points: []Point{{X: 0, Y: 1}, {X: 5, Y: 2}, {X: 10, Y: 1}}}
+	detour := Traversal{CharacterId: "c", Points: []Point{{X: 0, Y: 2}, {X: 5, Y: 4}, {X: 10, Y: 2}}}
+
+	require.Equal(t, near.Points, Medoid([]Traversal{straight, near, detour}))
+	// ties go to the first
+	require.Equal(t, straight.Points, Medoid([]Traversal{straight, detour}))
+	require.Equal(t, detour.Points, Medoid([]Traversal{{CharacterId: "d"}, detour}))
+	require.Nil(t, Medoid(nil))
+}
+
+func TestGetTraversals(t *testing.T) {
+	mockQueue := &queue.MockQueue{}
+	mockQueue.On("Push", mock.Anything).Return()
+	cache := NewCache(Config{}, nil, mockQueue)
+
+	cache.StartJourney("character1", 23, 42)
+	cache.StartJourney("character2", 23, 42)
+	require.Equal(t, []error{nil, nil}, cache.Movements("character1", []Point{{X: 1, Y: 1}, {X: 2, Y: 2}}))
+	require.Equal(t, []error{nil, nil}, cache.Movements("character2", []Point{{X: 1, Y: 1}, {X: 3, Y: 1}}))
+
+	// the points of the journey interleave both walks
+	require.Equal(t, []Point{{X: 1, Y: 1}, {X: 2, Y: 2}, {X: 3, Y: 1}}, cache.journeys["23->42"].points)
+
+	traversals, ok := cache.GetTraversals(23, 42)
+	require.True(t, ok)
+	require.Equal(
+		t,
+		Traversals{
+			Id: "23->42",
+			Traversals: []Traversal{
+				{CharacterId: "character1", Points: []Point{{X: 1, Y: 1}, {X: 2, Y: 2}}},
+				{CharacterId: "character2", Points: []Point{{X: 1, Y: 1}, {X: 3, Y: 1}}},
+			},
+			Consensus: []Point{{X: 1, Y: 1}, {X: 2, Y: 2}},
+		},
+		traversals,
+	)
+
+	// only the latest walk of a character counts
+	cache.StartJourney("character2", 23, 42)
+	traversals, _ = cache.GetTraversals(23, 42)
+	require.Equal(t, 1, len(traversals.Traversals))
+
+	_, ok = cache.GetTraversals(42, 23)
+	require.False(t, ok)
+}
+
+func TestGetTraversalsUndirected(t *testing.T) {
+	mockQueue := &queue.MockQueue{}
+	mockQueue.On("Push", mock.Anything).Return()
+	cache := NewCache(Config{Undirected: true}, nil, mockQueue)
+
+	cache.StartJourney("character1", 42, 23)
+	require.Equal(t, []error{nil, nil}, cache.Movements("character1", []Point{{X: 1, Y: 1}, {X: 2, Y: 2}}))
+
+	// stored from 23 to 42
+	traversals, ok := cache.GetTraversals(23, 42)
+	require.True(t, ok)
+	require.Equal(t, []Point{{X: 2, Y: 2}, {X: 1, Y: 1}}, traversals.Consensus)
+
+	// as walked
+	traversals, ok = cache.GetTraversals(42, 23)
+	require.True(t, ok)
+	require.Equal(t, "42->23", traversals.Id)
+	require.Equal(t, []Traversal{{CharacterId: "character1", Points: []Point{{X: 1, Y: 1}, {X: 2, Y: 2}}}}, traversals.Traversals)
+	require.Equal(t, []Point{{X: 1, Y: 1}, {X: 2, Y: 2}}, traversals.Consensus)
+}
+
+func TestGetTraversalsWarmedUp(t *testing.T) {
+	cache := NewCache(Config{}, nil, nil)
+	cache.WarmUp([]Journey{{StartId: 23, DestinationId: 42, Points: []Point{{X: 1, Y: 1}}}})
+
+	// nobody walked it since the start
+	traversals, ok := cache.GetTraversals(23, 42)
+	require.True(t, ok)
+	require.Equal(t, Traversals{Id: "23->42", Traversals: []Traversal{}, Consensus: []Point{{X: 1, Y: 1}}}, traversals)
+}
+
+func TestConsensusPath(t *testing.T) {
+	mockQueue := &queue.MockQueue{}
+	mockQueue.On("Push", mock.Anything).Return()
+	mockMetrics := &metrics.MockMetrics{}
+	mockMetrics.On("LogJourney").Return()
+	cache := NewCache(Config{}, mockMetrics, mockQueue)
+
+	cache.StartJourney("character1", 23, 42)
+	cache.StartJourney("character2", 23, 42)
+	cache.StartJourney("character3", 23, 42)
+	cache.Movements("character1", []Point{{X: 0, Y: 0}, {X: 5, Y: 0}, {X: 10, Y: 0}})
+	cache.Movements("character2", []Point{{X: 0, Y: 1}, {X: 5, Y: 2}, {X: 10, Y: 1}})
+	cache.Movements("character3", []Point{{X: 0, Y: 2}, {X: 5, Y: 4}, {X: 10, Y: 2}})
+
+	// the journey serves the consensus instead of the interleaved points
+	require.Equal(t, 9, len(cache.journeys["23->42"].points))
+	journeys := cache.GetUniqueJourneys()
+	require.Equal(t, []Point{{X: 0, Y: 1}, {X: 5, Y: 2}, {X: 10, Y: 1}}, journeys[0].Points)
+	require.Equal(t, journeys[0].Points, cache.journeys["23->42"].consensus)
+
+	// kept up to date with every point
+	require.NoError(t, cache.Movement("character1", 10, 5))
+	traversals, _ := cache.GetTraversals(23, 42)
+	require.Equal(t, []Point{{X: 0, Y: 1}, {X: 5, Y: 2}, {X: 10, Y: 1}}, traversals.Consensus)
+
+	// the consensus replaces the points once the first character arrives, the walks are dropped
+	require.NoError(t, cache.ReachedDestination("character3", 42))
+	require.Equal(t, []Point{{X: 0, Y: 1}, {X: 5, Y: 2}, {X: 10, Y: 1}}, cache.journeys["23->42"].points)
+	require.Nil(t, cache.journeys["23->42"].traversals)
+	journeys = cache.GetUniqueJourneys()
+	require.Equal(t, []Point{{X: 0, Y: 1}, {X: 5, Y: 2}, {X: 10, Y: 1}}, journeys[0].Points)
+	require.Equal(t, 3, journeys[0].Stats.PointCount)
+	require.Equal(t, 3, journeys[0].Stats.Characters)
+	mockQueue.AssertCalled(t, "Push", queue.JourneyPath{
+		StartId:       23,
+		DestinationId: 42,
+		Points:        []queue.Location{{X: 0, Y: 1}, {X: 5, Y: 2}, {X: 10, Y: 1}},
+	})
+	traversals, _ = cache.GetTraversals(23, 42)
+	require.Equal(t, Traversals{Id: "23->42", Traversals: []Traversal{}, Consensus: journeys[0].Points}, traversals)
+}
+
+func TestConsensusWalksInProgress(t *testing.T) {
+	mockQueue := &queue.MockQueue{}
+	mockQueue.On("Push", mock.Anything).Return()
+	cache := NewCache(Config{}, nil, mockQueue)
+
+	var long []Point
+	for x := uint16(10); x <= 60; x++ {
+		long = append(long, Point{X: x, Y: 10})
+	}
+	cache.StartJourney("a", 23, 42)
+	cache.Movements("a", long)
+	// two characters which just started, close to each other
+	cache.StartJourney("b", 23, 42)
+	cache.StartJourney("c", 23, 42)
+	require.NoError(t, cache.Movement("b", 10, 10))
+	require.NoError(t, cache.Movement("c", 10, 11))
+
+	require.Equal(t, long, cache.GetUniqueJourneys()[0].Points)
+}
+
+func TestConsensusAfterRestart(t *testing.T) {
+	mockQueue := &queue.MockQueue{}
+	mockQueue.On("Push", mock.Anything).Return()
+	mockMetrics := &metrics.MockMetrics{}
+	mockMetrics.On("LogJourney").Return()
+	cache := NewCache(Config{}, mockMetrics, mockQueue)
+	stored := []Point{{X: 0, Y: 0}, {X: 1, Y: 0}, {X: 2, Y: 0}, {X: 3, Y: 0}}
+	cache.WarmUp([]Journey{{StartId: 23, DestinationId: 42, Points: stored}})
+
+	// a character arriving after a single step doesn't replace the stored points
+	cache.StartJourney("character1", 23, 42)
+	require.NoError(t, cache.Movement("character1", 3, 1))
+	require.Equal(t, stored, cache.GetUniqueJourneys()[0].Points)
+	require.NoError(t, cache.ReachedDestination("character1", 42))
+
+	journeys := cache.GetUniqueJourneys()
+	require.True(t, journeys[0].FullyMapped)
+	require.Equal(t, stored, journeys[0].Points)
+	mockQueue.AssertCalled(t, "Push", queue.JourneyPath{
+		StartId:       23,
+		DestinationId: 42,
+		Points:        []queue.Location{{X: 0, Y: 0}, {X: 1, Y: 0}, {X: 2, Y: 0}, {X: 3, Y: 0}},
+	})
+}
+
+func TestConsensusMatchesMedoid(t *testing.T) {
+	mockQueue := &queue.MockQueue{}
+	mockQueue.On("Push", mock.Anything).Return()
+	cache := NewCache(Config{Undirected: true}, nil, mockQueue)
+	cache.WarmUp([]Journey{{StartId: 23, DestinationId: 42, Points: []Point{{X: 20, Y: 20}, {X: 30, Y: 25}}}})
+
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		characterId := fmt.Sprintf("character%d", random.Intn(8))
+		if random.Intn(50) == 0 {
+			// restarts drop the walk
+			cache.StartJourney(characterId, 23, 42)
+			continue
+		}
+		if _, ok := cache.characterJourneys[characterId]; !ok {
+			if random.Intn(2) == 0 {
+				cache.StartJourney(characterId, 23, 42)
+			} else {
+				cache.StartJourney(characterId, 42, 23)
+			}
+		}
+		require.NoError(t, cache.Movement(characterId, uint16(random.Intn(50)), uint16(random.Intn(50))))
+
+		route := cache.journeys["23->42"]
+		traversals := []Traversal{{Points: route.stored.points}}
+		traversals = append(traversals, route.getTraversals()...)
+		require.Equal(t, Medoid(traversals), route.consensus)
+	}
+}
+
+// BenchmarkConsensusMovement adds a point to one of 10 walks of 500 points each
+func BenchmarkConsensusMovement(b *testing.B) {
+	route := &journey{}
+	for i := 0; i < 10; i++ {
+		for x := 0; x < 500; x++ {
+			route.addTraversalPoint(fmt.Sprintf("character%d", i), Point{X: uint16(x), Y: uint16(i)}, false)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		route.addTraversalPoint("character0", Point{X: uint16(500 + i%60000), Y: 1}, false)
+	}
+}
@@ -0,0 +1,175 @@
+package cache
+
+import (
+	"math"
+	"sort"
+)
+
+// INDEX_CELL is the size of the buckets of the spatial index in grid units
+const INDEX_CELL = 32
+
+// Area of the map to find journeys in
+type Area interface {
+	// Bounds of the area, points outside are never contained
+	Bounds() BoundingBox
+	Contains(point Point) bool
+}
+
+// Circle contains the points within the radius around the center
+type Circle struct {
+	Center Point
+	Radius float64
+}
+
+func (c Circle) Bounds() BoundingBox {
+	clamp := func(value float64) uint16 {
+		return uint16(math.Max(0, math.Min(math.MaxUint16, value)))
+	}
+	return BoundingBox{
+		MinX: clamp(math.Ceil(float64(c.Center.X) - c.Radius)),
+		MinY: clamp(math.Ceil(float64(c.Center.Y) - c.Radius)),
+		MaxX: clamp(math.Floor(float64(c.Center.X) + c.Radius)),
+		MaxY: clamp(math.Floor(float64(c.Center.Y) + c.Radius)),
+	}
+}
+
+func (c Circle) Contains(point Point) bool {
+	return distance(c.Center, point) <= c.Radius
+}
+
+func (b BoundingBox) Bounds() BoundingBox {
+	return b
+}
+
+// intersect is empty, i.e. min > max, if the boxes don't overlap
+func (b BoundingBox) intersect(other BoundingBox) BoundingBox {
+	if other.MinX > b.MinX {
+		b.MinX = other.MinX
+	}
+	if other.MinY > b.MinY {
+		b.MinY = other.MinY
+	}
+	if other.MaxX < b.MaxX {
+		b.MaxX = other.MaxX
+	}
+	if other.MaxY < b.MaxY {
+		b.MaxY = other.MaxY
+	}
+	return b
+}
+
+// Contains includes the border of the box
+func (b BoundingBox) Contains(point Point) bool {
+	return point.X >= b.MinX && point.X <= b.MaxX && point.Y >= b.MinY && point.Y <= b.MaxY
+}
+
+// JourneyMatch is a journey passing an area, Segments are the runs of consecutive points
+// of the journey inside the area
+type JourneyMatch struct {
+	Id            string    `json:"id"`
+	StartId       uint16    `json:"startId"`
+	DestinationId uint16    `json:"destinationId"`
+	FullyMapped   bool      `json:"fullyMapped"`
+	Segments      [][]Point `json:"segments"`
+}
+
+type indexCell struct {
+	x, y int
+}
+
+// spatialIndex buckets the journeys by the cells their points are in
+type spatialIndex struct {
+	cells map[indexCell]map[string]bool
+}
+
+func newSpatialIndex() *spatialIndex {
+	return &spatialIndex{cells: make(map[indexCell]map[string]bool)}
+}
+
+func (i *spatialIndex) add(routeKey string, point Point) {
+	cell := indexCell{x: int(point.X) / INDEX_CELL, y: int(point.Y) / INDEX_CELL}
+	routes := i.cells[cell]
+	if routes == nil {
+		routes = make(map[string]bool)
+		i.cells[cell] = routes
+	}
+	routes[routeKey] = true
+}
+
+// candidates returns the journeys with points in the cells overlapping the bounds. Bounds with
+// more cells than the index holds are checked cell by cell of the index instead.
+func (i *spatialIndex) candidates(bounds BoundingBox) map[string]bool {
+	candidates := make(map[string]bool)
+	minX, minY := int(bounds.MinX)/INDEX_CELL, int(bounds.MinY)/INDEX_CELL
+	maxX, maxY := int(bounds.MaxX)/INDEX_CELL, int(bounds.MaxY)/INDEX_CELL
+	if (maxX-minX+1)*(maxY-minY+1) > len(i.cells) {
+		for cell, routes := range i.cells {
+			if cell.x < minX || cell.x > maxX || cell.y < minY || cell.y > maxY {
+				continue
+			}
+			for routeKey := range routes {
+				candidates[routeKey] = true
+			}
+		}
+		return candidates
+	}
+	for x := int(bounds.MinX) / INDEX_CELL; x <= int(bounds.MaxX)/INDEX_CELL; x++ {
+		for y := int(bounds.MinY) / INDEX_CELL; y <= int(bounds.MaxY)/INDEX_CELL; y++ {
+			for routeKey := range i.cells[indexCell{x: x, y: y}] {
+				candidates[routeKey] = true
+			}
+		}
+	}
+	return candidates
+}
+
+// FindJourneys returns the journeys with points inside the area ordered by id
+func (c *cache) FindJourneys(area Area) []JourneyMatch {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	matches := []JourneyMatch{}
+	bounds := area.Bounds()
+	if c.world.Bounded() {
+		// no journey passes the area outside of the world
+		bounds = bounds.intersect(c.world.Bounds)
+	}
+	if bounds.MinX > bounds.MaxX || bounds.MinY > bounds.MaxY {
+		return matches
+	}
+	for routeKey := range c.index.candidates(bounds) {
+		route := c.journeys[routeKey]
+		segments := route.segmentsIn(area)
+		if len(segments) == 0 {
+			continue
+		}
+		matches = append(matches, JourneyMatch{
+			Id:            routeKey,
+			StartId:       route.startId,
+			DestinationId: route.destinationId,
+			FullyMapped:   route.isFullyMapped,
+			Segments:      segments,
+		})
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Id < matches[j].Id })
+	return matches
+}
+
+func (t *journey) segmentsIn(area Area) [][]Point {
+	var segments [][]Point
+	var segment []Point
+	for _, point := range t.points {
+		if area.Contains(point) {
+			segment = append(segment, point)
+			continue
+		}
+		if segment != nil {
+			segments = append(segments, segment)
+			segment = nil
+		}
+	}
+	if segment != nil {
+		segments = append(segments, segment)
+	}
+	return segments
+}
@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"fiurgeist/journey/internal/metrics"
+	"fiurgeist/journey/internal/queue"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+func TestCircle(t *testing.T) {
+	circle := Circle{Center: Point{X: 10, Y: 2}, Radius: 5}
+
+	require.Equal(t, BoundingBox{MinX: 5, MinY: 0, MaxX: 15, MaxY: 7}, circle.Bounds())
+	require.True(t, circle.Contains(Point{X: 13, Y: 6}))
+	require.False(t, circle.Contains(Point{X: 14, Y: 6}))
+}
+
+func TestBoundingBoxContains(t *testing.T) {
+	box := BoundingBox{MinX: 1, MinY: 1, MaxX: 3, MaxY: 3}
+
+	require.True(t, box.Contains(Point{X: 1, Y: 3}))
+	require.False(t, box.Contains(Point{X: 0, Y: 2}))
+	require.False(t, box.Contains(Point{X: 2, Y: 4}))
+}
+
+func TestFindJourneys(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockMetrics.On("LogJourney").Return()
+	mockQueue := &queue.MockQueue{}
+	mockQueue.On("Push", mock.Anything).Return()
+	cache := NewCache(Config{}, mockMetrics, mockQueue)
+
+	// leaves and enters the area again
+	cache.StartJourney("character1", 23, 42)
+	cache.Movements("character1", []Point{{X: 10, Y: 10}, {X: 11, Y: 10}, {X: 50, Y: 10}, {X: 12, Y: 11}})
+	cache.WarmUp([]Journey{
+		{StartId: 13, DestinationId: 42, FullyMapped: true, Points: []Point{{X: 100, Y: 100}, {X: 12, Y: 12}}},
+		{StartId: 42, DestinationId: 13, Points: []Point{{X: 500, Y: 500}}},
+	})
+
+	require.Equal(
+		t,
+		[]JourneyMatch{
+			{Id: "13->42", StartId: 13, DestinationId: 42, FullyMapped: true, Segments: [][]Point{{{X: 12, Y: 12}}}},
+			{Id: "23->42", StartId: 23, DestinationId: 42, Segments: [][]Point{{{X: 10, Y: 10}, {X: 11, Y: 10}}, {{X: 12, Y: 11}}}},
+		},
+		cache.FindJourneys(BoundingBox{MinX: 0, MinY: 0, MaxX: 20, MaxY: 20}),
+	)
+	require.Equal(
+		t,
+		[]JourneyMatch{{Id: "23->42", StartId: 23, DestinationId: 42, Segments: [][]Point{{{X: 50, Y: 10}}}}},
+		cache.FindJourneys(Circle{Center: Point{X: 52, Y: 12}, Radius: 3}),
+	)
+	require.Equal(t, []JourneyMatch{}, cache.FindJourneys(Circle{Center: Point{X: 300, Y: 300}, Radius: 10}))
+	require.Equal(t, []JourneyMatch{}, cache.FindJourneys(BoundingBox{MinX: 20, MaxX: 10, MaxY: 10}))
+
+	// an area larger than the index is checked by the cells of the index
+	matches := cache.FindJourneys(Circle{Center: Point{X: 0, Y: 0}, Radius: math.MaxUint16})
+	require.Equal(t, []string{"13->42", "23->42", "42->13"}, []string{matches[0].Id, matches[1].Id, matches[2].Id})
+}
+
+func TestFindJourneysInWorld(t *testing.T) {
+	mockQueue := &queue.MockQueue{}
+	mockQueue.On("Push", mock.Anything).Return()
+	cache := NewCache(Config{World: World{Id: "moon", Bounds: BoundingBox{MinX: 10, MinY: 10, MaxX: 99, MaxY: 99}}}, nil, mockQueue)
+	cache.WarmUp([]Journey{{StartId: 23, DestinationId: 42, Points: []Point{{X: 10, Y: 10}, {X: 99, Y: 99}}}})
+
+	// the area is clamped to the bounds of the world
+	require.Equal(t, BoundingBox{MinX: 10, MinY: 10, MaxX: 20, MaxY: 99}, BoundingBox{MaxX: 20, MaxY: 65535}.intersect(cache.world.Bounds))
+	require.Len(t, cache.FindJourneys(BoundingBox{MaxX: 20, MaxY: 65535}), 1)
+	require.Equal(t, []JourneyMatch{}, cache.FindJourneys(BoundingBox{MinX: 100, MinY: 100, MaxX: 65535, MaxY: 65535}))
+}
@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var ErrImplausibleMovement = errors.New("Implausible movement")
+
+// PlausibilityConfig limits how far a character may move, the checks are disabled with 0
+type PlausibilityConfig struct {
+	// MaxStep is the maximum distance between two movements in grid units
+	MaxStep float64 `yaml:"maxStep"`
+	// MaxSpeed is the maximum speed in grid units per second. The movements are timed by the
+	// client if it sends the time, otherwise when the cache receives them, so points of the same
+	// batch without time are only checked against MaxStep.
+	MaxSpeed float64 `yaml:"maxSpeed"`
+	// Reject drops implausible movements with ErrImplausibleMovement, otherwise they are only
+	// reported as anomalies
+	Reject bool `yaml:"reject"`
+}
+
+// Anomaly is an implausible movement of a character
+type Anomaly struct {
+	From     Point     `json:"from"`
+	To       Point     `json:"to"`
+	Distance float64   `json:"distance"`
+	Elapsed  float64   `json:"elapsed"`
+	At       time.Time `json:"at"`
+	Rejected bool      `json:"rejected"`
+}
+
+// CharacterAnomalies counts the implausible movements of a character and keeps the latest one
+type CharacterAnomalies struct {
+	CharacterId string  `json:"characterId"`
+	Count       int     `json:"count"`
+	Last        Anomaly `json:"last"`
+}
+
+type position struct {
+	point Point
+	at    time.Time
+	// at is the time of the client, which isn't compared to the time of the server
+	clientTime bool
+}
+
+// checkPlausibility compares a movement to the last plausible position of the character. The
+// speed is checked with the time of the client if given, otherwise with the time the movement
+// arrives, which isn't known for batched points. A client moving without its time passing is too
+// fast.
+func (c *cache) checkPlausibility(characterId string, x, y uint16, at time.Time, batched bool) error {
+	limits := c.plausibility
+	if limits.MaxStep <= 0 && limits.MaxSpeed <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	current := position{point: Point{X: x, Y: y}, at: at, clientTime: !at.IsZero()}
+	if !current.clientTime {
+		current.at = now
+	}
+	last, ok := c.positions[characterId]
+	if !ok {
+		c.positions[characterId] = current
+		return nil
+	}
+
+	step := distance(last.point, current.point)
+	elapsed := current.at.Sub(last.at).Seconds()
+	timed := (current.clientTime || !batched) && current.clientTime == last.clientTime
+	tooFar := limits.MaxStep > 0 && step > limits.MaxStep
+	tooFast := limits.MaxSpeed > 0 && timed && step > 0 &&
+		(elapsed > 0 && step/elapsed > limits.MaxSpeed || elapsed <= 0 && current.clientTime)
+	if !tooFar && !tooFast {
+		c.positions[characterId] = current
+		return nil
+	}
+
+	anomaly := Anomaly{From: last.point, To: current.point, Distance: step, Elapsed: elapsed, At: now, Rejected: limits.Reject}
+	anomalies := c.anomalies[characterId]
+	if anomalies == nil {
+		anomalies = &CharacterAnomalies{CharacterId: characterId}
+		c.anomalies[characterId] = anomalies
+	}
+	anomalies.Count++
+	anomalies.Last = anomaly
+	c.metrics.LogImplausibleMovement()
+
+	if limits.Reject {
+		// the next movement is compared to the last plausible position again
+		return fmt.Errorf(
+			"%w of character %s: %.1f from (%d, %d) to (%d, %d) within %.3fs",
+			ErrImplausibleMovement, characterId, step, last.point.X, last.point.Y, x, y, elapsed,
+		)
+	}
+	c.positions[characterId] = current
+	return nil
+}
+
+// GetAnomalies returns the characters with implausible movements, the most recent first
+func (c *cache) GetAnomalies() []CharacterAnomalies {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	anomalies := make([]CharacterAnomalies, 0, len(c.anomalies))
+	for _, character := range c.anomalies {
+		anomalies = append(anomalies, *character)
+	}
+	sort.Slice(anomalies, func(i, j int) bool {
+		a, b := anomalies[i], anomalies[j]
+		if !a.Last.At.Equal(b.Last.At) {
+			return a.Last.At.After(b.Last.At)
+		}
+		return a.CharacterId < b.CharacterId
+	})
+	return anomalies
+}
@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"errors"
+	"fiurgeist/journey/internal/metrics"
+	"fiurgeist/journey/internal/queue"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/undefinedlabs/go-mpatch"
+	"testing"
+	"time"
+)
+
+func TestCheckPlausibilityReject(t *testing.T) {
+	now := mockNow()
+	patchNow, err := mpatch.PatchMethod(time.Now, func() time.Time { return now })
+	require.NoError(t, err)
+	defer patchNow.Unpatch()
+
+	mockMetrics := &metrics.MockMetrics{}
+	mockMetrics.On("LogImplausibleMovement").Return()
+	cache := NewCache(Config{Plausibility: PlausibilityConfig{MaxStep: 10, MaxSpeed: 5, Reject: true}}, mockMetrics, nil)
+
+	// the first position can't be compared
+	require.NoError(t, cache.checkPlausibility("character1", 1, 1, time.Time{}, false))
+
+	// too far
+	now = now.Add(time.Minute)
+	err = cache.checkPlausibility("character1", 100, 1, time.Time{}, false)
+	require.True(t, errors.Is(err, ErrImplausibleMovement))
+	require.Equal(t, "Implausible movement of character character1: 99.0 from (1, 1) to (100, 1) within 60.000s", err.Error())
+
+	// compared to the last plausible position, within a batch only the step is checked
+	require.NoError(t, cache.checkPlausibility("character1", 9, 1, time.Time{}, true))
+	require.NoError(t, cache.checkPlausibility("character1", 17, 1, time.Time{}, true))
+
+	// too fast
+	now = now.Add(time.Second)
+	err = cache.checkPlausibility("character1", 24, 1, time.Time{}, false)
+	require.True(t, errors.Is(err, ErrImplausibleMovement))
+	require.Equal(t, Point{X: 17, Y: 1}, cache.positions["character1"].point)
+
+	require.Equal(
+		t,
+		[]CharacterAnomalies{{
+			CharacterId: "character1",
+			Count:       2,
+			Last:        Anomaly{From: Point{X: 17, Y: 1}, To: Point{X: 24, Y: 1}, Distance: 7, Elapsed: 1, At: now, Rejected: true},
+		}},
+		cache.GetAnomalies(),
+	)
+	mockMetrics.AssertNumberOfCalls(t, "LogImplausibleMovement", 2)
+}
+
+func TestCheckPlausibilityFlag(t *testing.T) {
+	patchNow, err := mpatch.PatchMethod(time.Now, mockNow)
+	require.NoError(t, err)
+	defer patchNow.Unpatch()
+
+	mockMetrics := &metrics.MockMetrics{}
+	mockMetrics.On("LogImplausibleMovement").Return()
+	cache := NewCache(Config{Plausibility: PlausibilityConfig{MaxStep: 10}}, mockMetrics, nil)
+
+	require.NoError(t, cache.checkPlausibility("character2", 1, 1, time.Time{}, false))
+	require.NoError(t, cache.checkPlausibility("character1", 1, 1, time.Time{}, false))
+	require.NoError(t, cache.checkPlausibility("character1", 50, 1, time.Time{}, false))
+	require.NoError(t, cache.checkPlausibility("character2", 1, 50, time.Time{}, false))
+
+	// flagged movements become the last position
+	require.Equal(t, Point{X: 50, Y: 1}, cache.positions["character1"].point)
+	anomalies := cache.GetAnomalies()
+	require.Equal(t, []string{"character1", "character2"}, []string{anomalies[0].CharacterId, anomalies[1].CharacterId})
+	require.False(t, anomalies[0].Last.Rejected)
+}
+
+func TestMovementImplausible(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockMetrics.On("LogImplausibleMovement").Return()
+	cache := NewCache(Config{Plausibility: PlausibilityConfig{MaxStep: 10, Reject: true}}, mockMetrics, nil)
+	cache.characterJourneys["character1"] = &characterJourney{characterId: "character1", startId: 23, destinationId: 42}
+	cache.journeys["23->42"] = &journey{startId: 23, destinationId: 42, points: []Point{{X: 1, Y: 1}}}
+	cache.positions["character1"] = position{point: Point{X: 1, Y: 1}, at: time.Now()}
+
+	err := cache.Movement("character1", 500, 500)
+	require.True(t, errors.Is(err, ErrImplausibleMovement))
+	require.Equal(t, []Point{{X: 1, Y: 1}}, cache.journeys["23->42"].points)
+
+	// a new journey may start anywhere
+	cache.journeys["42->23"] = &journey{startId: 42, destinationId: 23, isFullyMapped: true}
+	cache.StartJourney("character1", 42, 23)
+	require.NoError(t, cache.Movement("character1", 500, 500))
+}
+
+func TestMovementsMaxSpeed(t *testing.T) {
+	mockQueue := &queue.MockQueue{}
+	mockQueue.On("Push", mock.Anything).Return()
+	mockMetrics := &metrics.MockMetrics{}
+	mockMetrics.On("LogImplausibleMovement").Return()
+	cache := NewCache(Config{Plausibility: PlausibilityConfig{MaxSpeed: 10, Reject: true}}, mockMetrics, mockQueue)
+
+	// the points of a batch arrive at once, only the first one would be timed
+	cache.StartJourney("character1", 23, 42)
+	errs := cache.Movements("character1", []Point{{X: 1, Y: 1}, {X: 1, Y: 2}, {X: 1, Y: 3}, {X: 1, Y: 4}})
+	require.Equal(t, []error{nil, nil, nil, nil}, errs)
+	require.Equal(t, []Point{{X: 1, Y: 1}, {X: 1, Y: 2}, {X: 1, Y: 3}, {X: 1, Y: 4}}, cache.journeys["23->42"].points)
+	mockMetrics.AssertNumberOfCalls(t, "LogImplausibleMovement", 0)
+}
+
+func TestMovementsClientTime(t *testing.T) {
+	mockQueue := &queue.MockQueue{}
+	mockQueue.On("Push", mock.Anything).Return()
+	mockMetrics := &metrics.MockMetrics{}
+	mockMetrics.On("LogImplausibleMovement").Return()
+	cache := NewCache(Config{Plausibility: PlausibilityConfig{MaxSpeed: 10, Reject: true}}, mockMetrics, mockQueue)
+
+	// batched points are timed by the client
+	at := mockNow()
+	cache.StartJourney("character1", 23, 42)
+	errs := cache.TimedMovements("character1", []TimedPoint{
+		{Point: Point{X: 1, Y: 1}, At: at},
+		{Point: Point{X: 1, Y: 6}, At: at.Add(time.Second)},
+		{Point: Point{X: 1, Y: 26}, At: at.Add(2 * time.Second)},
+		// the time of the client has to pass
+		{Point: Point{X: 1, Y: 7}, At: at.Add(time.Second)},
+		{Point: Point{X: 1, Y: 26}, At: at.Add(3 * time.Second)},
+	})
+	require.True(t, errors.Is(errs[2], ErrImplausibleMovement))
+	require.True(t, errors.Is(errs[3], ErrImplausibleMovement))
+	require.Equal(t, []error{nil, nil, nil}, []error{errs[0], errs[1], errs[4]})
+	require.Equal(t, []Point{{X: 1, Y: 1}, {X: 1, Y: 6}, {X: 1, Y: 26}}, cache.journeys["23->42"].points)
+
+	// the time of the server isn't compared to the time of the client
+	require.NoError(t, cache.Movement("character1", 1, 40))
+	mockMetrics.AssertNumberOfCalls(t, "LogImplausibleMovement", 2)
+}
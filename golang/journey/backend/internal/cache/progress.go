@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// RECENT_MOVEMENTS is the number of the latest movements the speed of a character follows from
+const RECENT_MOVEMENTS = 10
+
+var (
+	ErrUnknownCharacter = errors.New("Unknown character")
+	ErrNoPosition       = errors.New("No position on the journey")
+	ErrNotFullyMapped   = errors.New("Journey is not fully mapped")
+)
+
+// Progress of a character on a fully mapped journey
+type Progress struct {
+	CharacterId string `json:"characterId"`
+	Journey     string `json:"journey"`
+	Position    Point  `json:"position"`
+	// Percent of the route's length up to the point of the route closest to the position
+	Percent float64 `json:"percent"`
+	// Remaining distance along the route in grid units
+	Remaining float64 `json:"remaining"`
+	// Speed over the recent movements in grid units per second, 0 if unknown
+	Speed float64 `json:"speed"`
+	// ETA at the destination, nil without speed
+	ETA *time.Time `json:"eta"`
+}
+
+// GetProgress projects the latest position of the character onto the route of its journey
+func (c *cache) GetProgress(characterId string) (Progress, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	character := c.characters[characterId]
+	if character == nil {
+		return Progress{}, fmt.Errorf("%w %q", ErrUnknownCharacter, characterId)
+	}
+	if character.Journey == nil || len(character.recent) == 0 {
+		return Progress{}, fmt.Errorf("%w of character %s", ErrNoPosition, characterId)
+	}
+	routeStart, routeDestination, reversed := c.route(character.Journey.StartId, character.Journey.DestinationId)
+	route := c.journeys[JourneyId(routeStart, routeDestination)]
+	if route == nil || !route.isFullyMapped {
+		return Progress{}, fmt.Errorf("%w: %s", ErrNotFullyMapped, character.Journey.Id)
+	}
+
+	last := character.recent[len(character.recent)-1]
+	progress := Progress{CharacterId: characterId, Journey: character.Journey.Id, Position: last.point}
+	if character.Journey.Arrived {
+		progress.Percent = 100
+		return progress, nil
+	}
+
+	points := route.points
+	if reversed {
+		points = reversePoints(points)
+	}
+	traveled, length := project(points, last.point)
+	if length > 0 {
+		progress.Percent = 100 * traveled / length
+	}
+	progress.Remaining = length - traveled
+	progress.Speed = speed(character.recent)
+	if progress.Speed > 0 {
+		eta := last.at.Add(time.Duration(progress.Remaining / progress.Speed * float64(time.Second)))
+		progress.ETA = &eta
+	}
+	return progress, nil
+}
+
+// project returns the distance along the line up to the closest point to the given one, the first
+// on ties, and the length of the line
+func project(line []Point, point Point) (float64, float64) {
+	var traveled, length float64
+	closest := math.Inf(1)
+	for i := 1; i < len(line); i++ {
+		a, b := line[i-1], line[i]
+		dx, dy := float64(b.X)-float64(a.X), float64(b.Y)-float64(a.Y)
+		segment := math.Hypot(dx, dy)
+		t := 0.0
+		if segment > 0 {
+			t = ((float64(point.X)-float64(a.X))*dx + (float64(point.Y)-float64(a.Y))*dy) / (segment * segment)
+			t = math.Max(0, math.Min(1, t))
+		}
+		if d := math.Hypot(float64(a.X)+t*dx-float64(point.X), float64(a.Y)+t*dy-float64(point.Y)); d < closest {
+			closest = d
+			traveled = length + t*segment
+		}
+		length += segment
+	}
+	return traveled, length
+}
+
+// speed is the walked distance divided by the time between the first and the last movement,
+// 0 if they arrived at once
+func speed(recent []timedPoint) float64 {
+	if len(recent) < 2 {
+		return 0
+	}
+	elapsed := recent[len(recent)-1].at.Sub(recent[0].at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	var walked float64
+	for i := 1; i < len(recent); i++ {
+		walked += distance(recent[i-1].point, recent[i].point)
+	}
+	return walked / elapsed
+}
@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"errors"
+	"fiurgeist/journey/internal/metrics"
+	"fiurgeist/journey/internal/queue"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/undefinedlabs/go-mpatch"
+	"testing"
+	"time"
+)
+
+func TestGetProgress(t *testing.T) {
+	now := mockNow()
+	patchNow, err := mpatch.PatchMethod(time.Now, func() time.Time { return now })
+	require.NoError(t, err)
+	defer patchNow.Unpatch()
+
+	cache := newProgressCache(Config{})
+
+	_, err = cache.GetProgress("character1")
+	require.True(t, errors.Is(err, ErrUnknownCharacter))
+	cache.StartJourney("character1", 23, 42)
+	_, err = cache.GetProgress("character1")
+	require.True(t, errors.Is(err, ErrNoPosition))
+
+	require.NoError(t, cache.Movement("character1", 2, 1))
+	// without speed yet
+	progress, err := cache.GetProgress("character1")
+	require.NoError(t, err)
+	require.Equal(t, Progress{CharacterId: "character1", Journey: "23->42", Position: Point{X: 2, Y: 1}, Percent: 10, Remaining: 18}, progress)
+
+	now = now.Add(time.Second)
+	require.NoError(t, cache.Movement("character1", 6, 1))
+	progress, err = cache.GetProgress("character1")
+	require.NoError(t, err)
+	eta := now.Add(3500 * time.Millisecond)
+	require.Equal(
+		t,
+		Progress{CharacterId: "character1", Journey: "23->42", Position: Point{X: 6, Y: 1}, Percent: 30, Remaining: 14, Speed: 4, ETA: &eta},
+		progress,
+	)
+
+	require.NoError(t, cache.ReachedDestination("character1", 42))
+	progress, err = cache.GetProgress("character1")
+	require.NoError(t, err)
+	require.Equal(t, Progress{CharacterId: "character1", Journey: "23->42", Position: Point{X: 6, Y: 1}, Percent: 100}, progress)
+
+	cache.StartJourney("character2", 42, 23)
+	require.NoError(t, cache.Movement("character2", 1, 1))
+	_, err = cache.GetProgress("character2")
+	require.EqualError(t, err, "Journey is not fully mapped: 42->23")
+}
+
+func TestGetProgressBatch(t *testing.T) {
+	cache := newProgressCache(Config{})
+
+	// the points of a single batch give no speed
+	cache.StartJourney("character1", 23, 42)
+	require.Equal(t, []error{nil, nil, nil}, cache.Movements("character1", []Point{{X: 0, Y: 0}, {X: 2, Y: 0}, {X: 4, Y: 0}}))
+	progress, err := cache.GetProgress("character1")
+	require.NoError(t, err)
+	require.Equal(t, Progress{CharacterId: "character1", Journey: "23->42", Position: Point{X: 4, Y: 0}, Percent: 20, Remaining: 16}, progress)
+}
+
+func TestGetProgressUndirected(t *testing.T) {
+	patchNow, err := mpatch.PatchMethod(time.Now, mockNow)
+	require.NoError(t, err)
+	defer patchNow.Unpatch()
+
+	cache := newProgressCache(Config{Undirected: true})
+
+	// walks the route from its end
+	cache.StartJourney("character1", 42, 23)
+	require.NoError(t, cache.Movement("character1", 11, 5))
+	progress, err := cache.GetProgress("character1")
+	require.NoError(t, err)
+	require.Equal(t, Progress{CharacterId: "character1", Journey: "42->23", Position: Point{X: 11, Y: 5}, Percent: 25, Remaining: 15}, progress)
+}
+
+func TestProject(t *testing.T) {
+	line := []Point{{X: 0, Y: 0}, {X: 10, Y: 0}, {X: 10, Y: 10}}
+
+	traveled, length := project(line, Point{X: 12, Y: 4})
+	require.Equal(t, 14.0, traveled)
+	require.Equal(t, 20.0, length)
+	// before the start
+	traveled, _ = project(line, Point{X: 0, Y: 5})
+	require.Equal(t, 0.0, traveled)
+
+	traveled, length = project([]Point{{X: 1, Y: 1}}, Point{X: 5, Y: 5})
+	require.Equal(t, 0.0, traveled)
+	require.Equal(t, 0.0, length)
+}
+
+func newProgressCache(config Config) *cache {
+	mockQueue := &queue.MockQueue{}
+	mockQueue.On("Push", mock.Anything).Return()
+	mockMetrics := &metrics.MockMetrics{}
+	mockMetrics.On("LogJourney").Return()
+	cache := NewCache(config, mockMetrics, mockQueue)
+	cache.WarmUp([]Journey{{
+		StartId:       23,
+		DestinationId: 42,
+		FullyMapped:   true,
+		Points:        []Point{{X: 0, Y: 0}, {X: 10, Y: 0}, {X: 10, Y: 10}},
+	}})
+	return cache
+}
@@ -0,0 +1,35 @@
+package cache
+
+import (
+	"errors"
+)
+
+// DEFAULT_WORLD serves the requests without a world, its journeys keep the ids of a single world
+const DEFAULT_WORLD = "default"
+
+var ErrOutOfBounds = errors.New("Position out of bounds")
+
+// World is a map of its own with separate journeys and characters
+type World struct {
+	Id string `yaml:"id" json:"id"`
+	// Bounds of the map, inclusive
+	Bounds BoundingBox `yaml:"bounds" json:"bounds"`
+}
+
+// Bounded tells if the world has bounds, an unbounded world spans the whole grid
+func (w World) Bounded() bool {
+	return w.Bounds != (BoundingBox{})
+}
+
+// Contains tells if a point is within the bounds of the world
+func (w World) Contains(point Point) bool {
+	return !w.Bounded() || w.Bounds.Contains(point)
+}
+
+// MessageWorld is the world of the messages and journeys, empty for the default world
+func MessageWorld(id string) string {
+	if id == DEFAULT_WORLD {
+		return ""
+	}
+	return id
+}
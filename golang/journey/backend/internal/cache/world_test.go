@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"errors"
+	"fiurgeist/journey/internal/queue"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/undefinedlabs/go-mpatch"
+	"testing"
+	"time"
+)
+
+func TestWorld(t *testing.T) {
+	patchNow, err := mpatch.PatchMethod(time.Now, mockNow)
+	require.NoError(t, err)
+	defer patchNow.Unpatch()
+
+	mockQueue := &queue.MockQueue{}
+	mockQueue.On("Push", mock.Anything).Return()
+	cache := NewCache(Config{World: World{Id: "moon", Bounds: BoundingBox{MaxX: 9, MaxY: 9}}}, nil, mockQueue)
+
+	cache.StartJourney("character1", 23, 42)
+	require.NoError(t, cache.Movement("character1", 9, 9))
+	err = cache.Movement("character1", 10, 9)
+	require.True(t, errors.Is(err, ErrOutOfBounds))
+	require.Equal(t, "Position out of bounds: (10, 9) in world moon", err.Error())
+
+	// the messages and journeys are of the world
+	require.Equal(
+		t,
+		[]interface{}{
+			queue.NewJourney{World: "moon", StartId: 23, DestinationId: 42, DiscoveredAt: mockNow()},
+			queue.NewLocation{World: "moon", StartId: 23, DestinationId: 42, X: 9, Y: 9},
+			queue.JourneyStats{World: "moon", StartId: 23, DestinationId: 42, PointCount: 1, MinX: 9, MinY: 9, MaxX: 9, MaxY: 9, Characters: 1},
+		},
+		pushed(mockQueue),
+	)
+	require.Equal(t, "moon", cache.GetUniqueJourneys()[0].World)
+}
+
+func TestDefaultWorld(t *testing.T) {
+	patchNow, err := mpatch.PatchMethod(time.Now, mockNow)
+	require.NoError(t, err)
+	defer patchNow.Unpatch()
+
+	mockQueue := &queue.MockQueue{}
+	mockQueue.On("Push", mock.Anything).Return()
+	cache := NewCache(Config{World: World{Id: DEFAULT_WORLD}}, nil, mockQueue)
+
+	// unbounded, the messages keep the ids of a single world
+	cache.StartJourney("character1", 23, 42)
+	require.NoError(t, cache.Movement("character1", 5000, 5000))
+	require.Equal(
+		t,
+		[]interface{}{
+			queue.NewJourney{StartId: 23, DestinationId: 42, DiscoveredAt: mockNow()},
+			queue.NewLocation{StartId: 23, DestinationId: 42, X: 5000, Y: 5000},
+			queue.JourneyStats{StartId: 23, DestinationId: 42, PointCount: 1, MinX: 5000, MinY: 5000, MaxX: 5000, MaxY: 5000, Characters: 1},
+		},
+		pushed(mockQueue),
+	)
+}
@@ -0,0 +1,436 @@
+package config
+
+import (
+	"errors"
+	"fiurgeist/journey/internal/cache"
+	"fiurgeist/journey/internal/geojson"
+	"fiurgeist/journey/internal/queue"
+	"fiurgeist/journey/internal/server"
+	"fiurgeist/journey/internal/store"
+	"fiurgeist/journey/internal/stream"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ENV_PREFIX = "JOURNEY_"
+
+	// what to do if the store can't be created
+	STORE_FAILURE_EXIT     = "exit"
+	STORE_FAILURE_DEGRADED = "degraded"
+)
+
+type Config struct {
+	Server          server.Config `yaml:"server"`
+	Cache           cache.Config  `yaml:"cache"`
+	Worlds          []cache.World `yaml:"worlds"`
+	Queue           QueueConfig   `yaml:"queue"`
+	Store           StoreConfig   `yaml:"store"`
+	Metrics         MetricsConfig `yaml:"metrics"`
+	Health          HealthConfig  `yaml:"health"`
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+}
+
+type QueueConfig struct {
+	Size int `yaml:"size"`
+}
+
+type StoreConfig struct {
+	store.Config `yaml:",inline"`
+	// OnFailure either exits or runs cache-only, discarding all writes
+	OnFailure string `yaml:"onFailure"`
+}
+
+type MetricsConfig struct {
+	Interval time.Duration `yaml:"interval"`
+}
+
+type HealthConfig struct {
+	// QueueThreshold is the fill ratio of the queue (0-1) above which the server isn't ready
+	QueueThreshold  float64       `yaml:"queueThreshold"`
+	ConsumerTimeout time.Duration `yaml:"consumerTimeout"`
+}
+
+// setting is a single value which can be overridden by an environment variable and a flag
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{"addr", "ADDR", "address the server listens on", func(c *Config, v string) error {
+		c.Server.Addr = v
+		return nil
+	}},
+	{"public-dir", "PUBLIC_DIR", "directory of the frontend", func(c *Config, v string) error {
+		c.Server.PublicDir = v
+		return nil
+	}},
+	{"public-js-dir", "PUBLIC_JS_DIR", "directory of the frontend scripts", func(c *Config, v string) error {
+		c.Server.PublicJsDir = v
+		return nil
+	}},
+	{"stream-heartbeat", "STREAM_HEARTBEAT", "interval of heartbeats in the journey stream", func(c *Config, v string) error {
+		return setDuration(&c.Server.StreamHeartbeat, v)
+	}},
+	{"telemetry-timeout", "TELEMETRY_TIMEOUT", "idle time after which telemetry connections are closed", func(c *Config, v string) error {
+		return setDuration(&c.Server.TelemetryTimeout, v)
+	}},
+	{"stream-positions", "STREAM_POSITIONS", "publish the accepted movements of the characters to the journey stream", func(c *Config, v string) error {
+		streamPositions, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", v)
+		}
+		c.Server.StreamPositions = streamPositions
+		return nil
+	}},
+	{"stream-history", "STREAM_HISTORY", "number of events kept for resuming the journey stream (0 keeps none)", func(c *Config, v string) error {
+		return setInt(&c.Server.StreamHistory, v)
+	}},
+	{"stream-buffer", "STREAM_BUFFER", "number of events a client of the journey stream may fall behind", func(c *Config, v string) error {
+		return setInt(&c.Server.StreamBuffer, v)
+	}},
+	{"cors-origins", "CORS_ORIGINS", "comma separated origins allowed for cross-origin requests", func(c *Config, v string) error {
+		c.Server.CORS.AllowedOrigins = splitList(v)
+		return nil
+	}},
+	{"geojson-transform", "GEOJSON_TRANSFORM", "transform of the GeoJSON coordinates as originX,originY,scaleX,scaleY", func(c *Config, v string) error {
+		values := splitList(v)
+		if len(values) != 4 {
+			return fmt.Errorf("expected 4 numbers, got %q", v)
+		}
+		numbers := make([]float64, len(values))
+		for i, value := range values {
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("invalid number %q", value)
+			}
+			numbers[i] = number
+		}
+		c.Server.GeoJSON = &geojson.Transform{OriginX: numbers[0], OriginY: numbers[1], ScaleX: numbers[2], ScaleY: numbers[3]}
+		return nil
+	}},
+	{"cache-undirected", "CACHE_UNDIRECTED", "map the journeys between two locations in both directions as one", func(c *Config, v string) error {
+		undirected, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", v)
+		}
+		c.Cache.Undirected = undirected
+		return nil
+	}},
+	{"cache-max-step", "CACHE_MAX_STEP", "maximum distance between two movements of a character (0 disables)", func(c *Config, v string) error {
+		return setFloat(&c.Cache.Plausibility.MaxStep, v)
+	}},
+	{"cache-max-speed", "CACHE_MAX_SPEED", "maximum speed of a character in grid units per second (0 disables)", func(c *Config, v string) error {
+		return setFloat(&c.Cache.Plausibility.MaxSpeed, v)
+	}},
+	{"cache-reject-implausible", "CACHE_REJECT_IMPLAUSIBLE", "reject implausible movements instead of only reporting them", func(c *Config, v string) error {
+		reject, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", v)
+		}
+		c.Cache.Plausibility.Reject = reject
+		return nil
+	}},
+	{"queue-size", "QUEUE_SIZE", "buffer size of the message queue", func(c *Config, v string) error {
+		return setInt(&c.Queue.Size, v)
+	}},
+	{"store-driver", "STORE_DRIVER", "database driver of the store (ramsql, sqlite3)", func(c *Config, v string) error {
+		c.Store.Driver = v
+		return nil
+	}},
+	{"store-dsn", "STORE_DSN", "data source name of the store database", func(c *Config, v string) error {
+		c.Store.DSN = v
+		return nil
+	}},
+	{"store-on-failure", "STORE_ON_FAILURE", "exit or run degraded without store if it can't be created", func(c *Config, v string) error {
+		c.Store.OnFailure = v
+		return nil
+	}},
+	{"store-retry-attempts", "STORE_RETRY_ATTEMPTS", "attempts to write a message before it is dead lettered", func(c *Config, v string) error {
+		attempts, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", v)
+		}
+		c.Store.Retry.MaxAttempts = attempts
+		return nil
+	}},
+	{"store-retry-initial-backoff", "STORE_RETRY_INITIAL_BACKOFF", "wait after the first failed write, doubled for every further attempt", func(c *Config, v string) error {
+		return setDuration(&c.Store.Retry.InitialBackoff, v)
+	}},
+	{"store-retry-max-backoff", "STORE_RETRY_MAX_BACKOFF", "maximum wait between two attempts to write", func(c *Config, v string) error {
+		return setDuration(&c.Store.Retry.MaxBackoff, v)
+	}},
+	{"store-dead-letter-file", "STORE_DEAD_LETTER_FILE", "JSONL file for messages which couldn't be written (empty only logs them)", func(c *Config, v string) error {
+		c.Store.DeadLetterFile = v
+		return nil
+	}},
+	{"metrics-interval", "METRICS_INTERVAL", "interval of printing metrics", func(c *Config, v string) error {
+		return setDuration(&c.Metrics.Interval, v)
+	}},
+	{"health-queue-threshold", "HEALTH_QUEUE_THRESHOLD", "queue fill ratio (0-1) above which the server isn't ready", func(c *Config, v string) error {
+		threshold, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", v)
+		}
+		c.Health.QueueThreshold = threshold
+		return nil
+	}},
+	{"health-consumer-timeout", "HEALTH_CONSUMER_TIMEOUT", "time the store consumer may be unresponsive", func(c *Config, v string) error {
+		return setDuration(&c.Health.ConsumerTimeout, v)
+	}},
+	{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "time to wait for open requests on shutdown", func(c *Config, v string) error {
+		return setDuration(&c.ShutdownTimeout, v)
+	}},
+}
+
+func Default() Config {
+	return Config{
+		Server: server.Config{
+			Addr:             ":8080",
+			PublicDir:        "../public",
+			PublicJsDir:      "../public/static/js",
+			StreamHeartbeat:  server.DEFAULT_STREAM_HEARTBEAT,
+			TelemetryTimeout: server.DEFAULT_TELEMETRY_TIMEOUT,
+			StreamHistory:    stream.DEFAULT_HISTORY_SIZE,
+			StreamBuffer:     stream.DEFAULT_BUFFER_SIZE,
+		},
+		Worlds: []cache.World{
+			{Id: cache.DEFAULT_WORLD, Bounds: cache.BoundingBox{MaxX: 1023, MaxY: 1023}},
+		},
+		Queue: QueueConfig{
+			Size: queue.CHANNEL_BUFFER_SIZE,
+		},
+		Store: StoreConfig{
+			Config: store.Config{
+				Driver: "ramsql",
+				DSN:    "JourneyDB",
+				Retry: store.RetryConfig{
+					MaxAttempts:    5,
+					InitialBackoff: 100 * time.Millisecond,
+					MaxBackoff:     5 * time.Second,
+				},
+				DeadLetterFile: "dead-letters.jsonl",
+			},
+			OnFailure: STORE_FAILURE_EXIT,
+		},
+		Metrics: MetricsConfig{
+			Interval: time.Second,
+		},
+		Health: HealthConfig{
+			QueueThreshold:  0.9,
+			ConsumerTimeout: 5 * time.Second,
+		},
+		ShutdownTimeout: 60 * time.Second,
+	}
+}
+
+// Load builds the config from the defaults, overridden by the config file, environment
+// variables and finally the command line flags. The config file is given by the flag
+// `-config` or the environment variable JOURNEY_CONFIG.
+func Load(name string, args []string, getenv func(string) string) (Config, error) {
+	c, _, err := LoadArgs(name, args, getenv)
+	return c, err
+}
+
+// LoadArgs is Load, but also returns the arguments following the flags
+func LoadArgs(name string, args []string, getenv func(string) string) (Config, []string, error) {
+	return LoadFlags(flag.NewFlagSet(name, flag.ContinueOnError), args, getenv)
+}
+
+// LoadFlags is LoadArgs with a flag set, which may define flags of its own, e.g. of a command
+func LoadFlags(flags *flag.FlagSet, args []string, getenv func(string) string) (Config, []string, error) {
+	c := Default()
+
+	configFile := flags.String("config", getenv(ENV_PREFIX+"CONFIG"), "path of a YAML config file")
+	values := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		values[s.flag] = flags.String(s.flag, "", fmt.Sprintf("%s (env %s%s)", s.usage, ENV_PREFIX, s.env))
+	}
+	if err := flags.Parse(args); err != nil {
+		return c, nil, err
+	}
+
+	if *configFile != "" {
+		if err := c.readFile(*configFile); err != nil {
+			return c, nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if value := getenv(ENV_PREFIX + s.env); value != "" {
+			if err := s.set(&c, value); err != nil {
+				return c, nil, fmt.Errorf("Invalid environment variable %s%s: %s", ENV_PREFIX, s.env, err)
+			}
+		}
+	}
+
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && err == nil {
+				if setErr := s.set(&c, *values[s.flag]); setErr != nil {
+					err = fmt.Errorf("Invalid flag -%s: %s", s.flag, setErr)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return c, nil, err
+	}
+
+	return c, flags.Args(), c.Validate()
+}
+
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Failed to open config file: %s", err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("Failed to parse config file %s: %s", path, err)
+	}
+	return nil
+}
+
+func (c *Config) Validate() error {
+	var problems []string
+	if c.Server.Addr == "" {
+		problems = append(problems, "server.addr must not be empty")
+	}
+	if c.Server.StreamHeartbeat <= 0 {
+		problems = append(problems, "server.streamHeartbeat must be positive")
+	}
+	if c.Server.TelemetryTimeout <= 0 {
+		problems = append(problems, "server.telemetryTimeout must be positive")
+	}
+	if c.Server.StreamHistory < 0 {
+		problems = append(problems, "server.streamHistory must not be negative")
+	}
+	if c.Server.StreamBuffer <= 0 {
+		problems = append(problems, "server.streamBuffer must be positive")
+	}
+	if c.Server.GeoJSON != nil && (c.Server.GeoJSON.ScaleX == 0 || c.Server.GeoJSON.ScaleY == 0) {
+		problems = append(problems, "server.geojson scaleX and scaleY must not be 0")
+	}
+	if c.Cache.Plausibility.MaxStep < 0 || c.Cache.Plausibility.MaxSpeed < 0 {
+		problems = append(problems, "cache.plausibility limits must not be negative")
+	}
+	problems = append(problems, validateWorlds(c.Worlds)...)
+	if c.Queue.Size <= 0 {
+		problems = append(problems, "queue.size must be positive")
+	}
+	if c.Store.Driver != "ramsql" && c.Store.Driver != "sqlite3" {
+		problems = append(problems, fmt.Sprintf("store.driver %q is not supported", c.Store.Driver))
+	}
+	if c.Store.DSN == "" {
+		problems = append(problems, "store.dsn must not be empty")
+	}
+	if c.Store.Retry.MaxAttempts <= 0 {
+		problems = append(problems, "store.retry.maxAttempts must be positive")
+	}
+	if c.Store.Retry.InitialBackoff <= 0 || c.Store.Retry.MaxBackoff < c.Store.Retry.InitialBackoff {
+		problems = append(problems, "store.retry backoffs must be positive with maxBackoff >= initialBackoff")
+	}
+	if c.Store.OnFailure != STORE_FAILURE_EXIT && c.Store.OnFailure != STORE_FAILURE_DEGRADED {
+		problems = append(problems, fmt.Sprintf("store.onFailure %q is not supported", c.Store.OnFailure))
+	}
+	if c.Metrics.Interval <= 0 {
+		problems = append(problems, "metrics.interval must be positive")
+	}
+	if c.Health.QueueThreshold <= 0 || c.Health.QueueThreshold > 1 {
+		problems = append(problems, "health.queueThreshold must be within (0, 1]")
+	}
+	if c.Health.ConsumerTimeout <= 0 {
+		problems = append(problems, "health.consumerTimeout must be positive")
+	}
+	if c.ShutdownTimeout <= 0 {
+		problems = append(problems, "shutdownTimeout must be positive")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("Invalid config: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+var worldIdPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// World returns the configured world of an id
+func (c Config) World(id string) (cache.World, bool) {
+	for _, world := range c.Worlds {
+		if world.Id == id {
+			return world, true
+		}
+	}
+	return cache.World{}, false
+}
+
+func validateWorlds(worlds []cache.World) []string {
+	var problems []string
+	ids := make(map[string]bool)
+	for _, world := range worlds {
+		if !worldIdPattern.MatchString(world.Id) {
+			problems = append(problems, fmt.Sprintf("worlds id %q must only contain letters, digits, '_' and '-'", world.Id))
+		} else if ids[world.Id] {
+			problems = append(problems, fmt.Sprintf("worlds id %q is not unique", world.Id))
+		}
+		ids[world.Id] = true
+		if world.Bounds.MinX > world.Bounds.MaxX || world.Bounds.MinY > world.Bounds.MaxY {
+			problems = append(problems, fmt.Sprintf("worlds %q bounds must have min <= max", world.Id))
+		}
+	}
+	if !ids[cache.DEFAULT_WORLD] {
+		problems = append(problems, fmt.Sprintf("worlds must contain %q", cache.DEFAULT_WORLD))
+	}
+	return problems
+}
+
+func setDuration(d *time.Duration, value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+func setInt(i *int, value string) error {
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid number %q", value)
+	}
+	*i = parsed
+	return nil
+}
+
+func setFloat(f *float64, value string) error {
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("invalid number %q", value)
+	}
+	*f = parsed
+	return nil
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,250 @@
+package config
+
+import (
+	"fiurgeist/journey/internal/cache"
+	"fiurgeist/journey/internal/geojson"
+	"flag"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadDefault(t *testing.T) {
+	config, err := Load("test", []string{}, mockEnv(nil))
+	require.NoError(t, err)
+	require.Equal(t, Default(), config)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	configFile := writeConfigFile(t, `
+server:
+  addr: ":1000"
+  publicDir: "/srv/public"
+queue:
+  size: 23
+store:
+  driver: sqlite3
+  dsn: "/tmp/file.db"
+cache:
+  undirected: true
+metrics:
+  interval: 5s
+`)
+	defer os.RemoveAll(filepath.Dir(configFile))
+
+	env := mockEnv(map[string]string{
+		"JOURNEY_CONFIG":     configFile,
+		"JOURNEY_ADDR":       ":2000",
+		"JOURNEY_QUEUE_SIZE": "42",
+	})
+	config, err := Load("test", []string{"-addr", ":3000", "-cors-origins", "http://a.com, http://b.com", "-store-retry-attempts", "3"}, env)
+	require.NoError(t, err)
+
+	expected := Default()
+	expected.Server.Addr = ":3000"            // flag over env and file
+	expected.Server.PublicDir = "/srv/public" // file over default
+	expected.Queue.Size = 42                  // env over file
+	expected.Cache.Undirected = true
+	expected.Store.Driver = "sqlite3"
+	expected.Store.DSN = "/tmp/file.db"
+	expected.Store.Retry.MaxAttempts = 3 // flag over default
+	expected.Metrics.Interval = 5 * time.Second
+	expected.Server.CORS.AllowedOrigins = []string{"http://a.com", "http://b.com"}
+	require.Equal(t, expected, config)
+}
+
+func TestLoadConfigFlag(t *testing.T) {
+	configFile := writeConfigFile(t, "shutdownTimeout: 10s\n")
+	defer os.RemoveAll(filepath.Dir(configFile))
+
+	config, err := Load("test", []string{"-config", configFile}, mockEnv(nil))
+	require.NoError(t, err)
+	require.Equal(t, 10*time.Second, config.ShutdownTimeout)
+}
+
+func TestLoadGeoJSONTransform(t *testing.T) {
+	config, err := Load("test", []string{"-geojson-transform", "-180, 90, 0.3515625, -0.17578125"}, mockEnv(nil))
+	require.NoError(t, err)
+	require.Equal(t, &geojson.Transform{OriginX: -180, OriginY: 90, ScaleX: 0.3515625, ScaleY: -0.17578125}, config.Server.GeoJSON)
+}
+
+func TestLoadStreamPositions(t *testing.T) {
+	config, err := Load("test", nil, mockEnv(map[string]string{"JOURNEY_STREAM_POSITIONS": "true"}))
+	require.NoError(t, err)
+	require.True(t, config.Server.StreamPositions)
+}
+
+func TestLoadStreamSizes(t *testing.T) {
+	config, err := Load("test", []string{"-stream-history", "0", "-stream-buffer", "16"}, mockEnv(nil))
+	require.NoError(t, err)
+	require.Equal(t, 0, config.Server.StreamHistory)
+	require.Equal(t, 16, config.Server.StreamBuffer)
+}
+
+func TestLoadPlausibility(t *testing.T) {
+	args := []string{"-cache-max-step", "12.5", "-cache-max-speed", "30", "-cache-reject-implausible", "true"}
+	config, err := Load("test", args, mockEnv(nil))
+	require.NoError(t, err)
+	require.Equal(t, cache.PlausibilityConfig{MaxStep: 12.5, MaxSpeed: 30, Reject: true}, config.Cache.Plausibility)
+}
+
+func TestLoadWorlds(t *testing.T) {
+	configFile := writeConfigFile(t, `
+worlds:
+  - id: default
+    bounds: {minX: 0, minY: 0, maxX: 2047, maxY: 2047}
+  - id: moon
+    bounds: {minX: 10, minY: 10, maxX: 99, maxY: 99}
+`)
+	defer os.RemoveAll(filepath.Dir(configFile))
+
+	config, err := Load("test", []string{"-config", configFile}, mockEnv(nil))
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		[]cache.World{
+			{Id: "default", Bounds: cache.BoundingBox{MaxX: 2047, MaxY: 2047}},
+			{Id: "moon", Bounds: cache.BoundingBox{MinX: 10, MinY: 10, MaxX: 99, MaxY: 99}},
+		},
+		config.Worlds,
+	)
+	moon, ok := config.World("moon")
+	require.True(t, ok)
+	require.Equal(t, config.Worlds[1], moon)
+	_, ok = config.World("mars")
+	require.False(t, ok)
+
+	invalid := writeConfigFile(t, `
+worlds:
+  - id: "moon:1"
+  - id: mars
+    bounds: {minX: 10, maxX: 9}
+  - id: mars
+`)
+	defer os.RemoveAll(filepath.Dir(invalid))
+
+	_, err = Load("test", []string{"-config", invalid}, mockEnv(nil))
+	require.Error(t, err)
+	require.Equal(
+		t,
+		"Invalid config: worlds id \"moon:1\" must only contain letters, digits, '_' and '-'; "+
+			"worlds \"mars\" bounds must have min <= max; worlds id \"mars\" is not unique; worlds must contain \"default\"",
+		err.Error(),
+	)
+}
+
+func TestLoadArgs(t *testing.T) {
+	config, args, err := LoadArgs("test", []string{"-addr", ":3000", "locations", "csv"}, mockEnv(nil))
+	require.NoError(t, err)
+	require.Equal(t, ":3000", config.Server.Addr)
+	require.Equal(t, []string{"locations", "csv"}, args)
+}
+
+func TestLoadFlags(t *testing.T) {
+	// flags of a command besides the settings
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	world := flags.String("world", "default", "")
+	config, args, err := LoadFlags(flags, []string{"-world", "moon", "-addr", ":3000", "routes.csv"}, mockEnv(nil))
+	require.NoError(t, err)
+	require.Equal(t, "moon", *world)
+	require.Equal(t, ":3000", config.Server.Addr)
+	require.Equal(t, []string{"routes.csv"}, args)
+}
+
+func TestLoadErrors(t *testing.T) {
+	unknownField := writeConfigFile(t, "foo: 1\n")
+	defer os.RemoveAll(filepath.Dir(unknownField))
+
+	testCases := []struct {
+		args []string
+		env  map[string]string
+		err  string
+	}{
+		{
+			args: []string{"-config", "does/not/exist.yaml"},
+			err:  "Failed to open config file: open does/not/exist.yaml: no such file or directory",
+		},
+		{
+			args: []string{"-config", unknownField},
+			err:  "Failed to parse config file " + unknownField + ": yaml: unmarshal errors:\n  line 1: field foo not found in type config.Config",
+		},
+		{
+			env: map[string]string{"JOURNEY_QUEUE_SIZE": "foo"},
+			err: "Invalid environment variable JOURNEY_QUEUE_SIZE: invalid number \"foo\"",
+		},
+		{
+			args: []string{"-metrics-interval", "foo"},
+			err:  "Invalid flag -metrics-interval: time: invalid duration \"foo\"",
+		},
+		{
+			args: []string{"-queue-size", "0", "-store-driver", "postgres", "-store-dsn", ""},
+			err:  "Invalid config: queue.size must be positive; store.driver \"postgres\" is not supported; store.dsn must not be empty",
+		},
+		{
+			env: map[string]string{"JOURNEY_GEOJSON_TRANSFORM": "1,2,3"},
+			err: "Invalid environment variable JOURNEY_GEOJSON_TRANSFORM: expected 4 numbers, got \"1,2,3\"",
+		},
+		{
+			args: []string{"-geojson-transform", "0,0,0,1"},
+			err:  "Invalid config: server.geojson scaleX and scaleY must not be 0",
+		},
+		{
+			args: []string{"-stream-history", "-1", "-stream-buffer", "0"},
+			err:  "Invalid config: server.streamHistory must not be negative; server.streamBuffer must be positive",
+		},
+		{
+			args: []string{"-cache-undirected", "foo"},
+			err:  "Invalid flag -cache-undirected: invalid boolean \"foo\"",
+		},
+		{
+			args: []string{"-cache-max-step", "-1"},
+			err:  "Invalid config: cache.plausibility limits must not be negative",
+		},
+		{
+			env: map[string]string{"JOURNEY_CACHE_MAX_SPEED": "fast"},
+			err: "Invalid environment variable JOURNEY_CACHE_MAX_SPEED: invalid number \"fast\"",
+		},
+		{
+			args: []string{"-health-queue-threshold", "1.5"},
+			err:  "Invalid config: health.queueThreshold must be within (0, 1]",
+		},
+		{
+			args: []string{"-store-retry-attempts", "0", "-store-retry-max-backoff", "1ms"},
+			err:  "Invalid config: store.retry.maxAttempts must be positive; store.retry backoffs must be positive with maxBackoff >= initialBackoff",
+		},
+		{
+			env: map[string]string{"JOURNEY_STORE_ON_FAILURE": "ignore"},
+			err: "Invalid config: store.onFailure \"ignore\" is not supported",
+		},
+	}
+
+	for _, testCase := range testCases {
+		_, err := Load("test", testCase.args, mockEnv(testCase.env))
+		require.Error(t, err, testCase.args)
+		require.Equal(t, testCase.err, err.Error())
+	}
+}
+
+func TestLoadHelp(t *testing.T) {
+	_, err := Load("test", []string{"-h"}, mockEnv(nil))
+	require.Equal(t, flag.ErrHelp, err)
+}
+
+func mockEnv(env map[string]string) func(string) string {
+	return func(key string) string {
+		return env[key]
+	}
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "config.yaml")
+	err = ioutil.WriteFile(path, []byte(content), 0644)
+	require.NoError(t, err)
+	return path
+}
@@ -0,0 +1,186 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fiurgeist/journey/internal/cache"
+	"fiurgeist/journey/internal/geometry"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	FORMAT_CSV    = "csv"
+	FORMAT_NDJSON = "ndjson"
+
+	DATASET_JOURNEYS  = "journeys"
+	DATASET_LOCATIONS = "locations"
+)
+
+// JourneyRow is a row of the journey table
+type JourneyRow struct {
+	Id            string `json:"id"`
+	StartId       uint16 `json:"startId"`
+	DestinationId uint16 `json:"destinationId"`
+	FullyMapped   bool   `json:"fullyMapped"`
+}
+
+// LocationRow is a row of the location table, JourneyId refers to JourneyRow.Id. Seq orders the
+// points of a journey, points added in front of the first one (undirected journeys walked in
+// reverse) have negative seqs.
+type LocationRow struct {
+	JourneyId string `json:"journeyId"`
+	Seq       int    `json:"seq"`
+	X         uint16 `json:"x"`
+	Y         uint16 `json:"y"`
+}
+
+// Source passes the rows one by one, so exports don't need to fit into memory
+type Source interface {
+	EachJourney(fn func(JourneyRow) error) error
+	// EachLocation passes the locations grouped by journey in order of the journey
+	EachLocation(fn func(LocationRow) error) error
+}
+
+var journeyHeader = []string{"id", "startId", "destinationId", "fullyMapped"}
+
+func (r JourneyRow) record() []string {
+	return []string{
+		r.Id,
+		strconv.Itoa(int(r.StartId)),
+		strconv.Itoa(int(r.DestinationId)),
+		strconv.FormatBool(r.FullyMapped),
+	}
+}
+
+var locationHeader = []string{"journeyId", "seq", "x", "y"}
+
+func (r LocationRow) record() []string {
+	return []string{r.JourneyId, strconv.Itoa(r.Seq), strconv.Itoa(int(r.X)), strconv.Itoa(int(r.Y))}
+}
+
+func ContentType(format string) string {
+	if format == FORMAT_CSV {
+		return "text/csv"
+	}
+	return "application/x-ndjson"
+}
+
+// rowWriter writes a row either as CSV record or JSON line
+type rowWriter interface {
+	write(row interface{ record() []string }) error
+	flush() error
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c csvWriter) write(row interface{ record() []string }) error {
+	return c.w.Write(row.record())
+}
+
+func (c csvWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonWriter struct {
+	encoder *json.Encoder
+}
+
+func (n ndjsonWriter) write(row interface{ record() []string }) error {
+	return n.encoder.Encode(row)
+}
+
+func (n ndjsonWriter) flush() error {
+	return nil
+}
+
+// Export writes a dataset of the source in the given format
+func Export(w io.Writer, source Source, dataset, format string) error {
+	var header []string
+	switch dataset {
+	case DATASET_JOURNEYS:
+		header = journeyHeader
+	case DATASET_LOCATIONS:
+		header = locationHeader
+	default:
+		return fmt.Errorf("Unknown dataset %q", dataset)
+	}
+
+	var rows rowWriter
+	switch format {
+	case FORMAT_CSV:
+		c := csv.NewWriter(w)
+		if err := c.Write(header); err != nil {
+			return err
+		}
+		rows = csvWriter{w: c}
+	case FORMAT_NDJSON:
+		rows = ndjsonWriter{encoder: json.NewEncoder(w)}
+	default:
+		return fmt.Errorf("Unknown format %q", format)
+	}
+
+	var err error
+	if dataset == DATASET_JOURNEYS {
+		err = source.EachJourney(func(row JourneyRow) error { return rows.write(row) })
+	} else {
+		err = source.EachLocation(func(row LocationRow) error { return rows.write(row) })
+	}
+	if err != nil {
+		return err
+	}
+	return rows.flush()
+}
+
+type simplifiedSource struct {
+	Source
+	simplify geometry.Simplifier
+}
+
+// Simplified simplifies the locations of every journey, only one journey is held in memory.
+// The kept locations keep their original seq.
+func Simplified(source Source, simplify geometry.Simplifier) Source {
+	return &simplifiedSource{Source: source, simplify: simplify}
+}
+
+func (s *simplifiedSource) EachLocation(fn func(LocationRow) error) error {
+	var journey []LocationRow
+	flush := func() error {
+		points := make([]cache.Point, len(journey))
+		for i, row := range journey {
+			points[i] = cache.Point{X: row.X, Y: row.Y}
+		}
+		kept := s.simplify(points)
+		// the simplified points are a subsequence of the journey
+		i := 0
+		for _, point := range kept {
+			for journey[i].X != point.X || journey[i].Y != point.Y {
+				i++
+			}
+			if err := fn(journey[i]); err != nil {
+				return err
+			}
+			i++
+		}
+		journey = journey[:0]
+		return nil
+	}
+
+	err := s.Source.EachLocation(func(row LocationRow) error {
+		if len(journey) > 0 && journey[0].JourneyId != row.JourneyId {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		journey = append(journey, row)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
@@ -0,0 +1,29 @@
+package export
+
+import (
+	"github.com/stretchr/testify/mock"
+)
+
+type MockSource struct {
+	mock.Mock
+}
+
+func (m *MockSource) EachJourney(fn func(JourneyRow) error) error {
+	args := m.Called()
+	for _, row := range args.Get(0).([]JourneyRow) {
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
+
+func (m *MockSource) EachLocation(fn func(LocationRow) error) error {
+	args := m.Called()
+	for _, row := range args.Get(0).([]LocationRow) {
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
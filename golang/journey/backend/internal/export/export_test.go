@@ -0,0 +1,96 @@
+package export
+
+import (
+	"bytes"
+	"errors"
+	"fiurgeist/journey/internal/geometry"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+var journeys = []JourneyRow{
+	{Id: "23-42", StartId: 23, DestinationId: 42, FullyMapped: true},
+	{Id: "42-23", StartId: 42, DestinationId: 23},
+}
+
+var locations = []LocationRow{
+	{JourneyId: "23-42", Seq: 0, X: 1, Y: 2},
+	{JourneyId: "23-42", Seq: 1, X: 2, Y: 2},
+}
+
+func TestExportCSV(t *testing.T) {
+	source := &MockSource{}
+	source.On("EachJourney").Return(journeys, nil)
+	source.On("EachLocation").Return(locations, nil)
+
+	var buf bytes.Buffer
+	require.NoError(t, Export(&buf, source, DATASET_JOURNEYS, FORMAT_CSV))
+	require.Equal(t, "id,startId,destinationId,fullyMapped\n23-42,23,42,true\n42-23,42,23,false\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, Export(&buf, source, DATASET_LOCATIONS, FORMAT_CSV))
+	require.Equal(t, "journeyId,seq,x,y\n23-42,0,1,2\n23-42,1,2,2\n", buf.String())
+}
+
+func TestExportNDJSON(t *testing.T) {
+	source := &MockSource{}
+	source.On("EachJourney").Return(journeys, nil)
+	source.On("EachLocation").Return(locations, nil)
+
+	var buf bytes.Buffer
+	require.NoError(t, Export(&buf, source, DATASET_JOURNEYS, FORMAT_NDJSON))
+	require.Equal(
+		t,
+		`{"id":"23-42","startId":23,"destinationId":42,"fullyMapped":true}`+"\n"+
+			`{"id":"42-23","startId":42,"destinationId":23,"fullyMapped":false}`+"\n",
+		buf.String(),
+	)
+
+	buf.Reset()
+	require.NoError(t, Export(&buf, source, DATASET_LOCATIONS, FORMAT_NDJSON))
+	require.Equal(
+		t,
+		`{"journeyId":"23-42","seq":0,"x":1,"y":2}`+"\n"+`{"journeyId":"23-42","seq":1,"x":2,"y":2}`+"\n",
+		buf.String(),
+	)
+}
+
+func TestExportErrors(t *testing.T) {
+	source := &MockSource{}
+	source.On("EachJourney").Return([]JourneyRow{}, errors.New("broken"))
+
+	var buf bytes.Buffer
+	err := Export(&buf, source, DATASET_JOURNEYS, FORMAT_NDJSON)
+	require.Error(t, err)
+	require.Equal(t, "broken", err.Error())
+
+	err = Export(&buf, source, "foo", FORMAT_CSV)
+	require.Error(t, err)
+	require.Equal(t, "Unknown dataset \"foo\"", err.Error())
+
+	err = Export(&buf, source, DATASET_JOURNEYS, "xml")
+	require.Error(t, err)
+	require.Equal(t, "Unknown format \"xml\"", err.Error())
+}
+
+func TestSimplified(t *testing.T) {
+	source := &MockSource{}
+	source.On("EachJourney").Return(journeys, nil)
+	source.On("EachLocation").Return([]LocationRow{
+		{JourneyId: "23-42", Seq: 0, X: 1, Y: 2},
+		{JourneyId: "23-42", Seq: 1, X: 2, Y: 2},
+		{JourneyId: "23-42", Seq: 2, X: 3, Y: 2},
+		{JourneyId: "42-23", Seq: 0, X: 3, Y: 2},
+		{JourneyId: "42-23", Seq: 1, X: 2, Y: 2},
+		{JourneyId: "42-23", Seq: 2, X: 2, Y: 3},
+	}, nil)
+
+	var buf bytes.Buffer
+	require.NoError(t, Export(&buf, Simplified(source, geometry.RemoveCollinear), DATASET_LOCATIONS, FORMAT_CSV))
+	require.Equal(t, "journeyId,seq,x,y\n23-42,0,1,2\n23-42,2,3,2\n42-23,0,3,2\n42-23,1,2,2\n42-23,2,2,3\n", buf.String())
+
+	// journeys aren't touched
+	buf.Reset()
+	require.NoError(t, Export(&buf, Simplified(source, geometry.RemoveCollinear), DATASET_JOURNEYS, FORMAT_CSV))
+	require.Equal(t, "id,startId,destinationId,fullyMapped\n23-42,23,42,true\n42-23,42,23,false\n", buf.String())
+}
@@ -0,0 +1,168 @@
+package geojson
+
+import (
+	"encoding/json"
+	"errors"
+	"fiurgeist/journey/internal/cache"
+	"fmt"
+	"io"
+	"math"
+)
+
+const CONTENT_TYPE = "application/geo+json"
+
+// Transform maps the 1024x1024 grid to another coordinate system:
+// x' = OriginX + x*ScaleX, y' = OriginY + y*ScaleY
+type Transform struct {
+	OriginX float64 `yaml:"originX"`
+	OriginY float64 `yaml:"originY"`
+	ScaleX  float64 `yaml:"scaleX"`
+	ScaleY  float64 `yaml:"scaleY"`
+}
+
+// Identity keeps the grid coordinates
+var Identity = Transform{ScaleX: 1, ScaleY: 1}
+
+func (t Transform) Apply(point cache.Point) Position {
+	return Position{t.OriginX + float64(point.X)*t.ScaleX, t.OriginY + float64(point.Y)*t.ScaleY}
+}
+
+// Invert maps a position back to the grid, rounding to the closest point
+func (t Transform) Invert(position Position) (cache.Point, error) {
+	if t.ScaleX == 0 || t.ScaleY == 0 {
+		return cache.Point{}, errors.New("Transform can't be inverted with a scale of 0")
+	}
+	x := math.Round((position[0] - t.OriginX) / t.ScaleX)
+	y := math.Round((position[1] - t.OriginY) / t.ScaleY)
+	if x < 0 || x > math.MaxUint16 || y < 0 || y > math.MaxUint16 {
+		return cache.Point{}, fmt.Errorf("Position %v is outside of the grid", position)
+	}
+	return cache.Point{X: uint16(x), Y: uint16(y)}, nil
+}
+
+type Position [2]float64
+
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+type Feature struct {
+	Type       string     `json:"type"`
+	Id         string     `json:"id"`
+	Geometry   *Geometry  `json:"geometry"`
+	Properties Properties `json:"properties"`
+}
+
+type Geometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type Properties struct {
+	StartId       uint16 `json:"startId"`
+	DestinationId uint16 `json:"destinationId"`
+	FullyMapped   bool   `json:"fullyMapped"`
+}
+
+// NewFeature renders a journey as LineString. A line needs two positions, so a journey with
+// a single point is a Point and one without points has no geometry.
+func NewFeature(journey cache.Journey, transform Transform) Feature {
+	feature := Feature{
+		Type: "Feature",
+		Id:   journey.Id,
+		Properties: Properties{
+			StartId:       journey.StartId,
+			DestinationId: journey.DestinationId,
+			FullyMapped:   journey.FullyMapped,
+		},
+	}
+
+	switch len(journey.Points) {
+	case 0:
+	case 1:
+		feature.Geometry = &Geometry{Type: "Point", Coordinates: transform.Apply(journey.Points[0])}
+	default:
+		line := make([]Position, len(journey.Points))
+		for i, point := range journey.Points {
+			line[i] = transform.Apply(point)
+		}
+		feature.Geometry = &Geometry{Type: "LineString", Coordinates: line}
+	}
+	return feature
+}
+
+func NewFeatureCollection(journeys []cache.Journey, transform Transform) FeatureCollection {
+	collection := FeatureCollection{
+		Type:     "FeatureCollection",
+		Features: make([]Feature, len(journeys)),
+	}
+	for i, journey := range journeys {
+		collection.Features[i] = NewFeature(journey, transform)
+	}
+	return collection
+}
+
+func Write(w io.Writer, journeys []cache.Journey, transform Transform) error {
+	return json.NewEncoder(w).Encode(NewFeatureCollection(journeys, transform))
+}
+
+// rawFeature is a Feature before the type of its geometry is known
+type rawFeature struct {
+	Geometry *struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	} `json:"geometry"`
+	Properties Properties `json:"properties"`
+}
+
+// Read is the inverse of Write, it reads the journeys of a FeatureCollection
+func Read(r io.Reader, transform Transform) ([]cache.Journey, error) {
+	var collection struct {
+		Type     string       `json:"type"`
+		Features []rawFeature `json:"features"`
+	}
+	if err := json.NewDecoder(r).Decode(&collection); err != nil {
+		return nil, err
+	}
+	if collection.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("Expected a FeatureCollection, got %q", collection.Type)
+	}
+
+	journeys := make([]cache.Journey, len(collection.Features))
+	for i, feature := range collection.Features {
+		journey := cache.Journey{
+			Id:            cache.JourneyId(feature.Properties.StartId, feature.Properties.DestinationId),
+			StartId:       feature.Properties.StartId,
+			DestinationId: feature.Properties.DestinationId,
+			FullyMapped:   feature.Properties.FullyMapped,
+		}
+
+		var positions []Position
+		if feature.Geometry != nil {
+			var err error
+			switch feature.Geometry.Type {
+			case "Point":
+				var position Position
+				err = json.Unmarshal(feature.Geometry.Coordinates, &position)
+				positions = []Position{position}
+			case "LineString":
+				err = json.Unmarshal(feature.Geometry.Coordinates, &positions)
+			default:
+				err = fmt.Errorf("Unsupported geometry %q", feature.Geometry.Type)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("Feature %d: %s", i, err)
+			}
+		}
+		for _, position := range positions {
+			point, err := transform.Invert(position)
+			if err != nil {
+				return nil, fmt.Errorf("Feature %d: %s", i, err)
+			}
+			journey.Points = append(journey.Points, point)
+		}
+		journeys[i] = journey
+	}
+	return journeys, nil
+}
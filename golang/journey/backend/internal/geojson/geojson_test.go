@@ -0,0 +1,134 @@
+package geojson
+
+import (
+	"bytes"
+	"fiurgeist/journey/internal/cache"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestTransform(t *testing.T) {
+	point := cache.Point{X: 512, Y: 256}
+	require.Equal(t, Position{512, 256}, Identity.Apply(point))
+
+	// e.g. the grid spanning longitude -180 to 180 and latitude 90 to -90
+	transform := Transform{OriginX: -180, OriginY: 90, ScaleX: 360.0 / 1024, ScaleY: -180.0 / 1024}
+	require.Equal(t, Position{0, 45}, transform.Apply(point))
+}
+
+func TestInvert(t *testing.T) {
+	transform := Transform{OriginX: -180, OriginY: 90, ScaleX: 360.0 / 1024, ScaleY: -180.0 / 1024}
+	point, err := transform.Invert(Position{0.1, 45})
+	require.NoError(t, err)
+	require.Equal(t, cache.Point{X: 512, Y: 256}, point)
+
+	_, err = transform.Invert(Position{-200, 45})
+	require.Error(t, err)
+	require.Equal(t, "Position [-200 45] is outside of the grid", err.Error())
+
+	_, err = Transform{}.Invert(Position{1, 2})
+	require.Error(t, err)
+}
+
+func TestNewFeature(t *testing.T) {
+	journey := cache.Journey{
+		Id:            "23->42",
+		Points:        []cache.Point{{X: 1, Y: 2}, {X: 2, Y: 2}},
+		StartId:       23,
+		DestinationId: 42,
+		FullyMapped:   true,
+	}
+	properties := Properties{StartId: 23, DestinationId: 42, FullyMapped: true}
+
+	require.Equal(
+		t,
+		Feature{
+			Type:       "Feature",
+			Id:         "23->42",
+			Geometry:   &Geometry{Type: "LineString", Coordinates: []Position{{1, 2}, {2, 2}}},
+			Properties: properties,
+		},
+		NewFeature(journey, Identity),
+	)
+
+	journey.Points = journey.Points[:1]
+	require.Equal(
+		t,
+		Feature{
+			Type:       "Feature",
+			Id:         "23->42",
+			Geometry:   &Geometry{Type: "Point", Coordinates: Position{1, 2}},
+			Properties: properties,
+		},
+		NewFeature(journey, Identity),
+	)
+
+	journey.Points = nil
+	require.Equal(t, Feature{Type: "Feature", Id: "23->42", Properties: properties}, NewFeature(journey, Identity))
+}
+
+func TestWrite(t *testing.T) {
+	journeys := []cache.Journey{
+		{Id: "23->42", Points: []cache.Point{{X: 1, Y: 2}, {X: 2, Y: 2}}, StartId: 23, DestinationId: 42},
+		{Id: "42->23", StartId: 42, DestinationId: 23},
+	}
+
+	var buf bytes.Buffer
+	err := Write(&buf, journeys, Transform{OriginX: 10, ScaleX: 2, ScaleY: 0.5})
+	require.NoError(t, err)
+
+	expected := `{"type":"FeatureCollection","features":[` +
+		`{"type":"Feature","id":"23-\u003e42","geometry":{"type":"LineString","coordinates":[[12,1],[14,1]]},` +
+		`"properties":{"startId":23,"destinationId":42,"fullyMapped":false}},` +
+		`{"type":"Feature","id":"42-\u003e23","geometry":null,` +
+		`"properties":{"startId":42,"destinationId":23,"fullyMapped":false}}` +
+		"]}\n"
+	require.Equal(t, expected, buf.String())
+
+	// no journeys is still a valid collection
+	buf.Reset()
+	require.NoError(t, Write(&buf, nil, Identity))
+	require.Equal(t, `{"type":"FeatureCollection","features":[]}`+"\n", buf.String())
+}
+
+func TestRead(t *testing.T) {
+	journeys := []cache.Journey{
+		{
+			Id:            "23->42",
+			Points:        []cache.Point{{X: 1, Y: 2}, {X: 2, Y: 2}},
+			StartId:       23,
+			DestinationId: 42,
+			FullyMapped:   true,
+		},
+		{Id: "42->23", Points: []cache.Point{{X: 11, Y: 12}}, StartId: 42, DestinationId: 23},
+		{Id: "1->2", StartId: 1, DestinationId: 2},
+	}
+	transform := Transform{OriginX: 10, OriginY: -5, ScaleX: 2, ScaleY: 0.5}
+
+	// a round trip keeps the journeys
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, journeys, transform))
+	read, err := Read(&buf, transform)
+	require.NoError(t, err)
+	require.Equal(t, journeys, read)
+}
+
+func TestReadErrors(t *testing.T) {
+	testCases := []struct {
+		input string
+		err   string
+	}{
+		{`{"type":"Feature"}`, "Expected a FeatureCollection, got \"Feature\""},
+		{`{"type":"FeatureCollection","features":[{"geometry":{"type":"Polygon","coordinates":[]}}]}`, "Feature 0: Unsupported geometry \"Polygon\""},
+		{`{"type":"FeatureCollection","features":[{"geometry":{"type":"Point","coordinates":[-1,0]}}]}`, "Feature 0: Position [-1 0] is outside of the grid"},
+	}
+	for _, testCase := range testCases {
+		_, err := Read(bytes.NewBufferString(testCase.input), Identity)
+		require.Error(t, err, testCase.input)
+		require.Equal(t, testCase.err, err.Error())
+	}
+
+	invalid := `{"type":"FeatureCollection","features":[{"geometry":{"type":"LineString","coordinates":[1,2]}}]}`
+	_, err := Read(bytes.NewBufferString(invalid), Identity)
+	require.Error(t, err)
+}
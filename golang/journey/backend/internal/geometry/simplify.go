@@ -0,0 +1,106 @@
+package geometry
+
+import (
+	"fiurgeist/journey/internal/cache"
+	"fmt"
+	"math"
+)
+
+const (
+	SIMPLIFY_COLLINEAR = "collinear"
+	SIMPLIFY_RDP       = "rdp"
+
+	DEFAULT_TOLERANCE = 1.0
+)
+
+// Simplifier returns a line with fewer points, the given points stay untouched
+type Simplifier func(points []cache.Point) []cache.Point
+
+func NewSimplifier(method string, tolerance float64) (Simplifier, error) {
+	if tolerance < 0 {
+		return nil, fmt.Errorf("Invalid tolerance %g", tolerance)
+	}
+	switch method {
+	case SIMPLIFY_COLLINEAR:
+		return RemoveCollinear, nil
+	case SIMPLIFY_RDP:
+		return func(points []cache.Point) []cache.Point {
+			return RamerDouglasPeucker(points, tolerance)
+		}, nil
+	}
+	return nil, fmt.Errorf("Unknown simplification %q", method)
+}
+
+// RemoveCollinear drops points in the middle of a straight segment. A point where the walk
+// turns back is kept, although it is on the same line.
+func RemoveCollinear(points []cache.Point) []cache.Point {
+	if len(points) < 3 {
+		return append([]cache.Point(nil), points...)
+	}
+
+	simplified := []cache.Point{points[0]}
+	for i := 1; i < len(points)-1; i++ {
+		prev, point, next := simplified[len(simplified)-1], points[i], points[i+1]
+		ax, ay := float64(point.X)-float64(prev.X), float64(point.Y)-float64(prev.Y)
+		bx, by := float64(next.X)-float64(point.X), float64(next.Y)-float64(point.Y)
+		if ax*by-ay*bx == 0 && ax*bx+ay*by > 0 {
+			continue
+		}
+		simplified = append(simplified, point)
+	}
+	return append(simplified, points[len(points)-1])
+}
+
+// RamerDouglasPeucker keeps the points deviating more than the tolerance from the line
+// through the points kept around them
+func RamerDouglasPeucker(points []cache.Point, tolerance float64) []cache.Point {
+	if len(points) < 3 {
+		return append([]cache.Point(nil), points...)
+	}
+
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+	// iterative, long journeys would nest deeply
+	stack := [][2]int{{0, len(points) - 1}}
+	for len(stack) > 0 {
+		first, last := stack[len(stack)-1][0], stack[len(stack)-1][1]
+		stack = stack[:len(stack)-1]
+
+		maxDistance, index := -1.0, 0
+		for i := first + 1; i < last; i++ {
+			if d := SegmentDistance(points[i], points[first], points[last]); d > maxDistance {
+				maxDistance, index = d, i
+			}
+		}
+		if maxDistance > tolerance {
+			keep[index] = true
+			stack = append(stack, [2]int{first, index}, [2]int{index, last})
+		}
+	}
+
+	var simplified []cache.Point
+	for i, point := range points {
+		if keep[i] {
+			simplified = append(simplified, point)
+		}
+	}
+	return simplified
+}
+
+// Distance is the euclidean distance in grid units
+func Distance(a, b cache.Point) float64 {
+	return math.Hypot(float64(b.X)-float64(a.X), float64(b.Y)-float64(a.Y))
+}
+
+// SegmentDistance is the distance of a point to the closest point of the segment a-b
+func SegmentDistance(point, a, b cache.Point) float64 {
+	dx, dy := float64(b.X)-float64(a.X), float64(b.Y)-float64(a.Y)
+	lengthSquared := dx*dx + dy*dy
+	if lengthSquared == 0 {
+		return Distance(point, a)
+	}
+
+	t := ((float64(point.X)-float64(a.X))*dx + (float64(point.Y)-float64(a.Y))*dy) / lengthSquared
+	t = math.Max(0, math.Min(1, t))
+	return math.Hypot(float64(a.X)+t*dx-float64(point.X), float64(a.Y)+t*dy-float64(point.Y))
+}
@@ -0,0 +1,73 @@
+package geometry
+
+import (
+	"fiurgeist/journey/internal/cache"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRemoveCollinear(t *testing.T) {
+	// straight east, diagonal, then back west along the same line
+	points := []cache.Point{
+		{X: 0, Y: 0}, {X: 1, Y: 0}, {X: 2, Y: 0}, {X: 3, Y: 1}, {X: 4, Y: 2}, {X: 5, Y: 2}, {X: 3, Y: 2},
+	}
+	original := append([]cache.Point(nil), points...)
+
+	require.Equal(
+		t,
+		[]cache.Point{{X: 0, Y: 0}, {X: 2, Y: 0}, {X: 4, Y: 2}, {X: 5, Y: 2}, {X: 3, Y: 2}},
+		RemoveCollinear(points),
+	)
+	require.Equal(t, original, points)
+
+	require.Equal(t, []cache.Point{{X: 1, Y: 2}, {X: 3, Y: 4}}, RemoveCollinear([]cache.Point{{X: 1, Y: 2}, {X: 3, Y: 4}}))
+	require.Empty(t, RemoveCollinear(nil))
+}
+
+func TestRamerDouglasPeucker(t *testing.T) {
+	// a small bump on a straight line
+	points := []cache.Point{{X: 0, Y: 0}, {X: 1, Y: 0}, {X: 2, Y: 1}, {X: 3, Y: 0}, {X: 10, Y: 0}, {X: 10, Y: 5}}
+	original := append([]cache.Point(nil), points...)
+
+	require.Equal(
+		t,
+		[]cache.Point{{X: 0, Y: 0}, {X: 2, Y: 1}, {X: 3, Y: 0}, {X: 10, Y: 0}, {X: 10, Y: 5}},
+		RamerDouglasPeucker(points, 0.5),
+	)
+	require.Equal(t, []cache.Point{{X: 0, Y: 0}, {X: 10, Y: 0}, {X: 10, Y: 5}}, RamerDouglasPeucker(points, 1))
+	require.Equal(t, []cache.Point{{X: 0, Y: 0}, {X: 10, Y: 5}}, RamerDouglasPeucker(points, 10))
+	require.Equal(t, points, RamerDouglasPeucker(points, 0))
+	require.Equal(t, original, points)
+
+	// a loop back to the start
+	loop := []cache.Point{{X: 0, Y: 0}, {X: 5, Y: 0}, {X: 5, Y: 5}, {X: 0, Y: 1}}
+	require.Equal(t, loop, RamerDouglasPeucker(loop, 1))
+}
+
+func TestNewSimplifier(t *testing.T) {
+	points := []cache.Point{{X: 0, Y: 0}, {X: 1, Y: 0}, {X: 2, Y: 1}, {X: 3, Y: 0}}
+
+	simplify, err := NewSimplifier(SIMPLIFY_RDP, 2)
+	require.NoError(t, err)
+	require.Equal(t, []cache.Point{{X: 0, Y: 0}, {X: 3, Y: 0}}, simplify(points))
+
+	simplify, err = NewSimplifier(SIMPLIFY_COLLINEAR, 0)
+	require.NoError(t, err)
+	require.Equal(t, points, simplify(points))
+
+	_, err = NewSimplifier("foo", 1)
+	require.Error(t, err)
+	require.Equal(t, "Unknown simplification \"foo\"", err.Error())
+
+	_, err = NewSimplifier(SIMPLIFY_RDP, -1)
+	require.Error(t, err)
+	require.Equal(t, "Invalid tolerance -1", err.Error())
+}
+
+func TestSegmentDistance(t *testing.T) {
+	a, b := cache.Point{X: 0, Y: 0}, cache.Point{X: 4, Y: 0}
+	require.Equal(t, 3.0, SegmentDistance(cache.Point{X: 2, Y: 3}, a, b))
+	// beyond the end of the segment
+	require.Equal(t, 5.0, SegmentDistance(cache.Point{X: 7, Y: 4}, a, b))
+	require.Equal(t, 5.0, SegmentDistance(cache.Point{X: 3, Y: 4}, a, a))
+}
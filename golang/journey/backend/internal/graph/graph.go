@@ -0,0 +1,121 @@
+package graph
+
+import (
+	"container/heap"
+	"errors"
+	"fiurgeist/journey/internal/cache"
+)
+
+var ErrNoRoute = errors.New("No route between the locations")
+
+// Edge is a journey from one location to another, weighted by its walked length
+type Edge struct {
+	From    uint16
+	To      uint16
+	Weight  float64
+	Journey cache.Journey
+}
+
+// Graph of the locations connected by journeys, it is a snapshot of the given journeys
+type Graph struct {
+	edges map[uint16][]Edge
+}
+
+// Route is a path over several journeys
+type Route struct {
+	From     uint16        `json:"from"`
+	To       uint16        `json:"to"`
+	Length   float64       `json:"length"`
+	Journeys []string      `json:"journeys"`
+	Points   []cache.Point `json:"points"`
+}
+
+// New builds the graph, optionally only of the fully mapped journeys whose length is known.
+// Undirected journeys can be walked both ways. Journeys without a walked length, i.e. with
+// fewer than two points, aren't mapped far enough to be a free shortcut and are skipped.
+func New(journeys []cache.Journey, fullyMappedOnly bool) *Graph {
+	g := &Graph{edges: make(map[uint16][]Edge)}
+	for _, journey := range journeys {
+		if fullyMappedOnly && !journey.FullyMapped || journey.Stats.Length <= 0 {
+			continue
+		}
+		g.add(journey)
+		if journey.Undirected {
+			g.add(journey.Reversed())
+		}
+	}
+	return g
+}
+
+func (g *Graph) add(journey cache.Journey) {
+	g.edges[journey.StartId] = append(g.edges[journey.StartId], Edge{
+		From:    journey.StartId,
+		To:      journey.DestinationId,
+		Weight:  journey.Stats.Length,
+		Journey: journey,
+	})
+}
+
+// ShortestRoute finds the route with the least walked length with Dijkstra. There are no
+// coordinates of the locations, so there's no heuristic for A*.
+func (g *Graph) ShortestRoute(from, to uint16) (Route, error) {
+	distances := map[uint16]float64{from: 0}
+	previous := make(map[uint16]Edge)
+	visited := make(map[uint16]bool)
+	queue := &priorityQueue{{location: from}}
+
+	for queue.Len() > 0 {
+		current := heap.Pop(queue).(item)
+		if visited[current.location] {
+			continue
+		}
+		visited[current.location] = true
+		if current.location == to {
+			return g.route(from, to, previous), nil
+		}
+
+		for _, edge := range g.edges[current.location] {
+			distance := current.distance + edge.Weight
+			if known, ok := distances[edge.To]; !ok || distance < known {
+				distances[edge.To] = distance
+				previous[edge.To] = edge
+				heap.Push(queue, item{location: edge.To, distance: distance})
+			}
+		}
+	}
+	return Route{}, ErrNoRoute
+}
+
+func (g *Graph) route(from, to uint16, previous map[uint16]Edge) Route {
+	var edges []Edge
+	for location := to; location != from; location = previous[location].From {
+		edges = append(edges, previous[location])
+	}
+
+	route := Route{From: from, To: to, Journeys: []string{}, Points: []cache.Point{}}
+	for i := len(edges) - 1; i >= 0; i-- {
+		route.Length += edges[i].Weight
+		route.Journeys = append(route.Journeys, edges[i].Journey.Id)
+		route.Points = append(route.Points, edges[i].Journey.Points...)
+	}
+	return route
+}
+
+type item struct {
+	location uint16
+	distance float64
+}
+
+// priorityQueue implements heap.Interface, the closest location first
+type priorityQueue []item
+
+func (q priorityQueue) Len() int            { return len(q) }
+func (q priorityQueue) Less(i, j int) bool  { return q[i].distance < q[j].distance }
+func (q priorityQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *priorityQueue) Push(x interface{}) { *q = append(*q, x.(item)) }
+func (q *priorityQueue) Pop() interface{} {
+	old := *q
+	last := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return last
+}
@@ -0,0 +1,92 @@
+package graph
+
+import (
+	"fiurgeist/journey/internal/cache"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func newJourney(start, dest uint16, length float64, fullyMapped bool, points ...cache.Point) cache.Journey {
+	return cache.Journey{
+		Id:            cache.JourneyId(start, dest),
+		Points:        points,
+		StartId:       start,
+		DestinationId: dest,
+		FullyMapped:   fullyMapped,
+		Stats:         cache.Stats{Length: length},
+	}
+}
+
+var journeys = []cache.Journey{
+	newJourney(1, 2, 5, true, cache.Point{X: 1, Y: 1}),
+	newJourney(2, 3, 5, true, cache.Point{X: 2, Y: 2}),
+	newJourney(1, 3, 20, true, cache.Point{X: 3, Y: 3}),
+	// shorter, but not fully mapped
+	newJourney(1, 4, 1, false, cache.Point{X: 4, Y: 4}),
+	newJourney(4, 3, 1, false, cache.Point{X: 5, Y: 5}),
+	// just started, it would be free
+	newJourney(1, 3, 0, false),
+}
+
+func TestShortestRoute(t *testing.T) {
+	route, err := New(journeys, true).ShortestRoute(1, 3)
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		Route{
+			From:     1,
+			To:       3,
+			Length:   10,
+			Journeys: []string{"1->2", "2->3"},
+			Points:   []cache.Point{{X: 1, Y: 1}, {X: 2, Y: 2}},
+		},
+		route,
+	)
+
+	route, err = New(journeys, false).ShortestRoute(1, 3)
+	require.NoError(t, err)
+	require.Equal(t, []string{"1->4", "4->3"}, route.Journeys)
+	require.Equal(t, 2.0, route.Length)
+
+	// staying is no route
+	route, err = New(journeys, true).ShortestRoute(2, 2)
+	require.NoError(t, err)
+	require.Equal(t, Route{From: 2, To: 2, Journeys: []string{}, Points: []cache.Point{}}, route)
+}
+
+func TestShortestRouteNoRoute(t *testing.T) {
+	g := New(journeys, true)
+
+	// journeys are directed
+	_, err := g.ShortestRoute(3, 1)
+	require.Equal(t, ErrNoRoute, err)
+
+	_, err = g.ShortestRoute(1, 4)
+	require.Equal(t, ErrNoRoute, err)
+
+	_, err = New(nil, false).ShortestRoute(1, 2)
+	require.Equal(t, ErrNoRoute, err)
+
+	// without a walked length yet
+	_, err = New([]cache.Journey{newJourney(1, 2, 0, false, cache.Point{X: 1, Y: 1})}, false).ShortestRoute(1, 2)
+	require.Equal(t, ErrNoRoute, err)
+}
+
+func TestShortestRouteUndirected(t *testing.T) {
+	journey := newJourney(1, 2, 5, true, cache.Point{X: 1, Y: 1}, cache.Point{X: 2, Y: 2})
+	journey.Undirected = true
+
+	route, err := New([]cache.Journey{journey}, true).ShortestRoute(2, 1)
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		Route{
+			From:     2,
+			To:       1,
+			Length:   5,
+			Journeys: []string{"2->1"},
+			Points:   []cache.Point{{X: 2, Y: 2}, {X: 1, Y: 1}},
+		},
+		route,
+	)
+}
@@ -0,0 +1,79 @@
+package health
+
+import (
+	"fiurgeist/journey/internal/queue"
+	"fmt"
+	"sync"
+)
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+type Check func() error
+
+type ComponentStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components,omitempty"`
+}
+
+type Checker interface {
+	Register(name string, check Check)
+	Check() Report
+}
+
+type checker struct {
+	mu     sync.RWMutex
+	checks map[string]Check
+}
+
+func NewChecker() *checker {
+	return &checker{
+		checks: make(map[string]Check),
+	}
+}
+
+func (c *checker) Register(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checks[name] = check
+}
+
+// Check runs all registered checks, the report is only up if every component is up
+func (c *checker) Check() Report {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	report := Report{
+		Status:     StatusUp,
+		Components: make(map[string]ComponentStatus, len(c.checks)),
+	}
+	for name, check := range c.checks {
+		if err := check(); err != nil {
+			report.Status = StatusDown
+			report.Components[name] = ComponentStatus{Status: StatusDown, Error: err.Error()}
+			continue
+		}
+		report.Components[name] = ComponentStatus{Status: StatusUp}
+	}
+	return report
+}
+
+// QueueFill fails once the queue is filled above the threshold (0-1), i.e. the consumer
+// can't keep up and pushing will soon block
+func QueueFill(q queue.Queue, threshold float64) Check {
+	return func() error {
+		ratio := float64(q.Len()) / float64(q.Cap())
+		if ratio > threshold {
+			return fmt.Errorf("Queue filled to %.0f%%", ratio*100)
+		}
+		return nil
+	}
+}
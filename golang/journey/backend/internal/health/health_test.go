@@ -0,0 +1,50 @@
+package health
+
+import (
+	"errors"
+	"fiurgeist/journey/internal/queue"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	checker := NewChecker()
+
+	// nothing to check
+	require.Equal(t, Report{Status: StatusUp, Components: map[string]ComponentStatus{}}, checker.Check())
+
+	checker.Register("foo", func() error { return nil })
+	require.Equal(
+		t,
+		Report{Status: StatusUp, Components: map[string]ComponentStatus{"foo": {Status: StatusUp}}},
+		checker.Check(),
+	)
+
+	// a single failing component sets the whole report down
+	checker.Register("bar", func() error { return errors.New("broken") })
+	require.Equal(
+		t,
+		Report{
+			Status: StatusDown,
+			Components: map[string]ComponentStatus{
+				"foo": {Status: StatusUp},
+				"bar": {Status: StatusDown, Error: "broken"},
+			},
+		},
+		checker.Check(),
+	)
+}
+
+func TestQueueFill(t *testing.T) {
+	mockQueue := &queue.MockQueue{}
+	mockQueue.On("Cap").Return(10)
+	check := QueueFill(mockQueue, 0.8)
+
+	mockQueue.On("Len").Return(8).Once()
+	require.NoError(t, check())
+
+	mockQueue.On("Len").Return(9).Once()
+	err := check()
+	require.Error(t, err)
+	require.Equal(t, "Queue filled to 90%", err.Error())
+}
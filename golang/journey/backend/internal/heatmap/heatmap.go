@@ -0,0 +1,127 @@
+package heatmap
+
+import (
+	"fiurgeist/journey/internal/cache"
+	"fiurgeist/journey/internal/queue"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"sync"
+)
+
+const DEFAULT_CELL = 16
+
+// Heatmap counts the recorded points per grid unit of the bounds of a world, they are summed up
+// into cells on read
+type Heatmap struct {
+	mu     sync.RWMutex
+	bounds cache.BoundingBox
+	width  int
+	height int
+	counts []uint32
+}
+
+// Grid are the counts of square cells, row by row from the minimum of the bounds
+type Grid struct {
+	Cell   int        `json:"cell"`
+	MinX   uint16     `json:"minX"`
+	MinY   uint16     `json:"minY"`
+	Width  int        `json:"width"`
+	Height int        `json:"height"`
+	Max    uint32     `json:"max"`
+	Counts [][]uint32 `json:"counts"`
+}
+
+// New creates the heatmap of the bounds of a world, points beyond aren't counted
+func New(bounds cache.BoundingBox) *Heatmap {
+	width := int(bounds.MaxX) - int(bounds.MinX) + 1
+	height := int(bounds.MaxY) - int(bounds.MinY) + 1
+	return &Heatmap{bounds: bounds, width: width, height: height, counts: make([]uint32, width*height)}
+}
+
+// Observe counts the new locations of the journeys, it is meant as observer of the queue
+func (h *Heatmap) Observe(msg interface{}) {
+	if location, ok := msg.(queue.NewLocation); ok {
+		h.Add(cache.Point{X: location.X, Y: location.Y})
+	}
+}
+
+// Add counts a point, e.g. of the journeys loaded from the store
+func (h *Heatmap) Add(point cache.Point) {
+	if !h.bounds.Contains(point) {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.counts[int(point.Y-h.bounds.MinY)*h.width+int(point.X-h.bounds.MinX)]++
+}
+
+// Grid sums up the counts into cells of the given size in grid units
+func (h *Heatmap) Grid(cell int) (Grid, error) {
+	size := h.width
+	if h.height > size {
+		size = h.height
+	}
+	if cell <= 0 || cell > size {
+		return Grid{}, fmt.Errorf("Invalid cell size %d, expected 1 to %d", cell, size)
+	}
+	grid := Grid{
+		Cell:   cell,
+		MinX:   h.bounds.MinX,
+		MinY:   h.bounds.MinY,
+		Width:  (h.width + cell - 1) / cell,
+		Height: (h.height + cell - 1) / cell,
+	}
+	grid.Counts = make([][]uint32, grid.Height)
+	for row := range grid.Counts {
+		grid.Counts[row] = make([]uint32, grid.Width)
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for y := 0; y < h.height; y++ {
+		row := grid.Counts[y/cell]
+		for x, count := range h.counts[y*h.width : (y+1)*h.width] {
+			row[x/cell] += count
+		}
+	}
+	for _, row := range grid.Counts {
+		for _, count := range row {
+			if count > grid.Max {
+				grid.Max = count
+			}
+		}
+	}
+	return grid, nil
+}
+
+// WritePNG renders the grid with one pixel per grid unit. Empty cells are transparent, the others
+// shade from blue to red on a logarithmic scale, so sparse areas remain visible.
+func WritePNG(w io.Writer, grid Grid) error {
+	img := image.NewNRGBA(image.Rect(0, 0, grid.Width*grid.Cell, grid.Height*grid.Cell))
+	for row, counts := range grid.Counts {
+		for column, count := range counts {
+			if count == 0 {
+				continue
+			}
+			c := heat(math.Log1p(float64(count)) / math.Log1p(float64(grid.Max)))
+			for y := row * grid.Cell; y < (row+1)*grid.Cell; y++ {
+				for x := column * grid.Cell; x < (column+1)*grid.Cell; x++ {
+					img.SetNRGBA(x, y, c)
+				}
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// heat is the color of an intensity from 0 to 1
+func heat(intensity float64) color.NRGBA {
+	red := uint8(math.Round(255 * intensity))
+	return color.NRGBA{R: red, G: 0, B: 255 - red, A: 255}
+}
@@ -0,0 +1,67 @@
+package heatmap
+
+import (
+	"bytes"
+	"fiurgeist/journey/internal/cache"
+	"fiurgeist/journey/internal/queue"
+	"github.com/stretchr/testify/require"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestGrid(t *testing.T) {
+	heatmap := New(cache.BoundingBox{MaxX: 3, MaxY: 3})
+	for _, point := range []cache.Point{{X: 0, Y: 0}, {X: 1, Y: 1}, {X: 3, Y: 2}} {
+		heatmap.Add(point)
+	}
+	heatmap.Observe(queue.NewLocation{X: 1, Y: 0})
+	heatmap.Observe(queue.NewJourney{StartId: 1, DestinationId: 2})
+	// outside of the grid
+	heatmap.Add(cache.Point{X: 4, Y: 0})
+
+	grid, err := heatmap.Grid(2)
+	require.NoError(t, err)
+	require.Equal(t, Grid{Cell: 2, Width: 2, Height: 2, Max: 3, Counts: [][]uint32{{3, 0}, {0, 1}}}, grid)
+
+	// the last cell is cut off by the grid
+	grid, err = heatmap.Grid(3)
+	require.NoError(t, err)
+	require.Equal(t, Grid{Cell: 3, Width: 2, Height: 2, Max: 3, Counts: [][]uint32{{3, 1}, {0, 0}}}, grid)
+}
+
+func TestGridBounds(t *testing.T) {
+	heatmap := New(cache.BoundingBox{MinX: 10, MinY: 20, MaxX: 13, MaxY: 21})
+	for _, point := range []cache.Point{{X: 10, Y: 20}, {X: 13, Y: 21}, {X: 9, Y: 20}, {X: 10, Y: 22}} {
+		heatmap.Add(point)
+	}
+
+	grid, err := heatmap.Grid(2)
+	require.NoError(t, err)
+	require.Equal(t, Grid{Cell: 2, MinX: 10, MinY: 20, Width: 2, Height: 1, Max: 1, Counts: [][]uint32{{1, 1}}}, grid)
+}
+
+func TestGridInvalidCell(t *testing.T) {
+	heatmap := New(cache.BoundingBox{MaxX: 3, MaxY: 3})
+
+	_, err := heatmap.Grid(0)
+	require.Error(t, err)
+	require.Equal(t, "Invalid cell size 0, expected 1 to 4", err.Error())
+
+	_, err = heatmap.Grid(5)
+	require.Error(t, err)
+}
+
+func TestWritePNG(t *testing.T) {
+	grid := Grid{Cell: 2, Width: 2, Height: 1, Max: 4, Counts: [][]uint32{{4, 0}}}
+
+	var buf bytes.Buffer
+	require.NoError(t, WritePNG(&buf, grid))
+
+	img, err := png.Decode(&buf)
+	require.NoError(t, err)
+	require.Equal(t, 4, img.Bounds().Dx())
+	require.Equal(t, 2, img.Bounds().Dy())
+	require.Equal(t, color.NRGBA{R: 255, A: 255}, img.At(1, 1))
+	require.Equal(t, color.NRGBA{}, img.At(2, 0))
+}
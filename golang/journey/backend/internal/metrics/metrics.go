@@ -10,21 +10,29 @@ type Metrics interface {
 	Close()
 	LogRequest()
 	LogJourney()
+	LogConnectionOpened()
+	LogConnectionClosed()
+	LogDiscarded()
+	LogImplausibleMovement()
 }
 
 type metrics struct {
-	requestCount uint64
-	journeyCount uint64
-	runningSince time.Time
-	quit         chan bool
+	requestCount    uint64
+	journeyCount    uint64
+	connectionCount int64
+	discardedCount  uint64
+	// implausibleCount counts rejected and flagged movements
+	implausibleCount uint64
+	runningSince     time.Time
+	quit             chan bool
 }
 
-func NewMetrics() *metrics {
+func NewMetrics(interval time.Duration) *metrics {
 	quit := make(chan bool)
 	m := newMetrics(quit)
 
 	go func() {
-		tick := time.Tick(time.Second)
+		tick := time.Tick(interval)
 		for {
 			select {
 			case <-m.quit:
@@ -59,12 +67,33 @@ func (m *metrics) LogJourney() {
 	atomic.AddUint64(&m.journeyCount, 1)
 }
 
+func (m *metrics) LogConnectionOpened() {
+	atomic.AddInt64(&m.connectionCount, 1)
+}
+
+func (m *metrics) LogConnectionClosed() {
+	atomic.AddInt64(&m.connectionCount, -1)
+}
+
+// LogDiscarded counts messages dropped because there is no store to write them
+func (m *metrics) LogDiscarded() {
+	atomic.AddUint64(&m.discardedCount, 1)
+}
+
+// LogImplausibleMovement counts movements failing the plausibility check of the cache
+func (m *metrics) LogImplausibleMovement() {
+	atomic.AddUint64(&m.implausibleCount, 1)
+}
+
 func (m *metrics) print() {
 	since := time.Since(m.runningSince)
 	log.Printf(
-		"Running since %s; received %.2f req/sec; %d unique journeys\n",
+		"Running since %s; received %.2f req/sec; %d unique journeys; %d open connections; %d discarded messages; %d implausible movements\n",
 		since,
 		float64(atomic.LoadUint64(&m.requestCount))/since.Seconds(),
 		atomic.LoadUint64(&m.journeyCount),
+		atomic.LoadInt64(&m.connectionCount),
+		atomic.LoadUint64(&m.discardedCount),
+		atomic.LoadUint64(&m.implausibleCount),
 	)
 }
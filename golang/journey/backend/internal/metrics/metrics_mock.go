@@ -16,6 +16,22 @@ func (m *MockMetrics) LogJourney() {
 	m.Called()
 }
 
+func (m *MockMetrics) LogConnectionOpened() {
+	m.Called()
+}
+
+func (m *MockMetrics) LogConnectionClosed() {
+	m.Called()
+}
+
+func (m *MockMetrics) LogDiscarded() {
+	m.Called()
+}
+
+func (m *MockMetrics) LogImplausibleMovement() {
+	m.Called()
+}
+
 func (m *MockMetrics) Close() {
 	m.Called()
 }
@@ -36,6 +36,33 @@ func TestLogJourney(t *testing.T) {
 	require.Equal(t, uint64(1), metrics.journeyCount)
 }
 
+func TestLogConnection(t *testing.T) {
+	metrics := newMetrics(nil)
+
+	require.Equal(t, int64(0), metrics.connectionCount)
+	metrics.LogConnectionOpened()
+	metrics.LogConnectionOpened()
+	require.Equal(t, int64(2), metrics.connectionCount)
+	metrics.LogConnectionClosed()
+	require.Equal(t, int64(1), metrics.connectionCount)
+}
+
+func TestLogDiscarded(t *testing.T) {
+	metrics := newMetrics(nil)
+
+	require.Equal(t, uint64(0), metrics.discardedCount)
+	metrics.LogDiscarded()
+	require.Equal(t, uint64(1), metrics.discardedCount)
+}
+
+func TestLogImplausibleMovement(t *testing.T) {
+	metrics := newMetrics(nil)
+
+	require.Equal(t, uint64(0), metrics.implausibleCount)
+	metrics.LogImplausibleMovement()
+	require.Equal(t, uint64(1), metrics.implausibleCount)
+}
+
 func TestPrint(t *testing.T) {
 	patchRunningSince, err := mpatch.PatchMethod(time.Now, mockRunningSince)
 	require.NoError(t, err)
@@ -51,7 +78,7 @@ func TestPrint(t *testing.T) {
 	require.True(t, scanner.Scan())
 	require.Equal(
 		t,
-		"2021/01/01 00:00:00 Running since 0s; received NaN req/sec; 0 unique journeys",
+		"2021/01/01 00:00:00 Running since 0s; received NaN req/sec; 0 unique journeys; 0 open connections; 0 discarded messages; 0 implausible movements",
 		scanner.Text(),
 	)
 
@@ -61,6 +88,9 @@ func TestPrint(t *testing.T) {
 	// with updated values
 	metrics.requestCount = uint64(42)
 	metrics.journeyCount = uint64(23)
+	metrics.connectionCount = int64(2)
+	metrics.discardedCount = uint64(5)
+	metrics.implausibleCount = uint64(3)
 
 	// test print two seconds later
 	patchNow, err := mpatch.PatchMethod(time.Now, mockNow)
@@ -71,7 +101,7 @@ func TestPrint(t *testing.T) {
 	require.True(t, scanner.Scan())
 	require.Equal(
 		t,
-		"2021/01/01 00:00:02 Running since 2s; received 21.00 req/sec; 23 unique journeys",
+		"2021/01/01 00:00:02 Running since 2s; received 21.00 req/sec; 23 unique journeys; 2 open connections; 5 discarded messages; 3 implausible movements",
 		scanner.Text(),
 	)
 }
@@ -84,13 +114,13 @@ func TestNewMetrics(t *testing.T) {
 	scanner, reader, writer := mockLogger(t)
 	defer resetLogger(reader, writer)
 
-	metrics := NewMetrics()
+	metrics := NewMetrics(time.Second)
 
 	// test printing
 	require.True(t, scanner.Scan())
 	require.Equal(
 		t,
-		"2021/01/01 00:00:00 Running since 0s; received NaN req/sec; 0 unique journeys",
+		"2021/01/01 00:00:00 Running since 0s; received NaN req/sec; 0 unique journeys; 0 open connections; 0 discarded messages; 0 implausible movements",
 		scanner.Text(),
 	)
 
@@ -0,0 +1,62 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	TYPE_NEW_JOURNEY          = "NewJourney"
+	TYPE_NEW_LOCATION         = "NewLocation"
+	TYPE_JOURNEY_FULLY_MAPPED = "JourneyFullyMapped"
+	TYPE_JOURNEY_STATS        = "JourneyStats"
+	TYPE_JOURNEY_PATH         = "JourneyPath"
+)
+
+// EncodeMessage serializes a message together with its type, to be read by DecodeMessage
+func EncodeMessage(msg interface{}) (string, json.RawMessage, error) {
+	var msgType string
+	switch msg.(type) {
+	case NewJourney:
+		msgType = TYPE_NEW_JOURNEY
+	case NewLocation:
+		msgType = TYPE_NEW_LOCATION
+	case JourneyFullyMapped:
+		msgType = TYPE_JOURNEY_FULLY_MAPPED
+	case JourneyStats:
+		msgType = TYPE_JOURNEY_STATS
+	case JourneyPath:
+		msgType = TYPE_JOURNEY_PATH
+	default:
+		return "", nil, fmt.Errorf("Unknown message type %T", msg)
+	}
+
+	data, err := json.Marshal(msg)
+	return msgType, data, err
+}
+
+func DecodeMessage(msgType string, data json.RawMessage) (interface{}, error) {
+	switch msgType {
+	case TYPE_NEW_JOURNEY:
+		var msg NewJourney
+		err := json.Unmarshal(data, &msg)
+		return msg, err
+	case TYPE_NEW_LOCATION:
+		var msg NewLocation
+		err := json.Unmarshal(data, &msg)
+		return msg, err
+	case TYPE_JOURNEY_FULLY_MAPPED:
+		var msg JourneyFullyMapped
+		err := json.Unmarshal(data, &msg)
+		return msg, err
+	case TYPE_JOURNEY_STATS:
+		var msg JourneyStats
+		err := json.Unmarshal(data, &msg)
+		return msg, err
+	case TYPE_JOURNEY_PATH:
+		var msg JourneyPath
+		err := json.Unmarshal(data, &msg)
+		return msg, err
+	}
+	return nil, fmt.Errorf("Unknown message type %q", msgType)
+}
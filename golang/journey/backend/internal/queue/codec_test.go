@@ -0,0 +1,42 @@
+package queue
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestEncodeDecodeMessage(t *testing.T) {
+	msgs := []interface{}{
+		NewJourney{StartId: 23, DestinationId: 42},
+		NewJourney{World: "moon", StartId: 23, DestinationId: 42},
+		NewLocation{StartId: 23, DestinationId: 42, X: 1, Y: 2, Seq: 3},
+		JourneyFullyMapped{StartId: 23, DestinationId: 42},
+		JourneyStats{StartId: 23, DestinationId: 42, Length: 1.5, PointCount: 2, MinX: 1, MaxX: 2, MaxY: 3, Characters: 1},
+		JourneyPath{World: "moon", StartId: 23, DestinationId: 42, Points: []Location{{X: 1, Y: 2}, {X: 3, Y: 4}}},
+	}
+
+	for _, msg := range msgs {
+		msgType, data, err := EncodeMessage(msg)
+		require.NoError(t, err)
+
+		decoded, err := DecodeMessage(msgType, data)
+		require.NoError(t, err)
+		require.Equal(t, msg, decoded)
+	}
+}
+
+func TestEncodeMessageUnknownType(t *testing.T) {
+	_, _, err := EncodeMessage(struct{}{})
+	require.Error(t, err)
+	require.Equal(t, "Unknown message type struct {}", err.Error())
+}
+
+func TestDecodeMessageErrors(t *testing.T) {
+	_, err := DecodeMessage("foo", json.RawMessage(`{}`))
+	require.Error(t, err)
+	require.Equal(t, "Unknown message type \"foo\"", err.Error())
+
+	_, err = DecodeMessage(TYPE_NEW_JOURNEY, json.RawMessage(`{"StartId": "foo"}`))
+	require.Error(t, err)
+}
@@ -1,35 +1,71 @@
 package queue
 
+import "time"
+
 const CHANNEL_BUFFER_SIZE = 1024 * 1024
 
+// The messages carry the world of the journey, which is empty for the default world
+
 type NewJourney struct {
+	World         string `json:",omitempty"`
 	StartId       uint16
 	DestinationId uint16
+	// DiscoveredAt is zero for journeys not discovered by the cache, e.g. imported ones
+	DiscoveredAt time.Time
 }
 type NewLocation struct {
+	World         string `json:",omitempty"`
 	StartId       uint16
 	DestinationId uint16
 	X             uint16
 	Y             uint16
+	Seq           int // position of the location within the journey
 }
 type JourneyFullyMapped struct {
+	World         string `json:",omitempty"`
 	StartId       uint16
 	DestinationId uint16
 }
+type JourneyStats struct {
+	World         string `json:",omitempty"`
+	StartId       uint16
+	DestinationId uint16
+	Length        float64
+	PointCount    int
+	MinX          uint16
+	MinY          uint16
+	MaxX          uint16
+	MaxY          uint16
+	Characters    int
+}
+
+// JourneyPath replaces all locations of a journey, numbered from 0
+type JourneyPath struct {
+	World         string `json:",omitempty"`
+	StartId       uint16
+	DestinationId uint16
+	Points        []Location
+}
+type Location struct {
+	X uint16
+	Y uint16
+}
 
 type Queue interface {
 	Close()
 	Push(msg interface{})
 	GetChannel() chan interface{}
+	Len() int
+	Cap() int
 }
 
 type queue struct {
 	channel chan interface{}
 }
 
-func NewQueue() *queue {
+func NewQueue(size int) *queue {
 	q := &queue{
-		channel: make(chan interface{}, CHANNEL_BUFFER_SIZE),
+		channel: make(chan interface{}, size),
 	}
 	return q
 }
@@ -45,3 +81,38 @@ func (q *queue) Push(msg interface{}) {
 func (q *queue) GetChannel() chan interface{} {
 	return q.channel
 }
+
+func (q *queue) Len() int {
+	return len(q.channel)
+}
+
+func (q *queue) Cap() int {
+	return cap(q.channel)
+}
+
+type observedQueue struct {
+	Queue
+	observers []func(msg interface{})
+}
+
+// NewObservedQueue passes every message pushed into the queue to the observers as well
+func NewObservedQueue(q Queue, observers ...func(msg interface{})) *observedQueue {
+	return &observedQueue{
+		Queue:     q,
+		observers: observers,
+	}
+}
+
+func (q *observedQueue) Push(msg interface{}) {
+	q.Queue.Push(msg)
+	for _, observe := range q.observers {
+		observe(msg)
+	}
+}
+
+// Drain consumes the queue until it is closed, for running without a store
+func Drain(q Queue, discarded func(msg interface{})) {
+	for msg := range q.GetChannel() {
+		discarded(msg)
+	}
+}
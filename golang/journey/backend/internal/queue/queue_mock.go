@@ -17,6 +17,16 @@ func (m *MockQueue) GetChannel() chan interface{} {
 	return args.Get(0).(chan interface{})
 }
 
+func (m *MockQueue) Len() int {
+	args := m.Called()
+	return args.Int(0)
+}
+
+func (m *MockQueue) Cap() int {
+	args := m.Called()
+	return args.Int(0)
+}
+
 func (m *MockQueue) Close() {
 	m.Called()
 }
@@ -6,22 +6,30 @@ import (
 )
 
 func TestNewQueue(t *testing.T) {
-	queue := NewQueue()
+	queue := NewQueue(CHANNEL_BUFFER_SIZE)
 
 	require.Equal(t, 0, len(queue.channel))
 	require.Equal(t, CHANNEL_BUFFER_SIZE, cap(queue.channel))
 }
 
 func TestClose(t *testing.T) {
-	queue := NewQueue()
+	queue := NewQueue(CHANNEL_BUFFER_SIZE)
 
 	queue.Close()
 	_, ok := <-queue.channel
 	require.False(t, ok)
 }
 
+func TestLenCap(t *testing.T) {
+	queue := NewQueue(2)
+	queue.Push(struct{}{})
+
+	require.Equal(t, 1, queue.Len())
+	require.Equal(t, 2, queue.Cap())
+}
+
 func Push(t *testing.T) {
-	queue := NewQueue()
+	queue := NewQueue(CHANNEL_BUFFER_SIZE)
 
 	require.Equal(t, 0, len(queue.channel))
 
@@ -31,9 +39,39 @@ func Push(t *testing.T) {
 }
 
 func GetChannel(t *testing.T) {
-	queue := NewQueue()
+	queue := NewQueue(CHANNEL_BUFFER_SIZE)
 	queue.Push(struct{ foo int }{foo: 42})
 
 	require.Equal(t, 1, len(queue.GetChannel()))
 	require.Equal(t, struct{ foo int }{foo: 42}, <-queue.GetChannel())
 }
+
+func TestDrain(t *testing.T) {
+	queue := NewQueue(10)
+	queue.Push(NewJourney{StartId: 23, DestinationId: 42})
+	queue.Push(NewJourney{StartId: 42, DestinationId: 23})
+	queue.Close()
+
+	// returns once the queue is closed
+	var discarded []interface{}
+	Drain(queue, func(msg interface{}) { discarded = append(discarded, msg) })
+	require.Equal(
+		t,
+		[]interface{}{NewJourney{StartId: 23, DestinationId: 42}, NewJourney{StartId: 42, DestinationId: 23}},
+		discarded,
+	)
+}
+
+func TestObservedQueuePush(t *testing.T) {
+	mockQueue := &MockQueue{}
+	var observed []interface{}
+	queue := NewObservedQueue(mockQueue, func(msg interface{}) { observed = append(observed, msg) })
+
+	msg := NewJourney{StartId: 23, DestinationId: 42}
+	mockQueue.On("Push", msg).Return()
+	queue.Push(msg)
+
+	// message is queued and observed
+	mockQueue.AssertCalled(t, "Push", msg)
+	require.Equal(t, []interface{}{msg}, observed)
+}
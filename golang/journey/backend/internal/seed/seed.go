@@ -0,0 +1,197 @@
+package seed
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fiurgeist/journey/internal/cache"
+	"fiurgeist/journey/internal/geojson"
+	"fiurgeist/journey/internal/queue"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	FORMAT_CSV     = "csv"
+	FORMAT_NDJSON  = "ndjson"
+	FORMAT_GEOJSON = "geojson"
+)
+
+// route is a line of an NDJSON route file, the v2 journeys of the API match it
+type route struct {
+	StartId       uint16        `json:"startId"`
+	DestinationId uint16        `json:"destinationId"`
+	FullyMapped   bool          `json:"fullyMapped"`
+	Points        []cache.Point `json:"points"`
+}
+
+// Writer stores the messages of the imported journeys
+type Writer interface {
+	Write(msg interface{}) error
+}
+
+// FormatOf guesses the format of a route file from its extension
+func FormatOf(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return FORMAT_CSV, nil
+	case ".ndjson", ".jsonl":
+		return FORMAT_NDJSON, nil
+	case ".geojson", ".json":
+		return FORMAT_GEOJSON, nil
+	}
+	return "", fmt.Errorf("Unknown format of route file %s", path)
+}
+
+// Read parses a route file, the transform maps GeoJSON coordinates back to the grid
+func Read(r io.Reader, format string, transform geojson.Transform) ([]cache.Journey, error) {
+	switch format {
+	case FORMAT_CSV:
+		return readCSV(r)
+	case FORMAT_NDJSON:
+		return readNDJSON(r)
+	case FORMAT_GEOJSON:
+		return geojson.Read(r, transform)
+	}
+	return nil, fmt.Errorf("Unknown format %q", format)
+}
+
+func readNDJSON(r io.Reader) ([]cache.Journey, error) {
+	var journeys []cache.Journey
+	decoder := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var route route
+		if err := decoder.Decode(&route); err == io.EOF {
+			return journeys, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("Invalid route %d: %s", line, err)
+		}
+		journeys = append(journeys, cache.Journey{
+			Id:            cache.JourneyId(route.StartId, route.DestinationId),
+			Points:        route.Points,
+			StartId:       route.StartId,
+			DestinationId: route.DestinationId,
+			FullyMapped:   route.FullyMapped,
+		})
+	}
+}
+
+// readCSV reads one point per row in order of the journey. The columns startId, destinationId,
+// x and y are required, fullyMapped is optional.
+func readCSV(r io.Reader) ([]cache.Journey, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Missing CSV header: %s", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"startId", "destinationId", "x", "y"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("Missing CSV column %q", name)
+		}
+	}
+
+	var journeys []cache.Journey
+	index := make(map[string]int)
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return journeys, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var values [4]uint16
+		for i, name := range []string{"startId", "destinationId", "x", "y"} {
+			value, err := strconv.ParseUint(strings.TrimSpace(record[columns[name]]), 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid %s in line %d: %q", name, line, record[columns[name]])
+			}
+			values[i] = uint16(value)
+		}
+		fullyMapped := false
+		if i, ok := columns["fullyMapped"]; ok && strings.TrimSpace(record[i]) != "" {
+			fullyMapped, err = strconv.ParseBool(strings.TrimSpace(record[i]))
+			if err != nil {
+				return nil, fmt.Errorf("Invalid fullyMapped in line %d: %q", line, record[i])
+			}
+		}
+
+		id := cache.JourneyId(values[0], values[1])
+		i, ok := index[id]
+		if !ok {
+			i = len(journeys)
+			index[id] = i
+			journeys = append(journeys, cache.Journey{Id: id, StartId: values[0], DestinationId: values[1]})
+		}
+		journeys[i].Points = append(journeys[i].Points, cache.Point{X: values[2], Y: values[3]})
+		journeys[i].FullyMapped = journeys[i].FullyMapped || fullyMapped
+	}
+}
+
+// Validate checks the journeys of a world before anything is written, points have to be within
+// the bounds of the world and unique per journey, as the cache only records a point once
+func Validate(journeys []cache.Journey, world cache.World) error {
+	var problems []string
+	seen := make(map[string]bool, len(journeys))
+	for _, journey := range journeys {
+		if seen[journey.Id] {
+			problems = append(problems, fmt.Sprintf("journey %s: duplicate", journey.Id))
+		}
+		seen[journey.Id] = true
+
+		points := make(map[cache.Point]bool, len(journey.Points))
+		for i, point := range journey.Points {
+			if !world.Contains(point) {
+				problems = append(problems, fmt.Sprintf(
+					"journey %s: point %d (%d, %d) is outside the bounds of world %s",
+					journey.Id, i, point.X, point.Y, world.Id,
+				))
+			}
+			if points[point] {
+				problems = append(problems, fmt.Sprintf(
+					"journey %s: point %d (%d, %d) is visited twice", journey.Id, i, point.X, point.Y,
+				))
+			}
+			points[point] = true
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("Invalid routes: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Write passes a journey as the messages the cache would have sent while discovering it.
+// It returns the number of messages which couldn't be written.
+func Write(w Writer, journey cache.Journey) int {
+	msgs := []interface{}{queue.NewJourney{World: journey.World, StartId: journey.StartId, DestinationId: journey.DestinationId}}
+	for i, point := range journey.Points {
+		msgs = append(msgs, queue.NewLocation{
+			World:         journey.World,
+			StartId:       journey.StartId,
+			DestinationId: journey.DestinationId,
+			X:             point.X,
+			Y:             point.Y,
+			Seq:           i,
+		})
+	}
+	if journey.FullyMapped {
+		msgs = append(msgs, queue.JourneyFullyMapped{World: journey.World, StartId: journey.StartId, DestinationId: journey.DestinationId})
+	}
+
+	failed := 0
+	for _, msg := range msgs {
+		if err := w.Write(msg); err != nil {
+			failed++
+		}
+	}
+	return failed
+}
@@ -0,0 +1,142 @@
+package seed
+
+import (
+	"bytes"
+	"errors"
+	"fiurgeist/journey/internal/cache"
+	"fiurgeist/journey/internal/geojson"
+	"fiurgeist/journey/internal/queue"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+var expectedJourneys = []cache.Journey{
+	{
+		Id:            "23->42",
+		Points:        []cache.Point{{X: 1, Y: 2}, {X: 2, Y: 2}},
+		StartId:       23,
+		DestinationId: 42,
+		FullyMapped:   true,
+	},
+	{Id: "42->23", Points: []cache.Point{{X: 11, Y: 12}}, StartId: 42, DestinationId: 23},
+}
+
+func TestFormatOf(t *testing.T) {
+	for path, expected := range map[string]string{
+		"routes.csv":          FORMAT_CSV,
+		"routes.NDJSON":       FORMAT_NDJSON,
+		"dir/routes.jsonl":    FORMAT_NDJSON,
+		"routes.geojson":      FORMAT_GEOJSON,
+		"dir.csv/routes.json": FORMAT_GEOJSON,
+	} {
+		format, err := FormatOf(path)
+		require.NoError(t, err)
+		require.Equal(t, expected, format, path)
+	}
+
+	_, err := FormatOf("routes.xml")
+	require.Error(t, err)
+	require.Equal(t, "Unknown format of route file routes.xml", err.Error())
+}
+
+func TestReadNDJSON(t *testing.T) {
+	input := `{"startId":23,"destinationId":42,"fullyMapped":true,"points":[{"x":1,"y":2},{"x":2,"y":2}]}
+{"id":"42->23","startId":42,"destinationId":23,"pointCount":1,"points":[{"x":11,"y":12}]}
+`
+	journeys, err := Read(bytes.NewBufferString(input), FORMAT_NDJSON, geojson.Identity)
+	require.NoError(t, err)
+	require.Equal(t, expectedJourneys, journeys)
+
+	_, err = Read(bytes.NewBufferString(input+"foo\n"), FORMAT_NDJSON, geojson.Identity)
+	require.Error(t, err)
+	require.Equal(t, "Invalid route 3: invalid character 'o' in literal false (expecting 'a')", err.Error())
+}
+
+func TestReadCSV(t *testing.T) {
+	input := "startId,destinationId,x,y,fullyMapped\n" +
+		"23,42,1,2,true\n" +
+		"42,23,11,12,\n" +
+		"23,42,2,2,true\n"
+	journeys, err := Read(bytes.NewBufferString(input), FORMAT_CSV, geojson.Identity)
+	require.NoError(t, err)
+	require.Equal(t, expectedJourneys, journeys)
+
+	// fullyMapped is optional
+	journeys, err = Read(bytes.NewBufferString("x,y,startId,destinationId\n11,12,42,23\n"), FORMAT_CSV, geojson.Identity)
+	require.NoError(t, err)
+	require.Equal(t, expectedJourneys[1:], journeys)
+}
+
+func TestReadCSVErrors(t *testing.T) {
+	testCases := []struct {
+		input string
+		err   string
+	}{
+		{"", "Missing CSV header: EOF"},
+		{"startId,destinationId,x\n", "Missing CSV column \"y\""},
+		{"startId,destinationId,x,y\n23,42,1,-2\n", "Invalid y in line 2: \"-2\""},
+		{"startId,destinationId,x,y,fullyMapped\n23,42,1,2,yes\n", "Invalid fullyMapped in line 2: \"yes\""},
+	}
+	for _, testCase := range testCases {
+		_, err := Read(bytes.NewBufferString(testCase.input), FORMAT_CSV, geojson.Identity)
+		require.Error(t, err, testCase.input)
+		require.Equal(t, testCase.err, err.Error())
+	}
+}
+
+func TestReadGeoJSON(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, geojson.Write(&buf, expectedJourneys, geojson.Identity))
+
+	journeys, err := Read(&buf, FORMAT_GEOJSON, geojson.Identity)
+	require.NoError(t, err)
+	require.Equal(t, expectedJourneys, journeys)
+}
+
+func TestValidate(t *testing.T) {
+	world := cache.World{Id: cache.DEFAULT_WORLD, Bounds: cache.BoundingBox{MaxX: 1023, MaxY: 1023}}
+	require.NoError(t, Validate(expectedJourneys, world))
+
+	journeys := []cache.Journey{
+		{Id: "23->42", Points: []cache.Point{{X: 1, Y: 2}, {X: 1024, Y: 2}, {X: 1, Y: 2}}},
+		{Id: "23->42"},
+	}
+	err := Validate(journeys, world)
+	require.Error(t, err)
+	require.Equal(
+		t,
+		"Invalid routes: journey 23->42: point 1 (1024, 2) is outside the bounds of world default; "+
+			"journey 23->42: point 2 (1, 2) is visited twice; journey 23->42: duplicate",
+		err.Error(),
+	)
+}
+
+type recordingWriter struct {
+	msgs []interface{}
+	err  error
+}
+
+func (w *recordingWriter) Write(msg interface{}) error {
+	w.msgs = append(w.msgs, msg)
+	return w.err
+}
+
+func TestWrite(t *testing.T) {
+	w := &recordingWriter{}
+	require.Equal(t, 0, Write(w, expectedJourneys[0]))
+	require.Equal(
+		t,
+		[]interface{}{
+			queue.NewJourney{StartId: 23, DestinationId: 42},
+			queue.NewLocation{StartId: 23, DestinationId: 42, X: 1, Y: 2, Seq: 0},
+			queue.NewLocation{StartId: 23, DestinationId: 42, X: 2, Y: 2, Seq: 1},
+			queue.JourneyFullyMapped{StartId: 23, DestinationId: 42},
+		},
+		w.msgs,
+	)
+
+	// not fully mapped
+	w = &recordingWriter{err: errors.New("broken")}
+	require.Equal(t, 2, Write(w, expectedJourneys[1]))
+	require.Len(t, w.msgs, 2)
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	DEFAULT_CORS_METHODS = []string{"GET", "POST", "OPTIONS"}
+	DEFAULT_CORS_HEADERS = []string{"Accept", "Content-Type", "Last-Event-ID"}
+)
+
+// CORSConfig allows cross-origin requests from AllowedOrigins, "*" allows any origin without
+// credentials. Without allowed origins no CORS headers are sent.
+type CORSConfig struct {
+	AllowedOrigins []string      `yaml:"allowedOrigins"`
+	AllowedMethods []string      `yaml:"allowedMethods"`
+	AllowedHeaders []string      `yaml:"allowedHeaders"`
+	MaxAge         time.Duration `yaml:"maxAge"`
+}
+
+type cors struct {
+	config  CORSConfig
+	methods string
+	headers string
+}
+
+// withCORS wraps the whole router, so preflight requests are answered for every route,
+// independent of the methods the route is registered for
+func withCORS(config CORSConfig, next http.Handler) http.Handler {
+	if len(config.AllowedMethods) == 0 {
+		config.AllowedMethods = DEFAULT_CORS_METHODS
+	}
+	if len(config.AllowedHeaders) == 0 {
+		config.AllowedHeaders = DEFAULT_CORS_HEADERS
+	}
+	c := &cors{
+		config:  config,
+		methods: strings.Join(config.AllowedMethods, ", "),
+		headers: strings.Join(config.AllowedHeaders, ", "),
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || len(c.config.AllowedOrigins) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+		allowed := c.allowOrigin(origin)
+		if allowed != "*" {
+			w.Header().Add("Vary", "Origin")
+		}
+		if allowed == "" {
+			if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", allowed)
+		if !preflight {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !c.allowMethod(r.Header.Get("Access-Control-Request-Method")) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", c.methods)
+		w.Header().Set("Access-Control-Allow-Headers", c.headers)
+		if c.config.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.config.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// allowOrigin returns the allowed origin header for an origin, empty if it isn't allowed.
+// Origins listed explicitly are echoed, any other origin gets the literal "*", which browsers
+// don't accept for requests with credentials.
+func (c *cors) allowOrigin(origin string) string {
+	wildcard := false
+	for _, allowed := range c.config.AllowedOrigins {
+		if allowed == origin {
+			return origin
+		}
+		wildcard = wildcard || allowed == "*"
+	}
+	if wildcard {
+		return "*"
+	}
+	return ""
+}
+
+func (c *cors) allowMethod(method string) bool {
+	for _, allowed := range c.config.AllowedMethods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,21 +1,74 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fiurgeist/journey/internal/cache"
+	"fiurgeist/journey/internal/export"
+	"fiurgeist/journey/internal/geojson"
+	"fiurgeist/journey/internal/geometry"
+	"fiurgeist/journey/internal/graph"
+	"fiurgeist/journey/internal/health"
+	"fiurgeist/journey/internal/heatmap"
 	"fiurgeist/journey/internal/metrics"
+	"fiurgeist/journey/internal/stream"
+	"fmt"
 	"github.com/gorilla/mux"
+	"io"
+	"log"
+	"math"
+	"mime"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	DEFAULT_STREAM_HEARTBEAT  = 15 * time.Second
+	DEFAULT_TELEMETRY_TIMEOUT = 60 * time.Second
+	// MAX_RADIUS limits the area of /journeys/near, the same area is found with /journeys/within
+	MAX_RADIUS = 1024
 )
 
 type Config struct {
-	Addr        string
-	PublicDir   string
-	PublicJsDir string
+	Addr            string        `yaml:"addr"`
+	PublicDir       string        `yaml:"publicDir"`
+	PublicJsDir     string        `yaml:"publicJsDir"`
+	StreamHeartbeat time.Duration `yaml:"streamHeartbeat"`
+	// TelemetryTimeout closes telemetry connections without any frame or pong for that long, they
+	// are pinged twice within it
+	TelemetryTimeout time.Duration `yaml:"telemetryTimeout"`
+	CORS             CORSConfig    `yaml:"cors"`
+	// GeoJSON transforms the grid coordinates of /journeys.geojson, they are kept if nil
+	GeoJSON *geojson.Transform `yaml:"geojson"`
+	// StreamPositions publishes every accepted movement to /journeys/stream as characterMoved
+	StreamPositions bool `yaml:"streamPositions"`
+	// StreamHistory is the number of events kept for resuming clients of the stream, 0 keeps none
+	StreamHistory int `yaml:"streamHistory"`
+	// StreamBuffer is the number of events a client may fall behind before it is dropped
+	StreamBuffer int `yaml:"streamBuffer"`
 }
 
-func NewHTTPServer(config Config, metrics metrics.Metrics, cache cache.Cache) *http.Server {
-	httpsrv := newHTTPServer(metrics, cache)
+// Dependencies of the server, only the metrics and the cache are required
+type Dependencies struct {
+	Metrics metrics.Metrics
+	// Cache of the default world
+	Cache   cache.Cache
+	Hub     stream.Hub
+	Checker health.Checker
+	// Exporter is nil without store
+	Exporter export.Source
+	// Heatmaps by world id, unbounded worlds have none
+	Heatmaps map[string]*heatmap.Heatmap
+	// Worlds by id, besides the default world of the cache
+	Worlds map[string]cache.Cache
+}
+
+func NewHTTPServer(config Config, deps Dependencies) *http.Server {
+	httpsrv := newHTTPServer(config, deps)
 	r := mux.NewRouter()
 
 	r.Handle("/", http.StripPrefix("/", http.FileServer(http.Dir(config.PublicDir))))
@@ -27,15 +80,53 @@ func NewHTTPServer(config Config, metrics metrics.Metrics, cache cache.Cache) *h
 		),
 	)
 
-	r.HandleFunc("/character/movement", httpsrv.handleMovement).Methods("POST")
-	r.HandleFunc("/character/startJourney", httpsrv.handleStartJourney).Methods("POST")
-	r.HandleFunc("/character/reachedDestination", httpsrv.handleReachedDestination).Methods("POST")
+	r.HandleFunc("/character/movement", httpsrv.warmedUp(httpsrv.handleMovement)).Methods("POST")
+	r.HandleFunc("/character/movements", httpsrv.warmedUp(httpsrv.handleMovements)).Methods("POST")
+	r.HandleFunc("/character/startJourney", httpsrv.warmedUp(httpsrv.handleStartJourney)).Methods("POST")
+	r.HandleFunc("/character/reachedDestination", httpsrv.warmedUp(httpsrv.handleReachedDestination)).Methods("POST")
+	r.HandleFunc("/character/telemetry", httpsrv.warmedUp(httpsrv.handleTelemetry)).Methods("GET")
+
+	r.HandleFunc("/journeys", versioned(map[int]http.HandlerFunc{
+		API_VERSION_1: httpsrv.handleJourneys,
+		API_VERSION_2: httpsrv.handleJourneysV2,
+	})).Methods("GET")
+	r.HandleFunc("/journeys.geojson", httpsrv.handleJourneysGeoJSON).Methods("GET")
+	r.HandleFunc("/journeys/stream", httpsrv.handleJourneyStream).Methods("GET")
+	r.HandleFunc("/journeys/near", httpsrv.handleJourneysNear).Methods("GET")
+	r.HandleFunc("/journeys/within", httpsrv.handleJourneysWithin).Methods("GET")
+	r.HandleFunc("/journeys/{startId:[0-9]+}/{destinationId:[0-9]+}/traversals", httpsrv.handleTraversals).Methods("GET")
+	r.HandleFunc("/characters", httpsrv.handleCharacters).Methods("GET")
+	r.HandleFunc("/characters/{characterId}", httpsrv.handleCharacter).Methods("GET")
+	r.HandleFunc("/characters/{characterId}/progress", httpsrv.handleProgress).Methods("GET")
+	r.HandleFunc("/routes", httpsrv.handleRoutes).Methods("GET")
+	r.HandleFunc("/anomalies", httpsrv.handleAnomalies).Methods("GET")
+	r.HandleFunc("/heatmap", httpsrv.handleHeatmap).Methods("GET")
+	r.HandleFunc("/heatmap.png", httpsrv.handleHeatmapPNG).Methods("GET")
 
-	r.HandleFunc("/journeys", httpsrv.handleJourneys).Methods("GET", "OPTIONS")
+	r.HandleFunc("/worlds", httpsrv.handleWorlds).Methods("GET")
+	r.HandleFunc("/worlds/{world}/journeys", httpsrv.inWorld(versioned(map[int]http.HandlerFunc{
+		API_VERSION_1: httpsrv.handleJourneys,
+		API_VERSION_2: httpsrv.handleJourneysV2,
+	}))).Methods("GET")
+	r.HandleFunc("/worlds/{world}/characters", httpsrv.inWorld(httpsrv.handleCharacters)).Methods("GET")
+	r.HandleFunc("/worlds/{world}/characters/{characterId}", httpsrv.inWorld(httpsrv.handleCharacter)).Methods("GET")
+	r.HandleFunc("/worlds/{world}/characters/{characterId}/progress", httpsrv.inWorld(httpsrv.handleProgress)).Methods("GET")
+	r.HandleFunc("/worlds/{world}/journeys/near", httpsrv.inWorld(httpsrv.handleJourneysNear)).Methods("GET")
+	r.HandleFunc("/worlds/{world}/journeys/within", httpsrv.inWorld(httpsrv.handleJourneysWithin)).Methods("GET")
+	r.HandleFunc("/worlds/{world}/journeys/{startId:[0-9]+}/{destinationId:[0-9]+}/traversals", httpsrv.inWorld(httpsrv.handleTraversals)).Methods("GET")
+	r.HandleFunc("/worlds/{world}/routes", httpsrv.inWorld(httpsrv.handleRoutes)).Methods("GET")
+	r.HandleFunc("/worlds/{world}/anomalies", httpsrv.inWorld(httpsrv.handleAnomalies)).Methods("GET")
+	r.HandleFunc("/worlds/{world}/heatmap", httpsrv.inWorld(httpsrv.handleHeatmap)).Methods("GET")
+	r.HandleFunc("/worlds/{world}/heatmap.png", httpsrv.inWorld(httpsrv.handleHeatmapPNG)).Methods("GET")
+
+	r.HandleFunc("/export/{dataset:journeys|locations}.{format:csv|ndjson}", httpsrv.handleExport).Methods("GET")
+
+	r.HandleFunc("/healthz", httpsrv.handleHealth).Methods("GET")
+	r.HandleFunc("/readyz", httpsrv.handleReady).Methods("GET")
 
 	return &http.Server{
 		Addr:    config.Addr,
-		Handler: r,
+		Handler: withCORS(config.CORS, r),
 	}
 }
 
@@ -47,29 +138,77 @@ func setHeader(header, value string, handle http.Handler) func(http.ResponseWrit
 }
 
 type httpServer struct {
-	metrics metrics.Metrics
-	cache   cache.Cache
+	metrics  metrics.Metrics
+	cache    cache.Cache
+	hub      stream.Hub
+	checker  health.Checker
+	exporter export.Source
+	heatmaps map[string]*heatmap.Heatmap
+	// worlds by id, besides the default world of the cache
+	worlds           map[string]cache.Cache
+	streamHeartbeat  time.Duration
+	telemetryTimeout time.Duration
+	geoTransform     geojson.Transform
+	streamPositions  bool
 }
 
-func newHTTPServer(metrics metrics.Metrics, cache cache.Cache) *httpServer {
+func newHTTPServer(config Config, deps Dependencies) *httpServer {
+	streamHeartbeat := config.StreamHeartbeat
+	if streamHeartbeat <= 0 {
+		streamHeartbeat = DEFAULT_STREAM_HEARTBEAT
+	}
+	telemetryTimeout := config.TelemetryTimeout
+	if telemetryTimeout <= 0 {
+		telemetryTimeout = DEFAULT_TELEMETRY_TIMEOUT
+	}
+	geoTransform := config.GeoJSON
+	if geoTransform == nil {
+		geoTransform = &geojson.Identity
+	}
 	return &httpServer{
-		metrics: metrics,
-		cache:   cache,
+		metrics:          deps.Metrics,
+		cache:            deps.Cache,
+		hub:              deps.Hub,
+		checker:          deps.Checker,
+		exporter:         deps.Exporter,
+		heatmaps:         deps.Heatmaps,
+		worlds:           deps.Worlds,
+		streamHeartbeat:  streamHeartbeat,
+		telemetryTimeout: telemetryTimeout,
+		geoTransform:     *geoTransform,
+		streamPositions:  config.StreamPositions,
 	}
 }
 
 type MovementRequest struct {
+	// World of the character, the default world if empty
+	World       string `json:"World,omitempty"`
 	CharacterId string `json:"CharacterId"`
 	X           uint16 `json:"X"`
 	Y           uint16 `json:"Y"`
+	// At is the optional time the client took the movement (RFC 3339), which the speed is
+	// checked with, also for the movements of a batch
+	At time.Time `json:"At"`
+}
+
+type MovementResult struct {
+	Index int    `json:"index"`
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type MovementsResponse struct {
+	Results []MovementResult `json:"results"`
 }
 
 type ReachedDestinationRequest struct {
+	World         string `json:"World,omitempty"`
 	CharacterId   string `json:"CharacterId"`
 	DestinationId uint16 `json:"DestinationId"`
 }
 
 type StartJourneyRequest struct {
+	World         string `json:"World,omitempty"`
 	CharacterId   string `json:"CharacterId"`
 	StartId       uint16 `json:"StartId"`
 	DestinationId uint16 `json:"DestinationId"`
@@ -79,6 +218,38 @@ type JourneysResponse struct {
 	Journeys []cache.Journey `json:"journeys"`
 }
 
+type JourneyMatchesResponse struct {
+	Journeys []cache.JourneyMatch `json:"journeys"`
+}
+
+type WorldsResponse struct {
+	Worlds []cache.World `json:"worlds"`
+}
+
+type CharactersResponse struct {
+	Characters []cache.Character `json:"characters"`
+}
+
+type AnomaliesResponse struct {
+	Characters []cache.CharacterAnomalies `json:"characters"`
+}
+
+type JourneyV2 struct {
+	Id            string        `json:"id"`
+	StartId       uint16        `json:"startId"`
+	DestinationId uint16        `json:"destinationId"`
+	FullyMapped   bool          `json:"fullyMapped"`
+	DiscoveredAt  time.Time     `json:"discoveredAt"`
+	PointCount    int           `json:"pointCount"`
+	Points        []cache.Point `json:"points"`
+	// Stats are computed from all points, also if the points are simplified
+	Stats cache.Stats `json:"stats"`
+}
+
+type JourneysResponseV2 struct {
+	Journeys []JourneyV2 `json:"journeys"`
+}
+
 func (s *httpServer) handleMovement(w http.ResponseWriter, r *http.Request) {
 	var req MovementRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
@@ -86,8 +257,98 @@ func (s *httpServer) handleMovement(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	world, err := s.worldCache(req.World)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	s.metrics.LogRequest()
-	s.cache.Movement(req.CharacterId, req.X, req.Y)
+	if err := world.TimedMovement(req.CharacterId, req.X, req.Y, req.At); err == nil {
+		s.publishPosition(world, req.CharacterId, req.X, req.Y)
+	}
+}
+
+// handleMovements accepts a JSON array or, with Content-Type application/x-ndjson, one movement
+// per line. The movements of each character are applied in order with a single cache operation.
+// Characters are distinct per world, movements into an unknown world fail on their own.
+func (s *httpServer) handleMovements(w http.ResponseWriter, r *http.Request) {
+	reqs, err := decodeMovements(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type character struct {
+		world string
+		id    string
+	}
+	var characters []character
+	points := make(map[character][]cache.TimedPoint)
+	indexes := make(map[character][]int)
+	for i, req := range reqs {
+		s.metrics.LogRequest()
+		key := character{world: req.World, id: req.CharacterId}
+		if _, ok := points[key]; !ok {
+			characters = append(characters, key)
+		}
+		points[key] = append(points[key], cache.TimedPoint{Point: cache.Point{X: req.X, Y: req.Y}, At: req.At})
+		indexes[key] = append(indexes[key], i)
+	}
+
+	res := MovementsResponse{Results: make([]MovementResult, len(reqs))}
+	for _, key := range characters {
+		var errs []error
+		if world, err := s.worldCache(key.world); err == nil {
+			errs = world.TimedMovements(key.id, points[key])
+		} else {
+			errs = make([]error, len(points[key]))
+			for i := range errs {
+				errs[i] = err
+			}
+		}
+		for i, index := range indexes[key] {
+			res.Results[index] = MovementResult{Index: index, Ok: errs[i] == nil}
+			if errs[i] != nil {
+				res.Results[index].Error = errs[i].Error()
+			}
+		}
+		if world, err := s.worldCache(key.world); err == nil {
+			for i, point := range points[key] {
+				if errs[i] == nil {
+					s.publishPosition(world, key.id, point.X, point.Y)
+				}
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func decodeMovements(r *http.Request) ([]MovementRequest, error) {
+	var reqs []MovementRequest
+	decoder := json.NewDecoder(r.Body)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/x-ndjson" {
+		err := decoder.Decode(&reqs)
+		return reqs, err
+	}
+
+	for {
+		var req MovementRequest
+		err := decoder.Decode(&req)
+		if err == io.EOF {
+			return reqs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid movement in line %d: %s", len(reqs)+1, err)
+		}
+		reqs = append(reqs, req)
+	}
 }
 
 func (s *httpServer) handleReachedDestination(w http.ResponseWriter, r *http.Request) {
@@ -97,8 +358,13 @@ func (s *httpServer) handleReachedDestination(w http.ResponseWriter, r *http.Req
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	world, err := s.worldCache(req.World)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	s.metrics.LogRequest()
-	s.cache.ReachedDestination(req.CharacterId, req.DestinationId)
+	world.ReachedDestination(req.CharacterId, req.DestinationId)
 }
 
 func (s *httpServer) handleStartJourney(w http.ResponseWriter, r *http.Request) {
@@ -108,15 +374,584 @@ func (s *httpServer) handleStartJourney(w http.ResponseWriter, r *http.Request)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	world, err := s.worldCache(req.World)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	s.metrics.LogRequest()
-	s.cache.StartJourney(req.CharacterId, req.StartId, req.DestinationId)
+	world.StartJourney(req.CharacterId, req.StartId, req.DestinationId)
+}
+
+// publishPosition streams an accepted movement if enabled
+func (s *httpServer) publishPosition(world cache.Cache, characterId string, x, y uint16) {
+	if !s.streamPositions || s.hub == nil {
+		return
+	}
+	s.hub.Publish(stream.CharacterMoved{
+		World:       cache.MessageWorld(world.GetWorld().Id),
+		CharacterId: characterId,
+		X:           x,
+		Y:           y,
+	})
+}
+
+// warmedUp answers service unavailable until all caches are warmed up. A journey discovered
+// before would number its points from 0 again, colliding with the ones in the store.
+func (s *httpServer) warmedUp(handle http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		warmedUp := s.cache.IsWarmedUp()
+		for _, world := range s.worlds {
+			warmedUp = warmedUp && world.IsWarmedUp()
+		}
+		if !warmedUp {
+			http.Error(w, "Cache is warming up", http.StatusServiceUnavailable)
+			return
+		}
+		handle(w, r)
+	}
+}
+
+// worldCache returns the cache of the world, of the default world if the id is empty
+func (s *httpServer) worldCache(id string) (cache.Cache, error) {
+	if id == "" || id == cache.DEFAULT_WORLD {
+		return s.cache, nil
+	}
+	world, ok := s.worlds[id]
+	if !ok {
+		return nil, fmt.Errorf("Unknown world %q", id)
+	}
+	return world, nil
+}
+
+// inWorld answers not found for an unknown world of the path
+func (s *httpServer) inWorld(handle http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, err := s.worldCache(mux.Vars(r)["world"]); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		handle(w, r)
+	}
+}
+
+func (s *httpServer) handleWorlds(w http.ResponseWriter, r *http.Request) {
+	res := WorldsResponse{Worlds: []cache.World{s.cache.GetWorld()}}
+	for _, world := range s.worlds {
+		res.Worlds = append(res.Worlds, world.GetWorld())
+	}
+	sort.Slice(res.Worlds, func(i, j int) bool { return res.Worlds[i].Id < res.Worlds[j].Id })
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 }
 
 func (s *httpServer) handleJourneys(w http.ResponseWriter, r *http.Request) {
+	journeys, err := s.getJourneys(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	res := JourneysResponse{
-		Journeys: s.cache.GetUniqueJourneys(),
+		Journeys: journeys,
 	}
-	err := json.NewEncoder(w).Encode(res)
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *httpServer) handleJourneysV2(w http.ResponseWriter, r *http.Request) {
+	journeys, err := s.getJourneys(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res := JourneysResponseV2{
+		Journeys: make([]JourneyV2, len(journeys)),
+	}
+	for i, journey := range journeys {
+		res.Journeys[i] = JourneyV2{
+			Id:            journey.Id,
+			StartId:       journey.StartId,
+			DestinationId: journey.DestinationId,
+			FullyMapped:   journey.FullyMapped,
+			DiscoveredAt:  journey.DiscoveredAt,
+			PointCount:    len(journey.Points),
+			Points:        journey.Points,
+			Stats:         journey.Stats,
+		}
+	}
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *httpServer) handleJourneysGeoJSON(w http.ResponseWriter, r *http.Request) {
+	journeys, err := s.getJourneys(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", geojson.CONTENT_TYPE)
+	err = geojson.Write(w, journeys, s.geoTransform)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// getJourneys returns the journeys of the world in the path, of the default world without one
+func (s *httpServer) getJourneys(r *http.Request) ([]cache.Journey, error) {
+	simplify, err := getSimplifier(r)
+	if err != nil {
+		return nil, err
+	}
+	world, err := s.worldCache(mux.Vars(r)["world"])
+	if err != nil {
+		return nil, err
+	}
+
+	journeys := world.GetUniqueJourneys()
+	if by := r.URL.Query().Get("sort"); by != "" {
+		if err := cache.SortJourneys(journeys, by); err != nil {
+			return nil, err
+		}
+	}
+	if simplify == nil {
+		return journeys, nil
+	}
+	simplified := make([]cache.Journey, len(journeys))
+	for i, journey := range journeys {
+		journey.Points = simplify(journey.Points)
+		simplified[i] = journey
+	}
+	return simplified, nil
+}
+
+// getSimplifier reads the optional params `simplify` (collinear, rdp) and `tolerance`
+func getSimplifier(r *http.Request) (geometry.Simplifier, error) {
+	method := r.URL.Query().Get("simplify")
+	if method == "" {
+		return nil, nil
+	}
+	tolerance := geometry.DEFAULT_TOLERANCE
+	if value := r.URL.Query().Get("tolerance"); value != "" {
+		var err error
+		if tolerance, err = strconv.ParseFloat(value, 64); err != nil {
+			return nil, fmt.Errorf("Invalid tolerance %q", value)
+		}
+	}
+	return geometry.NewSimplifier(method, tolerance)
+}
+
+// handleRoutes finds the shortest route over several journeys, the params `from` and `to` are
+// location ids, `fullyMapped=true` only walks fully mapped journeys
+func (s *httpServer) handleRoutes(w http.ResponseWriter, r *http.Request) {
+	world, err := s.worldCache(mux.Vars(r)["world"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	from, err := getLocationId(query.Get("from"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := getLocationId(query.Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fullyMapped := false
+	if value := query.Get("fullyMapped"); value != "" {
+		if fullyMapped, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid fullyMapped %q", value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	route, err := graph.New(world.GetUniqueJourneys(), fullyMapped).ShortestRoute(from, to)
+	if err == graph.ErrNoRoute {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(route)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleJourneysNear finds the journeys with points within `radius` around `x` and `y`
+func (s *httpServer) handleJourneysNear(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var circle cache.Circle
+	var err error
+	if circle.Center.X, err = getCoordinate(query, "x"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if circle.Center.Y, err = getCoordinate(query, "y"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	value := query.Get("radius")
+	if circle.Radius, err = strconv.ParseFloat(value, 64); err != nil || circle.Radius < 0 || math.IsNaN(circle.Radius) {
+		http.Error(w, fmt.Sprintf("Invalid radius %q", value), http.StatusBadRequest)
+		return
+	}
+	if circle.Radius > MAX_RADIUS {
+		http.Error(w, fmt.Sprintf("Radius %s exceeds the maximum of %d", value, MAX_RADIUS), http.StatusBadRequest)
+		return
+	}
+	s.writeJourneyMatches(w, r, circle)
+}
+
+// handleJourneysWithin finds the journeys with points inside the box given by `minX`, `minY`,
+// `maxX` and `maxY`, including its border
+func (s *httpServer) handleJourneysWithin(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var box cache.BoundingBox
+	for _, coordinate := range []struct {
+		name  string
+		value *uint16
+	}{{"minX", &box.MinX}, {"minY", &box.MinY}, {"maxX", &box.MaxX}, {"maxY", &box.MaxY}} {
+		var err error
+		if *coordinate.value, err = getCoordinate(query, coordinate.name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if box.MinX > box.MaxX || box.MinY > box.MaxY {
+		http.Error(w, "Invalid box, the minimum exceeds the maximum", http.StatusBadRequest)
+		return
+	}
+	s.writeJourneyMatches(w, r, box)
+}
+
+func (s *httpServer) writeJourneyMatches(w http.ResponseWriter, r *http.Request, area cache.Area) {
+	world, err := s.worldCache(mux.Vars(r)["world"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	res := JourneyMatchesResponse{Journeys: world.FindJourneys(area)}
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func getCoordinate(query url.Values, name string) (uint16, error) {
+	value := query.Get(name)
+	coordinate, err := strconv.ParseUint(value, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s %q", name, value)
+	}
+	return uint16(coordinate), nil
+}
+
+// handleTraversals returns the walks of every character of a journey and their consensus
+func (s *httpServer) handleTraversals(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	world, err := s.worldCache(vars["world"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	startId, err := getLocationId(vars["startId"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	destinationId, err := getLocationId(vars["destinationId"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	traversals, ok := world.GetTraversals(startId, destinationId)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown journey %s", cache.JourneyId(startId, destinationId)), http.StatusNotFound)
+		return
+	}
+	err = json.NewEncoder(w).Encode(traversals)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleCharacters lists the last known state of the characters of the world, ordered by id
+func (s *httpServer) handleCharacters(w http.ResponseWriter, r *http.Request) {
+	world, err := s.worldCache(mux.Vars(r)["world"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(CharactersResponse{Characters: world.GetCharacters()})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleCharacter answers not found for a character not seen since the start
+func (s *httpServer) handleCharacter(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	world, err := s.worldCache(vars["world"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	character, ok := world.GetCharacter(vars["characterId"])
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown character %q", vars["characterId"]), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(character)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleProgress projects the position of the character onto the route of its journey. It
+// answers conflict while the journey isn't fully mapped, not found without a position.
+func (s *httpServer) handleProgress(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	world, err := s.worldCache(vars["world"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	progress, err := world.GetProgress(vars["characterId"])
+	if errors.Is(err, cache.ErrNotFullyMapped) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(progress)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleAnomalies lists the characters with implausible movements, the most recent first
+func (s *httpServer) handleAnomalies(w http.ResponseWriter, r *http.Request) {
+	world, err := s.worldCache(mux.Vars(r)["world"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	res := AnomaliesResponse{Characters: world.GetAnomalies()}
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func getLocationId(value string) (uint16, error) {
+	id, err := strconv.ParseUint(value, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid location id %q", value)
+	}
+	return uint16(id), nil
+}
+
+func (s *httpServer) handleJourneyStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	lastEventId := uint64(0)
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		id, err := strconv.ParseUint(header, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid Last-Event-ID %q", header), http.StatusBadRequest)
+			return
+		}
+		lastEventId = id
+	}
+
+	sub, missed := s.hub.Subscribe(lastEventId)
+	defer s.hub.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, event := range missed {
+		if err := writeEvent(w, event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(s.streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.Events:
+			if !ok {
+				// dropped by the hub, the client resumes with its Last-Event-ID
+				return
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event stream.Event) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Type, data)
+	return err
+}
+
+// handleExport streams a table of the store, the rows aren't held in memory
+func (s *httpServer) handleExport(w http.ResponseWriter, r *http.Request) {
+	if s.exporter == nil {
+		http.Error(w, "Store unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	simplify, err := getSimplifier(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	source := s.exporter
+	if simplify != nil {
+		source = export.Simplified(source, simplify)
+	}
+	vars := mux.Vars(r)
+	dataset, format := vars["dataset"], vars["format"]
+
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", dataset+"."+format))
+	if err := export.Export(w, source, dataset, format); err != nil {
+		// the status is sent already, the client sees a truncated export
+		log.Printf("Failed to export %s: %s\n", dataset, err)
+	}
+}
+
+// handleHeatmap returns the number of recorded points per cell, the param `cell` sets the size
+// of the cells in grid units
+func (s *httpServer) handleHeatmap(w http.ResponseWriter, r *http.Request) {
+	grid, ok := s.getHeatmap(w, r)
+	if !ok {
+		return
+	}
+	err := json.NewEncoder(w).Encode(grid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleHeatmapPNG renders the heatmap with one pixel per grid unit
+func (s *httpServer) handleHeatmapPNG(w http.ResponseWriter, r *http.Request) {
+	grid, ok := s.getHeatmap(w, r)
+	if !ok {
+		return
+	}
+	// encoded before answering, so errors still get their status
+	var buf bytes.Buffer
+	if err := heatmap.WritePNG(&buf, grid); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(buf.Bytes())
+}
+
+func (s *httpServer) getHeatmap(w http.ResponseWriter, r *http.Request) (heatmap.Grid, bool) {
+	world := mux.Vars(r)["world"]
+	if world == "" {
+		world = cache.DEFAULT_WORLD
+	}
+	worldHeatmap := s.heatmaps[world]
+	if worldHeatmap == nil {
+		http.Error(w, "Heatmap unavailable", http.StatusServiceUnavailable)
+		return heatmap.Grid{}, false
+	}
+	cell := heatmap.DEFAULT_CELL
+	if value := r.URL.Query().Get("cell"); value != "" {
+		var err error
+		if cell, err = strconv.Atoi(value); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid cell size %q", value), http.StatusBadRequest)
+			return heatmap.Grid{}, false
+		}
+	}
+	grid, err := worldHeatmap.Grid(cell)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return heatmap.Grid{}, false
+	}
+	return grid, true
+}
+
+// handleHealth only tells that the process is alive and serving requests
+func (s *httpServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(health.Report{Status: health.StatusUp})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleReady reports the state of every subsystem, failing if any of them is down
+func (s *httpServer) handleReady(w http.ResponseWriter, r *http.Request) {
+	report := s.checker.Check()
+
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != health.StatusUp {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	err := json.NewEncoder(w).Encode(report)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -2,16 +2,27 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fiurgeist/journey/internal/cache"
+	"fiurgeist/journey/internal/export"
+	"fiurgeist/journey/internal/geojson"
+	"fiurgeist/journey/internal/health"
+	"fiurgeist/journey/internal/heatmap"
 	"fiurgeist/journey/internal/metrics"
+	"fiurgeist/journey/internal/queue"
+	"fiurgeist/journey/internal/stream"
 	"fmt"
 	"github.com/stretchr/testify/require"
+	"image"
+	"image/png"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 var defaultConfig = Config{
@@ -23,10 +34,11 @@ var defaultConfig = Config{
 func TestMovementOK(t *testing.T) {
 	mockMetrics := &metrics.MockMetrics{}
 	mockCache := &cache.MockCache{}
-	srv := NewHTTPServer(defaultConfig, mockMetrics, mockCache)
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+	mockCache.On("IsWarmedUp").Return(true)
 
 	mockMetrics.On("LogRequest").Return()
-	mockCache.On("Movement", "character1", uint16(23), uint16(42)).Return(nil)
+	mockCache.On("TimedMovement", "character1", uint16(23), uint16(42), time.Time{}).Return(nil)
 
 	jsonStr := []byte(`{"CharacterId": "character1", "X": 23, "Y": 42}`)
 	req, _ := http.NewRequest("POST", "/character/movement", bytes.NewBuffer(jsonStr))
@@ -34,26 +46,122 @@ func TestMovementOK(t *testing.T) {
 
 	require.Equal(t, http.StatusOK, response.Code)
 	mockMetrics.AssertCalled(t, "LogRequest")
-	mockCache.AssertCalled(t, "Movement", "character1", uint16(23), uint16(42))
+	mockCache.AssertCalled(t, "TimedMovement", "character1", uint16(23), uint16(42), time.Time{})
 }
 
 func TestMovementBadRequest(t *testing.T) {
 	mockMetrics := &metrics.MockMetrics{}
 	mockCache := &cache.MockCache{}
-	srv := NewHTTPServer(defaultConfig, mockMetrics, mockCache)
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+	mockCache.On("IsWarmedUp").Return(true)
 
 	req, _ := http.NewRequest("POST", "/character/movement", bytes.NewBuffer([]byte("")))
 	response := executeRequest(srv, req)
 
 	require.Equal(t, http.StatusBadRequest, response.Code)
 	mockMetrics.AssertNumberOfCalls(t, "LogRequest", 0)
-	mockCache.AssertNumberOfCalls(t, "Movement", 0)
+	mockCache.AssertNumberOfCalls(t, "TimedMovement", 0)
+}
+
+func TestMovementWarmingUp(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	moonCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache, Worlds: map[string]cache.Cache{"moon": moonCache}})
+	mockCache.On("IsWarmedUp").Return(true)
+	moonCache.On("IsWarmedUp").Return(false)
+
+	// any world still warming up could get a journey discovered again
+	jsonStr := []byte(`{"CharacterId": "character1", "X": 23, "Y": 42}`)
+	req, _ := http.NewRequest("POST", "/character/movement", bytes.NewBuffer(jsonStr))
+	response := executeRequest(srv, req)
+
+	require.Equal(t, http.StatusServiceUnavailable, response.Code)
+	require.Equal(t, "Cache is warming up\n", response.Body.String())
+	mockMetrics.AssertNumberOfCalls(t, "LogRequest", 0)
+	mockCache.AssertNumberOfCalls(t, "TimedMovement", 0)
+}
+
+func TestMovementsOK(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+	mockCache.On("IsWarmedUp").Return(true)
+
+	mockMetrics.On("LogRequest").Return()
+	// with the time of the client if given
+	at := time.Date(2021, time.January, 1, 0, 0, 1, 0, time.UTC)
+	mockCache.On("TimedMovements", "character1", []cache.TimedPoint{{Point: cache.Point{X: 1, Y: 2}}, {Point: cache.Point{X: 2, Y: 2}, At: at}}).Return([]error{nil, nil})
+	mockCache.On("TimedMovements", "character2", []cache.TimedPoint{{Point: cache.Point{X: 23, Y: 42}}}).Return([]error{errors.New("foo")})
+
+	jsonStr := []byte(`[
+		{"CharacterId": "character1", "X": 1, "Y": 2},
+		{"CharacterId": "character2", "X": 23, "Y": 42},
+		{"CharacterId": "character1", "X": 2, "Y": 2, "At": "2021-01-01T00:00:01Z"}
+	]`)
+	req, _ := http.NewRequest("POST", "/character/movements", bytes.NewBuffer(jsonStr))
+	response := executeRequest(srv, req)
+
+	require.Equal(t, http.StatusOK, response.Code)
+	expected := "{\"results\":[" +
+		"{\"index\":0,\"ok\":true}," +
+		"{\"index\":1,\"ok\":false,\"error\":\"foo\"}," +
+		"{\"index\":2,\"ok\":true}" +
+		"]}\n"
+	require.Equal(t, expected, response.Body.String())
+	mockMetrics.AssertNumberOfCalls(t, "LogRequest", 3)
+	mockCache.AssertNumberOfCalls(t, "TimedMovements", 2)
+}
+
+func TestMovementsNDJSON(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+	mockCache.On("IsWarmedUp").Return(true)
+
+	mockMetrics.On("LogRequest").Return()
+	mockCache.On("TimedMovements", "character1", []cache.TimedPoint{{Point: cache.Point{X: 1, Y: 2}}, {Point: cache.Point{X: 2, Y: 2}}}).Return([]error{nil, nil})
+
+	body := []byte("{\"CharacterId\": \"character1\", \"X\": 1, \"Y\": 2}\n{\"CharacterId\": \"character1\", \"X\": 2, \"Y\": 2}\n")
+	req, _ := http.NewRequest("POST", "/character/movements", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	response := executeRequest(srv, req)
+
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, "{\"results\":[{\"index\":0,\"ok\":true},{\"index\":1,\"ok\":true}]}\n", response.Body.String())
+	mockCache.AssertCalled(t, "TimedMovements", "character1", []cache.TimedPoint{{Point: cache.Point{X: 1, Y: 2}}, {Point: cache.Point{X: 2, Y: 2}}})
+
+	// parameters of the media type are ignored
+	req, _ = http.NewRequest("POST", "/character/movements", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/x-ndjson; charset=utf-8")
+	response = executeRequest(srv, req)
+	require.Equal(t, http.StatusOK, response.Code)
+	mockCache.AssertNumberOfCalls(t, "TimedMovements", 2)
+}
+
+func TestMovementsBadRequest(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+	mockCache.On("IsWarmedUp").Return(true)
+
+	body := []byte("{\"CharacterId\": \"character1\", \"X\": 1, \"Y\": 2}\nfoo\n")
+	req, _ := http.NewRequest("POST", "/character/movements", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	response := executeRequest(srv, req)
+
+	// nothing is applied
+	require.Equal(t, http.StatusBadRequest, response.Code)
+	require.Equal(t, "Invalid movement in line 2: invalid character 'o' in literal false (expecting 'a')\n", response.Body.String())
+	mockMetrics.AssertNumberOfCalls(t, "LogRequest", 0)
+	mockCache.AssertNumberOfCalls(t, "TimedMovements", 0)
 }
 
 func TestReachedDestinationOK(t *testing.T) {
 	mockMetrics := &metrics.MockMetrics{}
 	mockCache := &cache.MockCache{}
-	srv := NewHTTPServer(defaultConfig, mockMetrics, mockCache)
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+	mockCache.On("IsWarmedUp").Return(true)
 
 	mockMetrics.On("LogRequest").Return()
 	mockCache.On("ReachedDestination", "character1", uint16(42)).Return(nil)
@@ -70,7 +178,8 @@ func TestReachedDestinationOK(t *testing.T) {
 func TestReachedDestinationBadRequest(t *testing.T) {
 	mockMetrics := &metrics.MockMetrics{}
 	mockCache := &cache.MockCache{}
-	srv := NewHTTPServer(defaultConfig, mockMetrics, mockCache)
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+	mockCache.On("IsWarmedUp").Return(true)
 
 	req, _ := http.NewRequest("POST", "/character/reachedDestination", bytes.NewBuffer([]byte("")))
 	response := executeRequest(srv, req)
@@ -83,7 +192,8 @@ func TestReachedDestinationBadRequest(t *testing.T) {
 func TestStartJourneyOK(t *testing.T) {
 	mockMetrics := &metrics.MockMetrics{}
 	mockCache := &cache.MockCache{}
-	srv := NewHTTPServer(defaultConfig, mockMetrics, mockCache)
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+	mockCache.On("IsWarmedUp").Return(true)
 
 	mockMetrics.On("LogRequest").Return()
 	mockCache.On("StartJourney", "character1", uint16(23), uint16(42)).Return(nil)
@@ -100,7 +210,8 @@ func TestStartJourneyOK(t *testing.T) {
 func TestStartJourneyBadRequest(t *testing.T) {
 	mockMetrics := &metrics.MockMetrics{}
 	mockCache := &cache.MockCache{}
-	srv := NewHTTPServer(defaultConfig, mockMetrics, mockCache)
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+	mockCache.On("IsWarmedUp").Return(true)
 
 	req, _ := http.NewRequest("POST", "/character/startJourney", bytes.NewBuffer([]byte("")))
 	response := executeRequest(srv, req)
@@ -113,7 +224,7 @@ func TestStartJourneyBadRequest(t *testing.T) {
 func TestJourneysOK(t *testing.T) {
 	mockMetrics := &metrics.MockMetrics{}
 	mockCache := &cache.MockCache{}
-	srv := NewHTTPServer(defaultConfig, mockMetrics, mockCache)
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
 
 	journeyData := []cache.Journey{
 		{Id: "23->42", Points: []cache.Point{{X: 1, Y: 2}, {X: 2, Y: 2}}},
@@ -134,6 +245,789 @@ func TestJourneysOK(t *testing.T) {
 	require.Equal(t, expected, response.Body.String())
 }
 
+func TestJourneysSorted(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+
+	journeyData := []cache.Journey{
+		{Id: "23->42", Points: []cache.Point{{X: 1, Y: 2}, {X: 2, Y: 2}}},
+		{Id: "42->23", Points: []cache.Point{{X: 11, Y: 12}}},
+	}
+	mockCache.On("GetUniqueJourneys").Return(journeyData)
+
+	req, _ := http.NewRequest("GET", "/journeys?sort=pointCount", bytes.NewBuffer([]byte("")))
+	response := executeRequest(srv, req)
+
+	require.Equal(t, http.StatusOK, response.Code)
+
+	expected :=
+		"{\"journeys\":[" +
+			"{\"id\":\"42-\\u003e23\",\"data\":[{\"x\":11,\"y\":12}]}," +
+			"{\"id\":\"23-\\u003e42\",\"data\":[{\"x\":1,\"y\":2},{\"x\":2,\"y\":2}]}" +
+			"]}\n"
+	require.Equal(t, expected, response.Body.String())
+}
+
+func TestJourneysBadSort(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+
+	mockCache.On("GetUniqueJourneys").Return([]cache.Journey{})
+
+	req, _ := http.NewRequest("GET", "/journeys?sort=foo", bytes.NewBuffer([]byte("")))
+	response := executeRequest(srv, req)
+
+	require.Equal(t, http.StatusBadRequest, response.Code)
+	require.Equal(t, "Unknown sort order \"foo\"\n", response.Body.String())
+}
+
+func TestJourneysSimplified(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+
+	journeyData := []cache.Journey{
+		{Id: "23->42", Points: []cache.Point{{X: 1, Y: 2}, {X: 2, Y: 2}, {X: 3, Y: 2}, {X: 4, Y: 3}}},
+	}
+	mockCache.On("GetUniqueJourneys").Return(journeyData)
+
+	req, _ := http.NewRequest("GET", "/journeys?simplify=collinear", nil)
+	response := executeRequest(srv, req)
+	require.Equal(t, http.StatusOK, response.Code)
+	expected := "{\"journeys\":[{\"id\":\"23-\\u003e42\",\"data\":[{\"x\":1,\"y\":2},{\"x\":3,\"y\":2},{\"x\":4,\"y\":3}]}]}\n"
+	require.Equal(t, expected, response.Body.String())
+	// the points of the cache are kept
+	require.Len(t, journeyData[0].Points, 4)
+
+	req, _ = http.NewRequest("GET", "/journeys?simplify=rdp&tolerance=2", nil)
+	response = executeRequest(srv, req)
+	require.Equal(t, http.StatusOK, response.Code)
+	expected = "{\"journeys\":[{\"id\":\"23-\\u003e42\",\"data\":[{\"x\":1,\"y\":2},{\"x\":4,\"y\":3}]}]}\n"
+	require.Equal(t, expected, response.Body.String())
+
+	req, _ = http.NewRequest("GET", "/journeys?simplify=rdp&tolerance=foo", nil)
+	response = executeRequest(srv, req)
+	require.Equal(t, http.StatusBadRequest, response.Code)
+	require.Equal(t, "Invalid tolerance \"foo\"\n", response.Body.String())
+
+	req, _ = http.NewRequest("GET", "/journeys.geojson?simplify=foo", nil)
+	response = executeRequest(srv, req)
+	require.Equal(t, http.StatusBadRequest, response.Code)
+	require.Equal(t, "Unknown simplification \"foo\"\n", response.Body.String())
+}
+
+func TestJourneysGeoJSON(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	config := defaultConfig
+	config.GeoJSON = &geojson.Transform{OriginX: 100, OriginY: 100, ScaleX: 1, ScaleY: -1}
+	srv := NewHTTPServer(config, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+
+	journeyData := []cache.Journey{
+		{
+			Id:            "23->42",
+			Points:        []cache.Point{{X: 1, Y: 2}, {X: 2, Y: 2}},
+			StartId:       23,
+			DestinationId: 42,
+			FullyMapped:   true,
+		},
+	}
+	mockCache.On("GetUniqueJourneys").Return(journeyData)
+
+	req, _ := http.NewRequest("GET", "/journeys.geojson", bytes.NewBuffer([]byte("")))
+	response := executeRequest(srv, req)
+
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, "application/geo+json", response.Header().Get("Content-Type"))
+
+	expected := `{"type":"FeatureCollection","features":[` +
+		`{"type":"Feature","id":"23-\u003e42","geometry":{"type":"LineString","coordinates":[[101,98],[102,98]]},` +
+		`"properties":{"startId":23,"destinationId":42,"fullyMapped":true}}` +
+		"]}\n"
+	require.Equal(t, expected, response.Body.String())
+}
+
+func TestRoutes(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+
+	journeyData := []cache.Journey{
+		{Id: "1->2", Points: []cache.Point{{X: 1, Y: 1}}, StartId: 1, DestinationId: 2, FullyMapped: true, Stats: cache.Stats{Length: 2}},
+		{Id: "2->3", Points: []cache.Point{{X: 2, Y: 2}}, StartId: 2, DestinationId: 3, FullyMapped: true, Stats: cache.Stats{Length: 2}},
+		{Id: "1->3", Points: []cache.Point{{X: 3, Y: 3}}, StartId: 1, DestinationId: 3, Stats: cache.Stats{Length: 1}},
+	}
+	mockCache.On("GetUniqueJourneys").Return(journeyData)
+
+	req, _ := http.NewRequest("GET", "/routes?from=1&to=3", nil)
+	response := executeRequest(srv, req)
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(
+		t,
+		`{"from":1,"to":3,"length":1,"journeys":["1-\u003e3"],"points":[{"x":3,"y":3}]}`+"\n",
+		response.Body.String(),
+	)
+
+	req, _ = http.NewRequest("GET", "/routes?from=1&to=3&fullyMapped=true", nil)
+	response = executeRequest(srv, req)
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(
+		t,
+		`{"from":1,"to":3,"length":4,"journeys":["1-\u003e2","2-\u003e3"],"points":[{"x":1,"y":1},{"x":2,"y":2}]}`+"\n",
+		response.Body.String(),
+	)
+
+	req, _ = http.NewRequest("GET", "/routes?from=3&to=1", nil)
+	response = executeRequest(srv, req)
+	require.Equal(t, http.StatusNotFound, response.Code)
+	require.Equal(t, "No route between the locations\n", response.Body.String())
+}
+
+func TestRoutesBadRequest(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+
+	testCases := map[string]string{
+		"/routes?to=1":                        "Invalid location id \"\"\n",
+		"/routes?from=1&to=70000":             "Invalid location id \"70000\"\n",
+		"/routes?from=1&to=2&fullyMapped=foo": "Invalid fullyMapped \"foo\"\n",
+	}
+	for url, expected := range testCases {
+		req, _ := http.NewRequest("GET", url, nil)
+		response := executeRequest(srv, req)
+		require.Equal(t, http.StatusBadRequest, response.Code, url)
+		require.Equal(t, expected, response.Body.String(), url)
+	}
+	mockCache.AssertNumberOfCalls(t, "GetUniqueJourneys", 0)
+}
+
+func TestJourneysNear(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+
+	matches := []cache.JourneyMatch{{
+		Id: "23->42", StartId: 23, DestinationId: 42, Segments: [][]cache.Point{{{X: 1, Y: 2}}},
+	}}
+	mockCache.On("FindJourneys", cache.Circle{Center: cache.Point{X: 1, Y: 3}, Radius: 1.5}).Return(matches)
+
+	req, _ := http.NewRequest("GET", "/journeys/near?x=1&y=3&radius=1.5", nil)
+	response := executeRequest(srv, req)
+	require.Equal(t, http.StatusOK, response.Code)
+	expected := `{"journeys":[{"id":"23-\u003e42","startId":23,"destinationId":42,"fullyMapped":false,` +
+		`"segments":[[{"x":1,"y":2}]]}]}` + "\n"
+	require.Equal(t, expected, response.Body.String())
+}
+
+func TestJourneysWithin(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+
+	mockCache.On("FindJourneys", cache.BoundingBox{MinX: 1, MinY: 2, MaxX: 3, MaxY: 4}).Return([]cache.JourneyMatch{})
+
+	req, _ := http.NewRequest("GET", "/journeys/within?minX=1&minY=2&maxX=3&maxY=4", nil)
+	response := executeRequest(srv, req)
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, `{"journeys":[]}`+"\n", response.Body.String())
+}
+
+func TestJourneysAreaBadRequest(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+
+	testCases := map[string]string{
+		"/journeys/near?y=1&radius=1":                      "Invalid x \"\"\n",
+		"/journeys/near?x=1&y=1&radius=-1":                 "Invalid radius \"-1\"\n",
+		"/journeys/near?x=1&y=1&radius=NaN":                "Invalid radius \"NaN\"\n",
+		"/journeys/near?x=1&y=1&radius=65535":              "Radius 65535 exceeds the maximum of 1024\n",
+		"/journeys/within?minX=1&minY=2&maxX=3":            "Invalid maxY \"\"\n",
+		"/journeys/within?minX=4&minY=2&maxX=3&maxY=4":     "Invalid box, the minimum exceeds the maximum\n",
+		"/journeys/within?minX=1&minY=2&maxX=3&maxY=70000": "Invalid maxY \"70000\"\n",
+	}
+	for url, expected := range testCases {
+		req, _ := http.NewRequest("GET", url, nil)
+		response := executeRequest(srv, req)
+		require.Equal(t, http.StatusBadRequest, response.Code, url)
+		require.Equal(t, expected, response.Body.String(), url)
+	}
+	mockCache.AssertNumberOfCalls(t, "FindJourneys", 0)
+}
+
+func TestTraversals(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+
+	mockCache.On("GetTraversals", uint16(23), uint16(42)).Return(cache.Traversals{
+		Id:         "23->42",
+		Traversals: []cache.Traversal{{CharacterId: "character1", Points: []cache.Point{{X: 1, Y: 2}}}},
+		Consensus:  []cache.Point{{X: 1, Y: 2}},
+	}, true)
+	mockCache.On("GetTraversals", uint16(42), uint16(23)).Return(cache.Traversals{}, false)
+
+	req, _ := http.NewRequest("GET", "/journeys/23/42/traversals", nil)
+	response := executeRequest(srv, req)
+	require.Equal(t, http.StatusOK, response.Code)
+	expected := `{"id":"23-\u003e42","traversals":[{"characterId":"character1","points":[{"x":1,"y":2}]}],` +
+		`"consensus":[{"x":1,"y":2}]}` + "\n"
+	require.Equal(t, expected, response.Body.String())
+
+	req, _ = http.NewRequest("GET", "/journeys/42/23/traversals", nil)
+	response = executeRequest(srv, req)
+	require.Equal(t, http.StatusNotFound, response.Code)
+	require.Equal(t, "Unknown journey 42->23\n", response.Body.String())
+
+	req, _ = http.NewRequest("GET", "/journeys/70000/23/traversals", nil)
+	response = executeRequest(srv, req)
+	require.Equal(t, http.StatusBadRequest, response.Code)
+	require.Equal(t, "Invalid location id \"70000\"\n", response.Body.String())
+}
+
+func TestHeatmap(t *testing.T) {
+	grid := heatmap.New(cache.BoundingBox{MaxX: 3, MaxY: 3})
+	grid.Add(cache.Point{X: 1, Y: 1})
+	grid.Add(cache.Point{X: 3, Y: 0})
+	moonGrid := heatmap.New(cache.BoundingBox{MinX: 2, MinY: 2, MaxX: 3, MaxY: 3})
+	moonGrid.Add(cache.Point{X: 2, Y: 3})
+	srv := NewHTTPServer(defaultConfig, Dependencies{
+		Heatmaps: map[string]*heatmap.Heatmap{cache.DEFAULT_WORLD: grid, "moon": moonGrid},
+		Worlds:   map[string]cache.Cache{"moon": &cache.MockCache{}, "mars": &cache.MockCache{}},
+	})
+
+	req, _ := http.NewRequest("GET", "/heatmap?cell=2", nil)
+	response := executeRequest(srv, req)
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, `{"cell":2,"minX":0,"minY":0,"width":2,"height":2,"max":1,"counts":[[1,1],[0,0]]}`+"\n", response.Body.String())
+
+	// each world has its own heatmap of its bounds, unbounded worlds have none
+	req, _ = http.NewRequest("GET", "/worlds/moon/heatmap?cell=1", nil)
+	response = executeRequest(srv, req)
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, `{"cell":1,"minX":2,"minY":2,"width":2,"height":2,"max":1,"counts":[[0,0],[1,0]]}`+"\n", response.Body.String())
+	req, _ = http.NewRequest("GET", "/worlds/mars/heatmap", nil)
+	response = executeRequest(srv, req)
+	require.Equal(t, http.StatusServiceUnavailable, response.Code)
+	req, _ = http.NewRequest("GET", "/worlds/venus/heatmap", nil)
+	response = executeRequest(srv, req)
+	require.Equal(t, http.StatusNotFound, response.Code)
+
+	req, _ = http.NewRequest("GET", "/heatmap.png?cell=4", nil)
+	response = executeRequest(srv, req)
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, "image/png", response.Header().Get("Content-Type"))
+	img, err := png.Decode(response.Body)
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 4, 4), img.Bounds())
+
+	testCases := map[string]string{
+		"/heatmap?cell=foo":   "Invalid cell size \"foo\"\n",
+		"/heatmap.png?cell=0": "Invalid cell size 0, expected 1 to 4\n",
+	}
+	for url, expected := range testCases {
+		req, _ := http.NewRequest("GET", url, nil)
+		response := executeRequest(srv, req)
+		require.Equal(t, http.StatusBadRequest, response.Code, url)
+		require.Equal(t, expected, response.Body.String(), url)
+	}
+}
+
+func TestHeatmapUnavailable(t *testing.T) {
+	srv := NewHTTPServer(defaultConfig, Dependencies{})
+
+	req, _ := http.NewRequest("GET", "/heatmap", nil)
+	response := executeRequest(srv, req)
+	require.Equal(t, http.StatusServiceUnavailable, response.Code)
+	require.Equal(t, "Heatmap unavailable\n", response.Body.String())
+}
+
+func TestAnomalies(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+
+	mockCache.On("GetAnomalies").Return([]cache.CharacterAnomalies{{
+		CharacterId: "character1",
+		Count:       2,
+		Last: cache.Anomaly{
+			From:     cache.Point{X: 1, Y: 1},
+			To:       cache.Point{X: 100, Y: 1},
+			Distance: 99,
+			Elapsed:  0.5,
+			At:       time.Date(2021, 01, 01, 00, 00, 00, 0, time.UTC),
+			Rejected: true,
+		},
+	}})
+
+	req, _ := http.NewRequest("GET", "/anomalies", nil)
+	response := executeRequest(srv, req)
+	require.Equal(t, http.StatusOK, response.Code)
+	expected := `{"characters":[{"characterId":"character1","count":2,"last":{` +
+		`"from":{"x":1,"y":1},"to":{"x":100,"y":1},"distance":99,"elapsed":0.5,"at":"2021-01-01T00:00:00Z","rejected":true` +
+		"}}]}\n"
+	require.Equal(t, expected, response.Body.String())
+}
+
+func TestWorlds(t *testing.T) {
+	mockCache := &cache.MockCache{}
+	moonCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Cache: mockCache, Worlds: map[string]cache.Cache{"moon": moonCache}})
+
+	mockCache.On("GetWorld").Return(cache.World{Id: "default", Bounds: cache.BoundingBox{MaxX: 1023, MaxY: 1023}})
+	moonCache.On("GetWorld").Return(cache.World{Id: "moon", Bounds: cache.BoundingBox{MaxX: 99, MaxY: 99}})
+
+	req, _ := http.NewRequest("GET", "/worlds", nil)
+	response := executeRequest(srv, req)
+	require.Equal(t, http.StatusOK, response.Code)
+	expected := `{"worlds":[` +
+		`{"id":"default","bounds":{"minX":0,"minY":0,"maxX":1023,"maxY":1023}},` +
+		`{"id":"moon","bounds":{"minX":0,"minY":0,"maxX":99,"maxY":99}}` +
+		"]}\n"
+	require.Equal(t, expected, response.Body.String())
+}
+
+func TestWorldJourneys(t *testing.T) {
+	mockCache := &cache.MockCache{}
+	moonCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Cache: mockCache, Worlds: map[string]cache.Cache{"moon": moonCache}})
+
+	moonCache.On("GetUniqueJourneys").Return([]cache.Journey{{Id: "23->42", Points: []cache.Point{{X: 1, Y: 2}}}})
+
+	req, _ := http.NewRequest("GET", "/worlds/moon/journeys", nil)
+	response := executeRequest(srv, req)
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, "{\"journeys\":[{\"id\":\"23-\\u003e42\",\"data\":[{\"x\":1,\"y\":2}]}]}\n", response.Body.String())
+	mockCache.AssertNumberOfCalls(t, "GetUniqueJourneys", 0)
+
+	req, _ = http.NewRequest("GET", "/worlds/mars/journeys", nil)
+	response = executeRequest(srv, req)
+	require.Equal(t, http.StatusNotFound, response.Code)
+	require.Equal(t, "Unknown world \"mars\"\n", response.Body.String())
+}
+
+func TestWorldQueries(t *testing.T) {
+	mockCache := &cache.MockCache{}
+	moonCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Cache: mockCache, Worlds: map[string]cache.Cache{"moon": moonCache}})
+
+	moonCache.On("GetUniqueJourneys").Return([]cache.Journey{
+		{Id: "23->42", StartId: 23, DestinationId: 42, Points: []cache.Point{{X: 1, Y: 2}, {X: 4, Y: 6}}, Stats: cache.Stats{Length: 5}},
+	})
+	moonCache.On("FindJourneys", cache.Circle{Center: cache.Point{X: 1, Y: 2}, Radius: 1}).Return([]cache.JourneyMatch{})
+	moonCache.On("FindJourneys", cache.BoundingBox{MaxX: 3, MaxY: 3}).Return([]cache.JourneyMatch{})
+	moonCache.On("GetTraversals", uint16(23), uint16(42)).Return(cache.Traversals{Id: "23->42"}, true)
+	moonCache.On("GetAnomalies").Return([]cache.CharacterAnomalies{})
+
+	for _, path := range []string{
+		"/worlds/moon/routes?from=23&to=42",
+		"/worlds/moon/journeys/near?x=1&y=2&radius=1",
+		"/worlds/moon/journeys/within?minX=0&minY=0&maxX=3&maxY=3",
+		"/worlds/moon/journeys/23/42/traversals",
+		"/worlds/moon/anomalies",
+	} {
+		req, _ := http.NewRequest("GET", path, nil)
+		response := executeRequest(srv, req)
+		require.Equal(t, http.StatusOK, response.Code, path)
+	}
+	moonCache.AssertExpectations(t)
+
+	req, _ := http.NewRequest("GET", "/worlds/mars/anomalies", nil)
+	response := executeRequest(srv, req)
+	require.Equal(t, http.StatusNotFound, response.Code)
+	require.Equal(t, "Unknown world \"mars\"\n", response.Body.String())
+}
+
+func TestMovementsOfWorlds(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	moonCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache, Worlds: map[string]cache.Cache{"moon": moonCache}})
+	mockCache.On("IsWarmedUp").Return(true)
+	moonCache.On("IsWarmedUp").Return(true)
+
+	mockMetrics.On("LogRequest").Return()
+	mockCache.On("TimedMovements", "character1", []cache.TimedPoint{{Point: cache.Point{X: 1, Y: 2}}}).Return([]error{nil})
+	moonCache.On("TimedMovements", "character1", []cache.TimedPoint{{Point: cache.Point{X: 3, Y: 4}}}).Return([]error{nil})
+
+	// the same character id in another world is another character
+	jsonStr := []byte(`[
+		{"CharacterId": "character1", "X": 1, "Y": 2},
+		{"World": "moon", "CharacterId": "character1", "X": 3, "Y": 4},
+		{"World": "mars", "CharacterId": "character1", "X": 5, "Y": 6}
+	]`)
+	req, _ := http.NewRequest("POST", "/character/movements", bytes.NewBuffer(jsonStr))
+	response := executeRequest(srv, req)
+
+	require.Equal(t, http.StatusOK, response.Code)
+	expected := "{\"results\":[" +
+		"{\"index\":0,\"ok\":true}," +
+		"{\"index\":1,\"ok\":true}," +
+		"{\"index\":2,\"ok\":false,\"error\":\"Unknown world \\\"mars\\\"\"}" +
+		"]}\n"
+	require.Equal(t, expected, response.Body.String())
+
+	jsonStr = []byte(`{"World": "mars", "CharacterId": "character1", "StartId": 23, "DestinationId": 42}`)
+	req, _ = http.NewRequest("POST", "/character/startJourney", bytes.NewBuffer(jsonStr))
+	response = executeRequest(srv, req)
+	require.Equal(t, http.StatusBadRequest, response.Code)
+	mockCache.AssertNumberOfCalls(t, "StartJourney", 0)
+}
+
+func TestCharacters(t *testing.T) {
+	mockCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Cache: mockCache})
+
+	character := cache.Character{
+		Id:       "character1",
+		Position: &cache.Point{X: 1, Y: 2},
+		Journey:  &cache.ActiveJourney{Id: "23->42", StartId: 23, DestinationId: 42},
+		LastSeen: time.Date(2021, 01, 01, 00, 00, 00, 0, time.UTC),
+	}
+	mockCache.On("GetCharacters").Return([]cache.Character{character, {Id: "character2"}})
+	mockCache.On("GetCharacter", "character1").Return(character, true)
+	mockCache.On("GetCharacter", "character3").Return(cache.Character{}, false)
+
+	req, _ := http.NewRequest("GET", "/characters", nil)
+	response := executeRequest(srv, req)
+	require.Equal(t, http.StatusOK, response.Code)
+	characterJSON := `{"id":"character1","position":{"x":1,"y":2},` +
+		`"journey":{"id":"23-\u003e42","startId":23,"destinationId":42,"arrived":false},"lastSeen":"2021-01-01T00:00:00Z"}`
+	expected := `{"characters":[` + characterJSON + `,` +
+		`{"id":"character2","position":null,"journey":null,"lastSeen":"0001-01-01T00:00:00Z"}` +
+		"]}\n"
+	require.Equal(t, expected, response.Body.String())
+
+	req, _ = http.NewRequest("GET", "/characters/character1", nil)
+	response = executeRequest(srv, req)
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, characterJSON+"\n", response.Body.String())
+
+	req, _ = http.NewRequest("GET", "/characters/character3", nil)
+	response = executeRequest(srv, req)
+	require.Equal(t, http.StatusNotFound, response.Code)
+	require.Equal(t, "Unknown character \"character3\"\n", response.Body.String())
+}
+
+func TestProgress(t *testing.T) {
+	mockCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Cache: mockCache})
+
+	eta := time.Date(2021, 01, 01, 00, 00, 10, 0, time.UTC)
+	mockCache.On("GetProgress", "character1").Return(
+		cache.Progress{CharacterId: "character1", Journey: "23->42", Position: cache.Point{X: 6, Y: 1}, Percent: 30, Remaining: 14, Speed: 4, ETA: &eta},
+		nil,
+	)
+	mockCache.On("GetProgress", "character2").Return(cache.Progress{}, fmt.Errorf("%w: 42->23", cache.ErrNotFullyMapped))
+	mockCache.On("GetProgress", "character3").Return(cache.Progress{}, fmt.Errorf("%w \"character3\"", cache.ErrUnknownCharacter))
+
+	req, _ := http.NewRequest("GET", "/characters/character1/progress", nil)
+	response := executeRequest(srv, req)
+	require.Equal(t, http.StatusOK, response.Code)
+	expected := `{"characterId":"character1","journey":"23-\u003e42","position":{"x":6,"y":1},` +
+		`"percent":30,"remaining":14,"speed":4,"eta":"2021-01-01T00:00:10Z"}` + "\n"
+	require.Equal(t, expected, response.Body.String())
+
+	req, _ = http.NewRequest("GET", "/characters/character2/progress", nil)
+	response = executeRequest(srv, req)
+	require.Equal(t, http.StatusConflict, response.Code)
+	require.Equal(t, "Journey is not fully mapped: 42->23\n", response.Body.String())
+
+	req, _ = http.NewRequest("GET", "/characters/character3/progress", nil)
+	response = executeRequest(srv, req)
+	require.Equal(t, http.StatusNotFound, response.Code)
+}
+
+func TestStreamPositions(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	moonCache := &cache.MockCache{}
+	hub := stream.NewHub(10, 10)
+	config := defaultConfig
+	config.StreamPositions = true
+	srv := NewHTTPServer(config, Dependencies{Metrics: mockMetrics, Cache: mockCache, Hub: hub, Worlds: map[string]cache.Cache{"moon": moonCache}})
+	mockCache.On("IsWarmedUp").Return(true)
+	moonCache.On("IsWarmedUp").Return(true)
+
+	mockMetrics.On("LogRequest").Return()
+	mockCache.On("GetWorld").Return(cache.World{Id: "default"})
+	mockCache.On("TimedMovements", "character1", []cache.TimedPoint{{Point: cache.Point{X: 1, Y: 2}}, {Point: cache.Point{X: 3, Y: 4}}}).Return([]error{nil, errors.New("foo")})
+	moonCache.On("GetWorld").Return(cache.World{Id: "moon"})
+	moonCache.On("TimedMovement", "character1", uint16(5), uint16(6), time.Time{}).Return(nil)
+
+	jsonStr := []byte(`[{"CharacterId": "character1", "X": 1, "Y": 2}, {"CharacterId": "character1", "X": 3, "Y": 4}]`)
+	req, _ := http.NewRequest("POST", "/character/movements", bytes.NewBuffer(jsonStr))
+	require.Equal(t, http.StatusOK, executeRequest(srv, req).Code)
+	jsonStr = []byte(`{"World": "moon", "CharacterId": "character1", "X": 5, "Y": 6}`)
+	req, _ = http.NewRequest("POST", "/character/movement", bytes.NewBuffer(jsonStr))
+	require.Equal(t, http.StatusOK, executeRequest(srv, req).Code)
+
+	// only the accepted movements
+	_, events := hub.Subscribe(0)
+	require.Equal(
+		t,
+		[]stream.Event{
+			{Id: 1, Type: stream.EventCharacterMoved, Data: stream.CharacterMoved{CharacterId: "character1", X: 1, Y: 2}},
+			{Id: 2, Type: stream.EventCharacterMoved, Data: stream.CharacterMoved{World: "moon", CharacterId: "character1", X: 5, Y: 6}},
+		},
+		events,
+	)
+}
+
+func TestJourneyStream(t *testing.T) {
+	hub := stream.NewHub(10, 10)
+	hub.Publish(queue.NewJourney{StartId: 23, DestinationId: 42})
+	srv := NewHTTPServer(defaultConfig, Dependencies{Hub: hub})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", "/journeys/stream", nil)
+	rec := httptest.NewRecorder()
+	done := make(chan bool)
+	go func() {
+		srv.Handler.ServeHTTP(rec, req)
+		done <- true
+	}()
+
+	// published either live or, if not yet subscribed, replayed from the history
+	hub.Publish(queue.NewLocation{StartId: 23, DestinationId: 42, X: 1, Y: 2})
+	cancel()
+	<-done
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+	expected := "id: 1\nevent: journeyDiscovered\ndata: {\"id\":\"23-\\u003e42\",\"startId\":23,\"destinationId\":42}\n\n" +
+		"id: 2\nevent: pointAdded\ndata: {\"id\":\"23-\\u003e42\",\"x\":1,\"y\":2,\"seq\":0}\n\n"
+	require.Equal(t, expected, rec.Body.String())
+}
+
+func TestJourneyStreamResume(t *testing.T) {
+	hub := stream.NewHub(10, 10)
+	hub.Publish(queue.NewJourney{StartId: 23, DestinationId: 42})
+	hub.Publish(queue.JourneyFullyMapped{StartId: 23, DestinationId: 42})
+	srv := NewHTTPServer(defaultConfig, Dependencies{Hub: hub})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "/journeys/stream", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	response := executeRequest(srv, req)
+
+	// only the missed event is replayed
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, "id: 2\nevent: journeyFullyMapped\ndata: {\"id\":\"23-\\u003e42\"}\n\n", response.Body.String())
+}
+
+func TestJourneyStreamHeartbeat(t *testing.T) {
+	hub := stream.NewHub(10, 10)
+	config := defaultConfig
+	config.StreamHeartbeat = 10 * time.Millisecond
+	srv := NewHTTPServer(config, Dependencies{Hub: hub})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "/journeys/stream", nil)
+	response := executeRequest(srv, req)
+
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Contains(t, response.Body.String(), ": heartbeat\n\n")
+}
+
+func TestJourneyStreamBadLastEventId(t *testing.T) {
+	srv := NewHTTPServer(defaultConfig, Dependencies{Hub: stream.NewHub(10, 10)})
+
+	req, _ := http.NewRequest("GET", "/journeys/stream", nil)
+	req.Header.Set("Last-Event-ID", "foo")
+	response := executeRequest(srv, req)
+
+	require.Equal(t, http.StatusBadRequest, response.Code)
+	require.Equal(t, "Invalid Last-Event-ID \"foo\"\n", response.Body.String())
+}
+
+func TestCORSPreflight(t *testing.T) {
+	config := defaultConfig
+	config.CORS = CORSConfig{AllowedOrigins: []string{"http://localhost:3000"}, MaxAge: time.Hour}
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	srv := NewHTTPServer(config, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+	mockCache.On("IsWarmedUp").Return(true)
+
+	// preflight is answered for routes not registered for OPTIONS as well
+	for _, path := range []string{"/journeys", "/character/movement"} {
+		req, _ := http.NewRequest("OPTIONS", path, nil)
+		req.Header.Set("Origin", "http://localhost:3000")
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		response := executeRequest(srv, req)
+
+		require.Equal(t, http.StatusNoContent, response.Code, path)
+		require.Equal(t, "http://localhost:3000", response.Header().Get("Access-Control-Allow-Origin"))
+		require.Equal(t, "GET, POST, OPTIONS", response.Header().Get("Access-Control-Allow-Methods"))
+		require.Equal(t, "Accept, Content-Type, Last-Event-ID", response.Header().Get("Access-Control-Allow-Headers"))
+		require.Equal(t, "3600", response.Header().Get("Access-Control-Max-Age"))
+		require.Empty(t, response.Body.String())
+	}
+	mockCache.AssertNumberOfCalls(t, "GetUniqueJourneys", 0)
+}
+
+func TestCORSPreflightForbidden(t *testing.T) {
+	config := defaultConfig
+	config.CORS = CORSConfig{
+		AllowedOrigins: []string{"http://localhost:3000"},
+		AllowedMethods: []string{"GET"},
+	}
+	srv := NewHTTPServer(config, Dependencies{})
+
+	// unknown origin
+	req, _ := http.NewRequest("OPTIONS", "/journeys", nil)
+	req.Header.Set("Origin", "http://example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	response := executeRequest(srv, req)
+
+	require.Equal(t, http.StatusForbidden, response.Code)
+	require.Empty(t, response.Header().Get("Access-Control-Allow-Origin"))
+
+	// method not allowed
+	req, _ = http.NewRequest("OPTIONS", "/character/movement", nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	response = executeRequest(srv, req)
+
+	require.Equal(t, http.StatusForbidden, response.Code)
+	require.Empty(t, response.Header().Get("Access-Control-Allow-Methods"))
+}
+
+func TestCORSRequest(t *testing.T) {
+	config := defaultConfig
+	config.CORS = CORSConfig{AllowedOrigins: []string{"*"}}
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	srv := NewHTTPServer(config, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+
+	mockCache.On("GetUniqueJourneys").Return([]cache.Journey{})
+
+	req, _ := http.NewRequest("GET", "/journeys", nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	response := executeRequest(srv, req)
+
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, "*", response.Header().Get("Access-Control-Allow-Origin"))
+	require.NotContains(t, response.Header().Values("Vary"), "Origin")
+	require.Equal(t, "{\"journeys\":[]}\n", response.Body.String())
+
+	// origins listed explicitly are echoed
+	config.CORS = CORSConfig{AllowedOrigins: []string{"*", "http://localhost:3000"}}
+	srv = NewHTTPServer(config, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+	response = executeRequest(srv, req)
+
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, "http://localhost:3000", response.Header().Get("Access-Control-Allow-Origin"))
+	require.Equal(t, "Origin", response.Header().Get("Vary"))
+}
+
+func TestCORSDisabled(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+
+	mockCache.On("GetUniqueJourneys").Return([]cache.Journey{})
+
+	req, _ := http.NewRequest("GET", "/journeys", nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	response := executeRequest(srv, req)
+
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Empty(t, response.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestExport(t *testing.T) {
+	exporter := &export.MockSource{}
+	exporter.On("EachJourney").Return([]export.JourneyRow{{Id: "23-42", StartId: 23, DestinationId: 42}}, nil)
+	exporter.On("EachLocation").Return([]export.LocationRow{{JourneyId: "23-42", X: 1, Y: 2}}, nil)
+	srv := NewHTTPServer(defaultConfig, Dependencies{Exporter: exporter})
+
+	req, _ := http.NewRequest("GET", "/export/journeys.csv", nil)
+	response := executeRequest(srv, req)
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, "text/csv", response.Header().Get("Content-Type"))
+	require.Equal(t, "attachment; filename=\"journeys.csv\"", response.Header().Get("Content-Disposition"))
+	require.Equal(t, "id,startId,destinationId,fullyMapped\n23-42,23,42,false\n", response.Body.String())
+
+	req, _ = http.NewRequest("GET", "/export/locations.ndjson", nil)
+	response = executeRequest(srv, req)
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, "application/x-ndjson", response.Header().Get("Content-Type"))
+	require.Equal(t, `{"journeyId":"23-42","seq":0,"x":1,"y":2}`+"\n", response.Body.String())
+
+	req, _ = http.NewRequest("GET", "/export/locations.xml", nil)
+	response = executeRequest(srv, req)
+	require.Equal(t, http.StatusNotFound, response.Code)
+}
+
+func TestExportSimplified(t *testing.T) {
+	exporter := &export.MockSource{}
+	exporter.On("EachLocation").Return([]export.LocationRow{
+		{JourneyId: "23-42", Seq: 0, X: 1, Y: 2},
+		{JourneyId: "23-42", Seq: 1, X: 2, Y: 2},
+		{JourneyId: "23-42", Seq: 2, X: 3, Y: 2},
+	}, nil)
+	srv := NewHTTPServer(defaultConfig, Dependencies{Exporter: exporter})
+
+	req, _ := http.NewRequest("GET", "/export/locations.csv?simplify=collinear", nil)
+	response := executeRequest(srv, req)
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, "journeyId,seq,x,y\n23-42,0,1,2\n23-42,2,3,2\n", response.Body.String())
+}
+
+func TestExportWithoutStore(t *testing.T) {
+	srv := NewHTTPServer(defaultConfig, Dependencies{})
+
+	req, _ := http.NewRequest("GET", "/export/journeys.csv", nil)
+	response := executeRequest(srv, req)
+	require.Equal(t, http.StatusServiceUnavailable, response.Code)
+	require.Equal(t, "Store unavailable\n", response.Body.String())
+}
+
+func TestHealth(t *testing.T) {
+	srv := NewHTTPServer(defaultConfig, Dependencies{})
+
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	response := executeRequest(srv, req)
+
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, "{\"status\":\"up\"}\n", response.Body.String())
+}
+
+func TestReady(t *testing.T) {
+	checker := health.NewChecker()
+	checker.Register("store", func() error { return nil })
+	srv := NewHTTPServer(defaultConfig, Dependencies{Checker: checker})
+
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	response := executeRequest(srv, req)
+
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, "{\"status\":\"up\",\"components\":{\"store\":{\"status\":\"up\"}}}\n", response.Body.String())
+}
+
+func TestNotReady(t *testing.T) {
+	checker := health.NewChecker()
+	checker.Register("store", func() error { return nil })
+	checker.Register("cache", func() error { return errors.New("Warming up") })
+	srv := NewHTTPServer(defaultConfig, Dependencies{Checker: checker})
+
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	response := executeRequest(srv, req)
+
+	require.Equal(t, http.StatusServiceUnavailable, response.Code)
+	expected := "{\"status\":\"down\",\"components\":{" +
+		"\"cache\":{\"status\":\"down\",\"error\":\"Warming up\"}," +
+		"\"store\":{\"status\":\"up\"}" +
+		"}}\n"
+	require.Equal(t, expected, response.Body.String())
+}
+
 func TestServeHome(t *testing.T) {
 	publicDir, err := ioutil.TempDir("", "public")
 	require.NoError(t, err)
@@ -153,7 +1047,7 @@ func TestServeHome(t *testing.T) {
 	}
 	mockMetrics := &metrics.MockMetrics{}
 	mockCache := &cache.MockCache{}
-	srv := NewHTTPServer(config, mockMetrics, mockCache)
+	srv := NewHTTPServer(config, Dependencies{Metrics: mockMetrics, Cache: mockCache})
 
 	req, _ := http.NewRequest("GET", "/", bytes.NewBuffer([]byte("")))
 	response := executeRequest(srv, req)
@@ -177,7 +1071,7 @@ func TestServeStaticFiles(t *testing.T) {
 	}
 	mockMetrics := &metrics.MockMetrics{}
 	mockCache := &cache.MockCache{}
-	srv := NewHTTPServer(config, mockMetrics, mockCache)
+	srv := NewHTTPServer(config, Dependencies{Metrics: mockMetrics, Cache: mockCache})
 
 	req, _ := http.NewRequest("GET", fmt.Sprintf("/static/js/%s", filepath.Base(f.Name())), bytes.NewBuffer([]byte("")))
 	response := executeRequest(srv, req)
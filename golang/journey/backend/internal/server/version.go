@@ -0,0 +1,61 @@
+package server
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	API_VERSION_1 = 1
+	API_VERSION_2 = 2
+
+	DEFAULT_API_VERSION = API_VERSION_1
+)
+
+// versioned routes the request to the handler of the version requested with the Accept header,
+// e.g. `Accept: application/json; version=2`. Requests without a version get the default one.
+func versioned(handlers map[int]http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+
+		version, err := acceptedVersion(r.Header.Get("Accept"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotAcceptable)
+			return
+		}
+		handler, ok := handlers[version]
+		if !ok {
+			http.Error(w, fmt.Sprintf("Unsupported API version %d", version), http.StatusNotAcceptable)
+			return
+		}
+
+		w.Header().Set("Content-Type", fmt.Sprintf("application/json; version=%d", version))
+		handler(w, r)
+	}
+}
+
+func acceptedVersion(accept string) (int, error) {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		if strings.TrimSpace(mediaRange) == "" {
+			continue
+		}
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid Accept header: %s", err)
+		}
+		if mediaType != "application/json" && mediaType != "application/*" && mediaType != "*/*" {
+			continue
+		}
+		if value, ok := params["version"]; ok {
+			version, err := strconv.Atoi(value)
+			if err != nil {
+				return 0, fmt.Errorf("Invalid API version %q", value)
+			}
+			return version, nil
+		}
+	}
+	return DEFAULT_API_VERSION, nil
+}
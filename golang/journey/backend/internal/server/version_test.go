@@ -0,0 +1,108 @@
+package server
+
+import (
+	"bytes"
+	"fiurgeist/journey/internal/cache"
+	"fiurgeist/journey/internal/metrics"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestJourneysVersion1(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+
+	journeyData := []cache.Journey{
+		{Id: "23->42", Points: []cache.Point{{X: 1, Y: 2}}, StartId: 23, DestinationId: 42, FullyMapped: true},
+	}
+	mockCache.On("GetUniqueJourneys").Return(journeyData)
+
+	req, _ := http.NewRequest("GET", "/journeys", bytes.NewBuffer([]byte("")))
+	req.Header.Set("Accept", "application/json; version=1")
+	response := executeRequest(srv, req)
+
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, "application/json; version=1", response.Header().Get("Content-Type"))
+	require.Equal(t, "{\"journeys\":[{\"id\":\"23-\\u003e42\",\"data\":[{\"x\":1,\"y\":2}]}]}\n", response.Body.String())
+}
+
+func TestJourneysVersion2(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+
+	journeyData := []cache.Journey{
+		{
+			Id:            "23->42",
+			Points:        []cache.Point{{X: 1, Y: 2}},
+			StartId:       23,
+			DestinationId: 42,
+			FullyMapped:   true,
+			DiscoveredAt:  time.Date(2021, 01, 01, 00, 00, 00, 0, time.UTC),
+			Stats: cache.Stats{
+				PointCount:  1,
+				BoundingBox: cache.BoundingBox{MinX: 1, MinY: 2, MaxX: 1, MaxY: 2},
+				Characters:  1,
+			},
+		},
+	}
+	mockCache.On("GetUniqueJourneys").Return(journeyData)
+
+	req, _ := http.NewRequest("GET", "/journeys", bytes.NewBuffer([]byte("")))
+	req.Header.Set("Accept", "text/html, application/json; version=2")
+	response := executeRequest(srv, req)
+
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, "application/json; version=2", response.Header().Get("Content-Type"))
+	expected := "{\"journeys\":[{" +
+		"\"id\":\"23-\\u003e42\",\"startId\":23,\"destinationId\":42,\"fullyMapped\":true," +
+		"\"discoveredAt\":\"2021-01-01T00:00:00Z\",\"pointCount\":1,\"points\":[{\"x\":1,\"y\":2}]," +
+		"\"stats\":{\"length\":0,\"pointCount\":1,\"boundingBox\":{\"minX\":1,\"minY\":2,\"maxX\":1,\"maxY\":2}," +
+		"\"directness\":0,\"characters\":1}" +
+		"}]}\n"
+	require.Equal(t, expected, response.Body.String())
+}
+
+func TestJourneysUnsupportedVersion(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+
+	req, _ := http.NewRequest("GET", "/journeys", bytes.NewBuffer([]byte("")))
+	req.Header.Set("Accept", "application/json; version=23")
+	response := executeRequest(srv, req)
+
+	require.Equal(t, http.StatusNotAcceptable, response.Code)
+	require.Equal(t, "Unsupported API version 23\n", response.Body.String())
+	mockCache.AssertNumberOfCalls(t, "GetUniqueJourneys", 0)
+}
+
+func TestAcceptedVersion(t *testing.T) {
+	testCases := []struct {
+		accept  string
+		version int
+		err     string
+	}{
+		{accept: "", version: DEFAULT_API_VERSION},
+		{accept: "*/*", version: DEFAULT_API_VERSION},
+		{accept: "application/json", version: DEFAULT_API_VERSION},
+		{accept: "application/json; version=2", version: 2},
+		{accept: "text/html; version=3, */*; version=2", version: 2},
+		{accept: "application/json; version=foo", err: "Invalid API version \"foo\""},
+		{accept: "application/json; =", err: "Invalid Accept header: mime: invalid media parameter"},
+	}
+
+	for _, testCase := range testCases {
+		version, err := acceptedVersion(testCase.accept)
+		if testCase.err != "" {
+			require.Error(t, err, testCase.accept)
+			require.Equal(t, testCase.err, err.Error(), testCase.accept)
+			continue
+		}
+		require.NoError(t, err, testCase.accept)
+		require.Equal(t, testCase.version, version, testCase.accept)
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"encoding/json"
+	"fiurgeist/journey/internal/cache"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	FrameAuth   = "auth"
+	FrameStart  = "start"
+	FrameMove   = "move"
+	FrameArrive = "arrive"
+
+	maxFrameSize = 4096
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// TelemetryFrame is sent by the client, the first frame has to authenticate the character. The
+// auth frame only claims the character id like the HTTP endpoints do, verifying it is left to a
+// proxy in front of the server; the server itself has no notion of users.
+type TelemetryFrame struct {
+	Seq         uint64 `json:"seq"`
+	Type        string `json:"type"`
+	CharacterId string `json:"characterId,omitempty"`
+	// World of the authenticated character, the default world if empty
+	World         string `json:"world,omitempty"`
+	StartId       uint16 `json:"startId,omitempty"`
+	DestinationId uint16 `json:"destinationId,omitempty"`
+	X             uint16 `json:"x,omitempty"`
+	Y             uint16 `json:"y,omitempty"`
+	// At is the optional time the client took the movement, see MovementRequest
+	At time.Time `json:"at,omitempty"`
+}
+
+// TelemetryAck answers every frame with either ok or the error of the frame
+type TelemetryAck struct {
+	Seq   uint64 `json:"seq"`
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+func (s *httpServer) handleTelemetry(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already answered with an error
+		log.Printf("Failed to upgrade telemetry connection: %s\n", err)
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(maxFrameSize)
+	// clients sending neither frames nor pongs are dropped, the pings keep idle clients alive
+	conn.SetReadDeadline(time.Now().Add(s.telemetryTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(s.telemetryTimeout))
+	})
+	done := make(chan struct{})
+	defer close(done)
+	go pingTelemetry(conn, s.telemetryTimeout/2, done)
+
+	s.metrics.LogConnectionOpened()
+	defer s.metrics.LogConnectionClosed()
+
+	var auth TelemetryFrame
+	if err := conn.ReadJSON(&auth); err != nil {
+		closeTelemetry(conn, websocket.CloseUnsupportedData, "Invalid frame")
+		return
+	}
+	if auth.Type != FrameAuth || auth.CharacterId == "" {
+		conn.WriteJSON(TelemetryAck{Seq: auth.Seq, Error: "First frame has to authenticate a character"})
+		closeTelemetry(conn, websocket.ClosePolicyViolation, "Not authenticated")
+		return
+	}
+	world, err := s.worldCache(auth.World)
+	if err != nil {
+		conn.WriteJSON(TelemetryAck{Seq: auth.Seq, Error: err.Error()})
+		closeTelemetry(conn, websocket.ClosePolicyViolation, "Unknown world")
+		return
+	}
+	if err := conn.WriteJSON(TelemetryAck{Seq: auth.Seq, Ok: true}); err != nil {
+		return
+	}
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			// closed by the client or broken connection
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(s.telemetryTimeout))
+		s.metrics.LogRequest()
+
+		var frame TelemetryFrame
+		err = json.Unmarshal(data, &frame)
+		if err == nil {
+			err = applyFrame(world, auth.CharacterId, frame)
+		}
+		if err == nil && frame.Type == FrameMove {
+			s.publishPosition(world, auth.CharacterId, frame.X, frame.Y)
+		}
+		ack := TelemetryAck{Seq: frame.Seq, Ok: err == nil}
+		if err != nil {
+			ack.Error = err.Error()
+		}
+		if err := conn.WriteJSON(ack); err != nil {
+			return
+		}
+	}
+}
+
+func applyFrame(world cache.Cache, characterId string, frame TelemetryFrame) error {
+	switch frame.Type {
+	case FrameStart:
+		world.StartJourney(characterId, frame.StartId, frame.DestinationId)
+		return nil
+	case FrameMove:
+		return world.TimedMovement(characterId, frame.X, frame.Y, frame.At)
+	case FrameArrive:
+		return world.ReachedDestination(characterId, frame.DestinationId)
+	}
+	return fmt.Errorf("Unknown frame type %q", frame.Type)
+}
+
+// pingTelemetry pings the client until done, control frames may be written concurrently to the
+// acks of the frames
+func pingTelemetry(conn *websocket.Conn, interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(interval)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func closeTelemetry(conn *websocket.Conn, code int, reason string) {
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+}
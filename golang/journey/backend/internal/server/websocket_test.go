@@ -0,0 +1,145 @@
+package server
+
+import (
+	"errors"
+	"fiurgeist/journey/internal/cache"
+	"fiurgeist/journey/internal/metrics"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTelemetry(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+	mockCache.On("IsWarmedUp").Return(true)
+
+	closed := make(chan bool, 1)
+	mockMetrics.On("LogConnectionOpened").Return()
+	mockMetrics.On("LogConnectionClosed").Run(func(mock.Arguments) { closed <- true }).Return()
+	mockMetrics.On("LogRequest").Return()
+	mockCache.On("StartJourney", "character1", uint16(23), uint16(42)).Return()
+	mockCache.On("TimedMovement", "character1", uint16(1), uint16(2), time.Time{}).Return(nil)
+	mockCache.On("ReachedDestination", "character1", uint16(42)).Return(errors.New("foo"))
+
+	conn, close := dialTelemetry(t, srv)
+	defer close()
+
+	// authenticate
+	require.Equal(t, TelemetryAck{Seq: 1, Ok: true}, sendFrame(t, conn, `{"seq": 1, "type": "auth", "characterId": "character1"}`))
+
+	// frames are passed to the cache
+	require.Equal(t, TelemetryAck{Seq: 2, Ok: true}, sendFrame(t, conn, `{"seq": 2, "type": "start", "startId": 23, "destinationId": 42}`))
+	require.Equal(t, TelemetryAck{Seq: 3, Ok: true}, sendFrame(t, conn, `{"seq": 3, "type": "move", "x": 1, "y": 2}`))
+	require.Equal(t, TelemetryAck{Seq: 4, Error: "foo"}, sendFrame(t, conn, `{"seq": 4, "type": "arrive", "destinationId": 42}`))
+	mockCache.AssertCalled(t, "StartJourney", "character1", uint16(23), uint16(42))
+	mockCache.AssertCalled(t, "TimedMovement", "character1", uint16(1), uint16(2), time.Time{})
+	mockCache.AssertCalled(t, "ReachedDestination", "character1", uint16(42))
+
+	// invalid frames are answered with an error, the connection stays open
+	require.Equal(t, TelemetryAck{Seq: 5, Error: "Unknown frame type \"foo\""}, sendFrame(t, conn, `{"seq": 5, "type": "foo"}`))
+	ack := sendFrame(t, conn, `foo`)
+	require.False(t, ack.Ok)
+	require.Equal(t, TelemetryAck{Seq: 6, Ok: true}, sendFrame(t, conn, `{"seq": 6, "type": "move", "x": 1, "y": 2}`))
+
+	mockMetrics.AssertCalled(t, "LogConnectionOpened")
+	mockMetrics.AssertNumberOfCalls(t, "LogRequest", 6)
+
+	// closing the connection is logged
+	conn.Close()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		require.Fail(t, "Connection close not logged")
+	}
+}
+
+func TestTelemetryNotAuthenticated(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	srv := NewHTTPServer(defaultConfig, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+	mockCache.On("IsWarmedUp").Return(true)
+
+	mockMetrics.On("LogConnectionOpened").Return()
+	mockMetrics.On("LogConnectionClosed").Return()
+
+	conn, close := dialTelemetry(t, srv)
+	defer close()
+
+	ack := sendFrame(t, conn, `{"seq": 1, "type": "move", "x": 1, "y": 2}`)
+	require.Equal(t, TelemetryAck{Seq: 1, Error: "First frame has to authenticate a character"}, ack)
+
+	// connection is closed by the server
+	_, _, err := conn.ReadMessage()
+	require.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation))
+	mockCache.AssertNumberOfCalls(t, "TimedMovement", 0)
+}
+
+func TestTelemetryTimeout(t *testing.T) {
+	mockMetrics := &metrics.MockMetrics{}
+	mockCache := &cache.MockCache{}
+	config := defaultConfig
+	config.TelemetryTimeout = 50 * time.Millisecond
+	srv := NewHTTPServer(config, Dependencies{Metrics: mockMetrics, Cache: mockCache})
+	mockCache.On("IsWarmedUp").Return(true)
+
+	closed := make(chan bool, 2)
+	mockMetrics.On("LogConnectionOpened").Return()
+	mockMetrics.On("LogConnectionClosed").Run(func(mock.Arguments) { closed <- true }).Return()
+
+	// reading answers the pings of the server
+	conn, close := dialTelemetry(t, srv)
+	defer close()
+	require.Equal(t, TelemetryAck{Seq: 1, Ok: true}, sendFrame(t, conn, `{"seq": 1, "type": "auth", "characterId": "character1"}`))
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// without reading no pong is sent
+	idle, closeIdle := dialTelemetry(t, srv)
+	defer closeIdle()
+	require.Equal(t, TelemetryAck{Seq: 1, Ok: true}, sendFrame(t, idle, `{"seq": 1, "type": "auth", "characterId": "character2"}`))
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		require.Fail(t, "Idle connection not closed")
+	}
+	select {
+	case <-closed:
+		require.Fail(t, "Connection answering pings closed")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func dialTelemetry(t *testing.T, srv *http.Server) (*websocket.Conn, func()) {
+	testSrv := httptest.NewServer(srv.Handler)
+	url := "ws" + strings.TrimPrefix(testSrv.URL, "http") + "/character/telemetry"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+
+	return conn, func() {
+		conn.Close()
+		testSrv.Close()
+	}
+}
+
+func sendFrame(t *testing.T, conn *websocket.Conn, frame string) TelemetryAck {
+	err := conn.WriteMessage(websocket.TextMessage, []byte(frame))
+	require.NoError(t, err)
+
+	var ack TelemetryAck
+	err = conn.ReadJSON(&ack)
+	require.NoError(t, err)
+	return ack
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fiurgeist/journey/internal/queue"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DeadLetter is a message which couldn't be written to the database, even after retrying
+type DeadLetter struct {
+	Type     string          `json:"type"`
+	Message  json.RawMessage `json:"message"`
+	Error    string          `json:"error"`
+	Attempts int             `json:"attempts"`
+	FailedAt time.Time       `json:"failedAt"`
+}
+
+func NewDeadLetter(msg interface{}, err error, attempts int) (DeadLetter, error) {
+	msgType, data, encodeErr := queue.EncodeMessage(msg)
+	if encodeErr != nil {
+		return DeadLetter{}, encodeErr
+	}
+	return DeadLetter{
+		Type:     msgType,
+		Message:  data,
+		Error:    err.Error(),
+		Attempts: attempts,
+		FailedAt: time.Now(),
+	}, nil
+}
+
+func (d DeadLetter) Decode() (interface{}, error) {
+	return queue.DecodeMessage(d.Type, d.Message)
+}
+
+// deadLetterFile appends dead letters to a JSONL file, one letter per line
+type deadLetterFile struct {
+	mu   sync.Mutex
+	path string
+}
+
+func newDeadLetterFile(path string) *deadLetterFile {
+	return &deadLetterFile{path: path}
+}
+
+func (f *deadLetterFile) Write(letter DeadLetter) error {
+	line, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// dead letters are rare, there's no need to keep the file open
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// ReadDeadLetters reads all dead letters of a file written by the store
+func ReadDeadLetters(path string) ([]DeadLetter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var letters []DeadLetter
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var letter DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			return nil, fmt.Errorf("Invalid dead letter in line %d: %s", line, err)
+		}
+		letters = append(letters, letter)
+	}
+	return letters, scanner.Err()
+}
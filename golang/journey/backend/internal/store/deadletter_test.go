@@ -0,0 +1,64 @@
+package store
+
+import (
+	"errors"
+	"fiurgeist/journey/internal/queue"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeadLetterFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dead-letters.jsonl")
+
+	msgs := []interface{}{
+		queue.NewJourney{StartId: 23, DestinationId: 42},
+		queue.NewLocation{StartId: 23, DestinationId: 42, X: 1, Y: 2, Seq: 0},
+	}
+	file := newDeadLetterFile(path)
+	for _, msg := range msgs {
+		letter, err := NewDeadLetter(msg, errors.New("database is locked"), 5)
+		require.NoError(t, err)
+		require.NoError(t, file.Write(letter))
+	}
+
+	// letters are appended and decode to the original messages
+	letters, err := ReadDeadLetters(path)
+	require.NoError(t, err)
+	require.Len(t, letters, 2)
+	for i, letter := range letters {
+		require.Equal(t, "database is locked", letter.Error)
+		require.Equal(t, 5, letter.Attempts)
+		msg, err := letter.Decode()
+		require.NoError(t, err)
+		require.Equal(t, msgs[i], msg)
+	}
+}
+
+func TestNewDeadLetterUnknownMessage(t *testing.T) {
+	_, err := NewDeadLetter("foo", errors.New("bar"), 1)
+	require.Error(t, err)
+	require.Equal(t, "Unknown message type string", err.Error())
+}
+
+func TestReadDeadLettersError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dead-letters.jsonl")
+
+	_, err = ReadDeadLetters(path)
+	require.Error(t, err)
+	require.True(t, os.IsNotExist(err))
+
+	err = ioutil.WriteFile(path, []byte("{\"type\":\"NewJourney\"}\n\nfoo\n"), 0644)
+	require.NoError(t, err)
+	_, err = ReadDeadLetters(path)
+	require.Error(t, err)
+	require.Equal(t, "Invalid dead letter in line 3: invalid character 'o' in literal false (expecting 'a')", err.Error())
+}
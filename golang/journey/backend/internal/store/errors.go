@@ -0,0 +1,102 @@
+package store
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/mattn/go-sqlite3"
+	"strings"
+)
+
+// Kinds of database errors, check them with errors.Is
+var (
+	ErrUniqueViolation = errors.New("unique violation")
+	ErrConnectionLost  = errors.New("connection lost")
+	ErrBusy            = errors.New("database busy")
+	ErrSchema          = errors.New("schema error")
+)
+
+// dbError is a database error classified by one of the kinds above, it keeps the message
+// of the driver
+type dbError struct {
+	kind error
+	err  error
+}
+
+func (e *dbError) Error() string {
+	return e.err.Error()
+}
+
+func (e *dbError) Unwrap() error {
+	return e.err
+}
+
+func (e *dbError) Is(target error) bool {
+	return e.kind == target
+}
+
+// classifier returns the kind of a driver error or nil if it is unknown
+type classifier func(err error) error
+
+func classify(c classifier, err error) error {
+	if err == nil {
+		return nil
+	}
+	// database/sql reports these itself, independent of the driver. A closed database has no
+	// exported error to compare with.
+	kind := error(nil)
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || err.Error() == "sql: database is closed" {
+		kind = ErrConnectionLost
+	} else if c != nil {
+		kind = c(err)
+	}
+
+	if kind == nil {
+		return err
+	}
+	return &dbError{kind: kind, err: err}
+}
+
+// isTransient reports whether writing again may succeed
+func isTransient(err error) bool {
+	return errors.Is(err, ErrConnectionLost) || errors.Is(err, ErrBusy)
+}
+
+// ramsql only reports plain error messages
+func classifyRamsql(err error) error {
+	msg := strings.ToLower(err.Error())
+	switch {
+	case msg == "unique constraint violation":
+		return ErrUniqueViolation
+	case msg == "connection closed":
+		return ErrConnectionLost
+	case strings.Contains(msg, "does not exist"), strings.Contains(msg, "not found"), strings.Contains(msg, "already exists"):
+		return ErrSchema
+	}
+	return nil
+}
+
+func classifySQLite(err error) error {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return nil
+	}
+
+	switch sqliteErr.Code {
+	case sqlite3.ErrConstraint:
+		if sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+			return ErrUniqueViolation
+		}
+	case sqlite3.ErrBusy, sqlite3.ErrLocked:
+		return ErrBusy
+	case sqlite3.ErrIoErr, sqlite3.ErrCantOpen:
+		return ErrConnectionLost
+	case sqlite3.ErrError:
+		// missing tables and columns only differ in the message from other SQL errors
+		msg := err.Error()
+		if strings.HasPrefix(msg, "no such table") || strings.HasPrefix(msg, "no such column") || strings.Contains(msg, "has no column named") {
+			return ErrSchema
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClassifyRamsql(t *testing.T) {
+	db, err := sql.Open("ramsql", "TestClassifyRamsql")
+	require.NoError(t, err)
+
+	_, err = db.Exec(`SELECT * FROM journey WHERE 1;`)
+	assertKind(t, ErrSchema, classify(classifyRamsql, err))
+
+	_, err = db.Exec(`CREATE TABLE journey (id TEXT UNIQUE NOT NULL);`)
+	require.NoError(t, err)
+	_, err = db.Exec(`CREATE TABLE journey (id TEXT UNIQUE NOT NULL);`)
+	assertKind(t, ErrSchema, classify(classifyRamsql, err))
+
+	_, err = db.Exec(`INSERT INTO journey (id) VALUES ('23-42');`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO journey (id) VALUES ('23-42');`)
+	assertKind(t, ErrUniqueViolation, classify(classifyRamsql, err))
+	// the message of the driver is kept
+	require.Equal(t, "UNIQUE constraint violation", classify(classifyRamsql, err).Error())
+
+	db.Close()
+	_, err = db.Exec(`INSERT INTO journey (id) VALUES ('42-23');`)
+	assertKind(t, ErrConnectionLost, classify(classifyRamsql, err))
+}
+
+func TestClassifySQLite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "journey.db"))
+	require.NoError(t, err)
+
+	_, err = db.Exec(`SELECT * FROM journey;`)
+	assertKind(t, ErrSchema, classify(classifySQLite, err))
+
+	_, err = db.Exec(`CREATE TABLE journey (id TEXT UNIQUE NOT NULL);`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO journey (foo) VALUES ('23-42');`)
+	assertKind(t, ErrSchema, classify(classifySQLite, err))
+
+	_, err = db.Exec(`INSERT INTO journey (id) VALUES ('23-42');`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO journey (id) VALUES ('23-42');`)
+	assertKind(t, ErrUniqueViolation, classify(classifySQLite, err))
+
+	// upserts skip duplicates
+	_, err = db.Exec(`INSERT OR IGNORE INTO journey (id) VALUES ('23-42');`)
+	require.NoError(t, err)
+
+	db.Close()
+	_, err = db.Exec(`INSERT INTO journey (id) VALUES ('42-23');`)
+	assertKind(t, ErrConnectionLost, classify(classifySQLite, err))
+}
+
+func TestClassifyUnknown(t *testing.T) {
+	err := errors.New("foo")
+	require.Equal(t, err, classify(classifyRamsql, err))
+	require.Equal(t, err, classify(classifySQLite, err))
+	require.False(t, isTransient(err))
+	require.Nil(t, classify(classifyRamsql, nil))
+}
+
+func assertKind(t *testing.T, kind error, err error) {
+	require.Error(t, err)
+	for _, k := range []error{ErrUniqueViolation, ErrConnectionLost, ErrBusy, ErrSchema} {
+		require.Equal(t, k == kind, errors.Is(err, k), "%s is %s", err, k)
+	}
+	require.Equal(t, kind == ErrConnectionLost || kind == ErrBusy, isTransient(err))
+}
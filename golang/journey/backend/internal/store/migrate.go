@@ -0,0 +1,135 @@
+package store
+
+import (
+	"database/sql"
+	"fiurgeist/journey/internal/cache"
+	"fiurgeist/journey/internal/export"
+	"fiurgeist/journey/internal/queue"
+	"log"
+	"time"
+)
+
+// MergeReverseJourneys migrates the store to the undirected mode of the cache, which only knows
+// the route from the lower to the higher location id. Journeys in the other direction are
+// reversed into that route. If both directions are stored, the fully mapped one with the
+// most points is kept and the other one is dropped. It returns the number of merged journeys,
+// an interrupted migration is completed by running it again.
+func (s *store) MergeReverseJourneys() (int, error) {
+	routes := make(map[string]export.JourneyRow)
+	var reverse []export.JourneyRow
+	err := s.EachJourney(func(row export.JourneyRow) error {
+		routes[row.Id] = row
+		if row.StartId > row.DestinationId {
+			reverse = append(reverse, row)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	stats, err := s.loadStats()
+	if err != nil {
+		return 0, err
+	}
+
+	for i, row := range reverse {
+		if err := s.mergeReverseJourney(row, routes, stats); err != nil {
+			log.Printf("Failed to merge journey %s: %s\n", row.Id, err)
+			return i, err
+		}
+	}
+	return len(reverse), nil
+}
+
+func (s *store) mergeReverseJourney(row export.JourneyRow, routes map[string]export.JourneyRow, stats map[string]cache.Stats) error {
+	points, _, err := s.loadPoints(row.Id)
+	if err != nil {
+		return err
+	}
+
+	world, startId, destinationId := journeyWorld(row.Id), row.DestinationId, row.StartId
+	route, exists := routes[JourneyId(world, startId, destinationId)]
+	keep := !exists
+	if exists {
+		routePoints, _, err := s.loadPoints(route.Id)
+		if err != nil {
+			return err
+		}
+		keep = row.FullyMapped && !route.FullyMapped ||
+			row.FullyMapped == route.FullyMapped && len(points) > len(routePoints)
+	}
+
+	if keep {
+		// the route is written completely before the reverse journey is deleted
+		if exists {
+			if err := s.deleteLocations(route.Id); err != nil {
+				return err
+			}
+		} else {
+			discoveredAt, err := s.discoveredAt(row.Id)
+			if err != nil {
+				return err
+			}
+			if err := s.newJourney(world, startId, destinationId, discoveredAt); err != nil {
+				return err
+			}
+		}
+		if row.FullyMapped {
+			if err := s.journeyFullyMapped(world, startId, destinationId); err != nil {
+				return err
+			}
+		}
+		for i := range points {
+			point := points[len(points)-1-i]
+			if err := s.newLocation(world, startId, destinationId, point.X, point.Y, i); err != nil {
+				return err
+			}
+		}
+		if rowStats, ok := stats[row.Id]; ok {
+			box := rowStats.BoundingBox
+			err := s.journeyStats(queue.JourneyStats{
+				World:         world,
+				StartId:       startId,
+				DestinationId: destinationId,
+				Length:        rowStats.Length,
+				PointCount:    rowStats.PointCount,
+				MinX:          box.MinX,
+				MinY:          box.MinY,
+				MaxX:          box.MaxX,
+				MaxY:          box.MaxY,
+				Characters:    rowStats.Characters,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return s.deleteJourney(row.Id)
+}
+
+// discoveredAt is zero for journeys stored before it was recorded
+func (s *store) discoveredAt(journeyId string) (time.Time, error) {
+	var discoveredAt sql.NullInt64
+	err := s.db.QueryRow(`SELECT discovered_at FROM journey WHERE id = $1;`, journeyId).Scan(&discoveredAt)
+	if err != nil || !discoveredAt.Valid || discoveredAt.Int64 == 0 {
+		return time.Time{}, err
+	}
+	return time.Unix(0, discoveredAt.Int64).UTC(), nil
+}
+
+func (s *store) deleteLocations(journeyId string) error {
+	_, err := s.db.Exec(`DELETE FROM location WHERE journey_id = $1;`, journeyId)
+	return classify(s.dialect.classify, err)
+}
+
+// deleteJourney removes a journey with its locations and stats
+func (s *store) deleteJourney(journeyId string) error {
+	if err := s.deleteLocations(journeyId); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(`DELETE FROM journey_stats WHERE journey_id = $1;`, journeyId); err != nil {
+		return classify(s.dialect.classify, err)
+	}
+	_, err := s.db.Exec(`DELETE FROM journey WHERE id = $1;`, journeyId)
+	return classify(s.dialect.classify, err)
+}
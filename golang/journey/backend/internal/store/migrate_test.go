@@ -0,0 +1,94 @@
+package store
+
+import (
+	"fiurgeist/journey/internal/cache"
+	"fiurgeist/journey/internal/queue"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestMergeReverseJourneys(t *testing.T) {
+	store, err := Open(Config{Driver: "ramsql", DSN: "TestMergeReverseJourneys"})
+	require.NoError(t, err)
+	defer store.Close()
+
+	msgs := []interface{}{
+		// only reversed
+		queue.NewJourney{StartId: 42, DestinationId: 23, DiscoveredAt: mockNow()},
+		queue.NewLocation{StartId: 42, DestinationId: 23, X: 1, Y: 1, Seq: 0},
+		queue.NewLocation{StartId: 42, DestinationId: 23, X: 2, Y: 2, Seq: 1},
+		queue.JourneyFullyMapped{StartId: 42, DestinationId: 23},
+		queue.JourneyStats{StartId: 42, DestinationId: 23, Length: 1.5, PointCount: 2, MaxX: 2, MaxY: 2, Characters: 1},
+		// the reversed journey has more points
+		queue.NewJourney{StartId: 3, DestinationId: 7, DiscoveredAt: mockNow()},
+		queue.NewLocation{StartId: 3, DestinationId: 7, X: 5, Y: 5, Seq: 0},
+		queue.NewJourney{StartId: 7, DestinationId: 3, DiscoveredAt: mockNow().Add(time.Hour)},
+		queue.NewLocation{StartId: 7, DestinationId: 3, X: 6, Y: 6, Seq: 0},
+		queue.NewLocation{StartId: 7, DestinationId: 3, X: 7, Y: 7, Seq: 1},
+		// the reversed journey isn't fully mapped
+		queue.NewJourney{StartId: 5, DestinationId: 9, DiscoveredAt: mockNow()},
+		queue.NewLocation{StartId: 5, DestinationId: 9, X: 8, Y: 8, Seq: -1},
+		queue.NewLocation{StartId: 5, DestinationId: 9, X: 9, Y: 9, Seq: 0},
+		queue.JourneyFullyMapped{StartId: 5, DestinationId: 9},
+		queue.NewJourney{StartId: 9, DestinationId: 5, DiscoveredAt: mockNow().Add(time.Hour)},
+		queue.NewLocation{StartId: 9, DestinationId: 5, X: 1, Y: 9, Seq: 0},
+		queue.NewLocation{StartId: 9, DestinationId: 5, X: 2, Y: 9, Seq: 1},
+		queue.NewLocation{StartId: 9, DestinationId: 5, X: 3, Y: 9, Seq: 2},
+	}
+	for _, msg := range msgs {
+		require.NoError(t, store.process(msg))
+	}
+
+	merged, err := store.MergeReverseJourneys()
+	require.NoError(t, err)
+	require.Equal(t, 3, merged)
+
+	journeys, err := store.LoadJourneys()
+	require.NoError(t, err)
+	require.ElementsMatch(
+		t,
+		[]cache.Journey{
+			{
+				Id:            "23->42",
+				Points:        []cache.Point{{X: 2, Y: 2}, {X: 1, Y: 1}},
+				StartId:       23,
+				DestinationId: 42,
+				FullyMapped:   true,
+				DiscoveredAt:  mockNow(),
+				Stats:         cache.Stats{Length: 1.5, PointCount: 2, BoundingBox: cache.BoundingBox{MaxX: 2, MaxY: 2}, Characters: 1},
+			},
+			{
+				Id:            "3->7",
+				Points:        []cache.Point{{X: 7, Y: 7}, {X: 6, Y: 6}},
+				StartId:       3,
+				DestinationId: 7,
+				DiscoveredAt:  mockNow(),
+			},
+			{
+				Id:            "5->9",
+				Points:        []cache.Point{{X: 8, Y: 8}, {X: 9, Y: 9}},
+				StartId:       5,
+				DestinationId: 9,
+				FullyMapped:   true,
+				DiscoveredAt:  mockNow(),
+				FirstSeq:      -1,
+			},
+		},
+		journeys,
+	)
+
+	// nothing left to merge
+	merged, err = store.MergeReverseJourneys()
+	require.NoError(t, err)
+	require.Equal(t, 0, merged)
+}
+
+func TestMergeReverseJourneysError(t *testing.T) {
+	store, err := Open(Config{Driver: "ramsql", DSN: "TestMergeReverseJourneysError"})
+	require.NoError(t, err)
+	store.Close()
+
+	_, err = store.MergeReverseJourneys()
+	require.Error(t, err)
+}
@@ -2,49 +2,120 @@ package store
 
 import (
 	"database/sql"
+	"errors"
+	"fiurgeist/journey/internal/cache"
+	"fiurgeist/journey/internal/export"
 	"fiurgeist/journey/internal/queue"
 	"fmt"
+	_ "github.com/mattn/go-sqlite3"
 	_ "github.com/proullon/ramsql/driver"
 	"log"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const CONSUMER_HEARTBEAT = time.Second
+
+var errInterrupted = errors.New("Store closed while retrying")
+
+type Config struct {
+	Driver string      `yaml:"driver"`
+	DSN    string      `yaml:"dsn"`
+	Retry  RetryConfig `yaml:"retry"`
+	// DeadLetterFile is the JSONL file receiving messages which failed all attempts,
+	// they are only logged if empty
+	DeadLetterFile string `yaml:"deadLetterFile"`
+}
+
+// RetryConfig sets the exponential backoff between attempts to write a message
+type RetryConfig struct {
+	MaxAttempts    int           `yaml:"maxAttempts"`
+	InitialBackoff time.Duration `yaml:"initialBackoff"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff"`
+}
+
 type store struct {
-	db             *sql.DB
-	msgQueue       queue.Queue
-	subroutineQuit chan bool
-	subroutineWG   *sync.WaitGroup
+	db                *sql.DB
+	dialect           dialect
+	retry             RetryConfig
+	deadLetters       *deadLetterFile
+	msgQueue          queue.Queue
+	consuming         bool
+	subroutineQuit    chan bool
+	subroutineWG      *sync.WaitGroup
+	consumerHeartbeat int64
+}
+
+// dialect covers the differences between the supported database drivers
+type dialect struct {
+	// ramsql doesn't support "IF NOT EXISTS" but is always empty on start
+	createTable string
+	// insert skips duplicates where supported, otherwise they fail with ErrUniqueViolation
+	insert   string
+	classify classifier
+	// migrate adds the columns missing in tables of earlier versions, ramsql starts empty
+	migrate bool
+}
+
+var dialects = map[string]dialect{
+	"ramsql":  {createTable: "CREATE TABLE", insert: "INSERT INTO", classify: classifyRamsql},
+	"sqlite3": {createTable: "CREATE TABLE IF NOT EXISTS", insert: "INSERT OR IGNORE INTO", classify: classifySQLite, migrate: true},
 }
 
-func NewStore(msgQueue queue.Queue) (*store, error) {
-	db, err := sql.Open("ramsql", "JourneyDB")
+// Open connects to the database without consuming the queue, e.g. for admin commands
+func Open(config Config) (*store, error) {
+	dialect, ok := dialects[config.Driver]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported store driver %q", config.Driver)
+	}
+	db, err := sql.Open(config.Driver, config.DSN)
 	if err != nil {
 		fmt.Printf("sql.Open : Error : %s\n", err)
 		return nil, err
 	}
 
-	s := newStore(db, msgQueue)
+	s := newStore(db, nil)
+	s.dialect = dialect
+	s.retry = config.Retry
+	if config.DeadLetterFile != "" {
+		s.deadLetters = newDeadLetterFile(config.DeadLetterFile)
+	}
 	if err := s.init(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func NewStore(config Config, msgQueue queue.Queue) (*store, error) {
+	s, err := Open(config)
+	if err != nil {
 		return nil, err
 	}
+	s.msgQueue = msgQueue
 
+	s.beat()
+	s.consuming = true
 	s.subroutineWG.Add(1)
 	go func() {
 		defer s.subroutineWG.Done()
+		heartbeat := time.NewTicker(CONSUMER_HEARTBEAT)
+		defer heartbeat.Stop()
 		for {
 			select {
 			case <-s.subroutineQuit:
 				log.Println("Quit storing.")
 				return
+			case <-heartbeat.C:
+				s.beat()
 			case msg := <-s.msgQueue.GetChannel():
-				switch data := msg.(type) {
-				case queue.NewJourney:
-					s.newJourney(data.StartId, data.DestinationId)
-				case queue.JourneyFullyMapped:
-					s.journeyFullyMapped(data.StartId, data.DestinationId)
-				case queue.NewLocation:
-					s.newLocation(data.StartId, data.DestinationId, data.X, data.Y)
+				if err := s.Write(msg); err == errInterrupted {
+					log.Println("Quit storing.")
+					return
 				}
+				s.beat()
 			}
 		}
 	}()
@@ -54,16 +125,73 @@ func NewStore(msgQueue queue.Queue) (*store, error) {
 func newStore(db *sql.DB, msgQueue queue.Queue) *store {
 	return &store{
 		db:             db,
+		dialect:        dialects["ramsql"],
 		msgQueue:       msgQueue,
 		subroutineQuit: make(chan bool),
 		subroutineWG:   &sync.WaitGroup{},
 	}
 }
 
+// Write stores a message, attempts failing with a transient error are retried with exponential
+// backoff. If writing fails for good the message goes to the dead letters and the error is returned.
+func (s *store) Write(msg interface{}) error {
+	backoff := s.retry.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := s.process(msg)
+		if err == nil {
+			return nil
+		}
+		if attempt >= s.retry.MaxAttempts || !isTransient(err) {
+			s.deadLetter(msg, err, attempt)
+			return err
+		}
+
+		select {
+		case <-s.subroutineQuit:
+			// don't lose the message on shutdown
+			s.deadLetter(msg, err, attempt)
+			return errInterrupted
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > s.retry.MaxBackoff {
+			backoff = s.retry.MaxBackoff
+		}
+	}
+}
+
+func (s *store) process(msg interface{}) error {
+	switch data := msg.(type) {
+	case queue.NewJourney:
+		return s.newJourney(data.World, data.StartId, data.DestinationId, data.DiscoveredAt)
+	case queue.JourneyFullyMapped:
+		return s.journeyFullyMapped(data.World, data.StartId, data.DestinationId)
+	case queue.NewLocation:
+		return s.newLocation(data.World, data.StartId, data.DestinationId, data.X, data.Y, data.Seq)
+	case queue.JourneyStats:
+		return s.journeyStats(data)
+	case queue.JourneyPath:
+		return s.journeyPath(data)
+	}
+	return fmt.Errorf("Unknown message type %T", msg)
+}
+
+func (s *store) deadLetter(msg interface{}, err error, attempts int) {
+	letter, encodeErr := NewDeadLetter(msg, err, attempts)
+	if encodeErr != nil || s.deadLetters == nil {
+		log.Printf("Lost message after %d attempts: %+v; %s\n", attempts, msg, err)
+		return
+	}
+	if writeErr := s.deadLetters.Write(letter); writeErr != nil {
+		log.Printf("Failed to write dead letter: %s; lost message: %+v\n", writeErr, msg)
+	}
+}
+
 func (s *store) init() error {
 	batch := []string{
-		`CREATE TABLE journey (id TEXT UNIQUE NOT NULL, start_id INT , destination_id INT, fully_mapped BOOLEAN);`,
-		`CREATE TABLE location (journey_id INT, x INT, y INT, ramsql_hack_unique_composite_key TEXT UNIQUE NOT NULL);`,
+		s.dialect.createTable + ` journey (id TEXT UNIQUE NOT NULL, start_id INT , destination_id INT, fully_mapped BOOLEAN, discovered_at INT);`,
+		s.dialect.createTable + ` location (journey_id INT, x INT, y INT, ramsql_hack_unique_composite_key TEXT UNIQUE NOT NULL, seq INT);`,
+		s.dialect.createTable + ` journey_stats (journey_id TEXT UNIQUE NOT NULL, length FLOAT, point_count INT, min_x INT, min_y INT, max_x INT, max_y INT, characters INT);`,
 	}
 
 	for _, b := range batch {
@@ -74,21 +202,231 @@ func (s *store) init() error {
 		}
 	}
 
+	if s.dialect.migrate {
+		// journeys stored before discovered_at was added are loaded as discovered on start
+		return s.addColumn("journey", "discovered_at", "INT")
+	}
 	return nil
 }
 
+// addColumn adds a column to a table created by an earlier version
+func (s *store) addColumn(table, column, definition string) error {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info($1) WHERE name = $2;`, table, column).Scan(&count)
+	if err == nil && count == 0 {
+		_, err = s.db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s;`, table, column, definition))
+	}
+	if err != nil {
+		log.Printf("Failed to add column %s.%s: %s\n", table, column, err)
+	}
+	return err
+}
+
 func (s *store) Close() {
-	s.subroutineQuit <- true
-	s.subroutineWG.Wait()
+	if s.consuming {
+		s.subroutineQuit <- true
+		s.subroutineWG.Wait()
+	}
 	s.db.Close()
 }
 
-func (s *store) newJourney(startId, destinationId uint16) error {
-	query := `INSERT INTO journey (id, start_id, destination_id, fully_mapped) VALUES ($1, $2, $3, 'FALSE');`
+func (s *store) Ping() error {
+	return s.db.Ping()
+}
+
+// CheckConsumer fails if the goroutine reading the queue didn't report back within maxIdle,
+// either because it died or because it is stuck on a message
+func (s *store) CheckConsumer(maxIdle time.Duration) error {
+	last := time.Unix(0, atomic.LoadInt64(&s.consumerHeartbeat))
+	if idle := time.Since(last); idle > maxIdle {
+		return fmt.Errorf("Store consumer unresponsive for %s", idle.Round(time.Second))
+	}
+	return nil
+}
+
+func (s *store) beat() {
+	atomic.StoreInt64(&s.consumerHeartbeat, time.Now().UnixNano())
+}
+
+// JourneyId is the id of a journey in the tables, prefixed by the world unless it is the
+// default world
+func JourneyId(world string, startId, destinationId uint16) string {
+	if world == "" {
+		return fmt.Sprintf("%d-%d", startId, destinationId)
+	}
+	return fmt.Sprintf("%s:%d-%d", world, startId, destinationId)
+}
+
+// journeyWorld returns the world of a journey id of the tables
+func journeyWorld(id string) string {
+	if i := strings.LastIndex(id, ":"); i >= 0 {
+		return id[:i]
+	}
+	return ""
+}
+
+// LoadJourneys reads all journeys of all worlds with their points in order, used to warm up
+// the caches
+func (s *store) LoadJourneys() ([]cache.Journey, error) {
+	rows, err := s.db.Query(`SELECT id, start_id, destination_id, fully_mapped, discovered_at FROM journey;`)
+	if err != nil {
+		log.Printf("Failed to load journeys: %s\n", err)
+		return nil, err
+	}
+
+	var journeys []cache.Journey
+	var ids []string
+	for rows.Next() {
+		var id string
+		var journey cache.Journey
+		var discoveredAt sql.NullInt64
+		if err := rows.Scan(&id, &journey.StartId, &journey.DestinationId, &journey.FullyMapped, &discoveredAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if discoveredAt.Valid && discoveredAt.Int64 != 0 {
+			journey.DiscoveredAt = time.Unix(0, discoveredAt.Int64).UTC()
+		}
+		journey.Id = cache.JourneyId(journey.StartId, journey.DestinationId)
+		journey.World = journeyWorld(id)
+		journeys = append(journeys, journey)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stats, err := s.loadStats()
+	if err != nil {
+		log.Printf("Failed to load journey stats: %s\n", err)
+		return nil, err
+	}
+	for i := range journeys {
+		points, firstSeq, err := s.loadPoints(ids[i])
+		if err != nil {
+			log.Printf("Failed to load locations: %s\n", err)
+			return nil, err
+		}
+		journeys[i].Points = points
+		journeys[i].FirstSeq = firstSeq
+		journeys[i].Stats = stats[ids[i]]
+	}
+	return journeys, nil
+}
+
+// loadStats returns the stats of the fully mapped journeys by journey_id
+func (s *store) loadStats() (map[string]cache.Stats, error) {
+	rows, err := s.db.Query(
+		`SELECT journey_id, length, point_count, min_x, min_y, max_x, max_y, characters FROM journey_stats;`,
+	)
+	if err != nil {
+		return nil, classify(s.dialect.classify, err)
+	}
+	defer rows.Close()
+
+	stats := make(map[string]cache.Stats)
+	for rows.Next() {
+		var journeyId string
+		var row cache.Stats
+		box := &row.BoundingBox
+		err := rows.Scan(&journeyId, &row.Length, &row.PointCount, &box.MinX, &box.MinY, &box.MaxX, &box.MaxY, &row.Characters)
+		if err != nil {
+			return nil, err
+		}
+		stats[journeyId] = row
+	}
+	return stats, rows.Err()
+}
+
+// loadPoints returns the points of a journey and the seq of the first one, which is negative
+// if points were prepended in undirected mode
+func (s *store) loadPoints(journeyId string) ([]cache.Point, int, error) {
+	var points []cache.Point
+	firstSeq := 0
+	err := s.eachJourneyLocation(journeyId, func(row export.LocationRow) error {
+		if len(points) == 0 {
+			firstSeq = row.Seq
+		}
+		points = append(points, cache.Point{X: row.X, Y: row.Y})
+		return nil
+	})
+	return points, firstSeq, err
+}
+
+// EachJourney passes the rows of the journey table one by one
+func (s *store) EachJourney(fn func(export.JourneyRow) error) error {
+	rows, err := s.db.Query(`SELECT id, start_id, destination_id, fully_mapped FROM journey;`)
+	if err != nil {
+		return classify(s.dialect.classify, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row export.JourneyRow
+		if err := rows.Scan(&row.Id, &row.StartId, &row.DestinationId, &row.FullyMapped); err != nil {
+			return err
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// EachLocation passes the rows of the location table one by one, grouped by journey and
+// ordered by their position in the journey
+func (s *store) EachLocation(fn func(export.LocationRow) error) error {
+	// ramsql can't order by several columns, only the journey ids are held in memory
+	var journeyIds []string
+	err := s.EachJourney(func(row export.JourneyRow) error {
+		journeyIds = append(journeyIds, row.Id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, journeyId := range journeyIds {
+		if err := s.eachJourneyLocation(journeyId, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *store) eachJourneyLocation(journeyId string, fn func(export.LocationRow) error) error {
+	// ramsql only sorts with an explicit direction
+	query := `SELECT x, y, seq FROM location WHERE journey_id = $1 ORDER BY seq ASC;`
+	rows, err := s.db.Query(query, journeyId)
+	if err != nil {
+		return classify(s.dialect.classify, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		row := export.LocationRow{JourneyId: journeyId}
+		if err := rows.Scan(&row.X, &row.Y, &row.Seq); err != nil {
+			return err
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// newJourney inserts a journey, it is discovered now if the time isn't known
+func (s *store) newJourney(world string, startId, destinationId uint16, discoveredAt time.Time) error {
+	if discoveredAt.IsZero() {
+		discoveredAt = time.Now()
+	}
+	query := s.dialect.insert + ` journey (id, start_id, destination_id, fully_mapped, discovered_at) VALUES ($1, $2, $3, 'FALSE', $4);`
 	_, err := s.db.Exec(
-		query, fmt.Sprintf("%d-%d", startId, destinationId), startId, destinationId,
+		query, JourneyId(world, startId, destinationId), startId, destinationId, discoveredAt.UnixNano(),
 	)
-	if err != nil && err.Error() != "UNIQUE constraint violation" {
+	err = classify(s.dialect.classify, err)
+	if err != nil && !errors.Is(err, ErrUniqueViolation) {
 		log.Printf(
 			"Failed to insert new journey: %s; (startId: %d, destinationId: %d)\n",
 			err,
@@ -101,9 +439,10 @@ func (s *store) newJourney(startId, destinationId uint16) error {
 	return nil
 }
 
-func (s *store) journeyFullyMapped(startId, destinationId uint16) error {
-	query := `UPDATE journey SET fully_mapped = 'TRUE' WHERE start_id = $1 AND destination_id = $2;`
-	_, err := s.db.Exec(query, startId, destinationId)
+func (s *store) journeyFullyMapped(world string, startId, destinationId uint16) error {
+	query := `UPDATE journey SET fully_mapped = 'TRUE' WHERE id = $1;`
+	_, err := s.db.Exec(query, JourneyId(world, startId, destinationId))
+	err = classify(s.dialect.classify, err)
 	if err != nil {
 		log.Printf(
 			"Failed to update `fully_mapped` of journey : %s; (startId: %d, destinationId: %d)\n",
@@ -116,12 +455,13 @@ func (s *store) journeyFullyMapped(startId, destinationId uint16) error {
 	return nil
 }
 
-func (s *store) newLocation(startId, destinationId, x, y uint16) error {
-	query := `INSERT INTO location (journey_id, x, y, ramsql_hack_unique_composite_key)
-                VALUES ($1, $2, $3, $4);`
-	journey_id := fmt.Sprintf("%d-%d", startId, destinationId)
-	_, err := s.db.Exec(query, journey_id, x, y, fmt.Sprintf("%s-%d-%d", journey_id, x, y))
-	if err != nil && err.Error() != "UNIQUE constraint violation" {
+func (s *store) newLocation(world string, startId, destinationId, x, y uint16, seq int) error {
+	query := s.dialect.insert + ` location (journey_id, x, y, ramsql_hack_unique_composite_key, seq)
+                VALUES ($1, $2, $3, $4, $5);`
+	journey_id := JourneyId(world, startId, destinationId)
+	_, err := s.db.Exec(query, journey_id, x, y, fmt.Sprintf("%s-%d-%d", journey_id, x, y), seq)
+	err = classify(s.dialect.classify, err)
+	if err != nil && !errors.Is(err, ErrUniqueViolation) {
 		log.Printf(
 			"Failed to insert new location: %s; (startId: %d, destinationId: %d, x: %d, y: %d)\n",
 			err,
@@ -134,3 +474,53 @@ func (s *store) newLocation(startId, destinationId, x, y uint16) error {
 	}
 	return nil
 }
+
+// journeyStats replaces the stats of a journey, ramsql can't upsert so it updates first
+func (s *store) journeyStats(stats queue.JourneyStats) error {
+	journeyId := JourneyId(stats.World, stats.StartId, stats.DestinationId)
+	res, err := s.db.Exec(
+		`UPDATE journey_stats SET length = $1, point_count = $2, min_x = $3, min_y = $4, max_x = $5, max_y = $6, characters = $7
+                WHERE journey_id = $8;`,
+		stats.Length, stats.PointCount, stats.MinX, stats.MinY, stats.MaxX, stats.MaxY, stats.Characters, journeyId,
+	)
+	if err == nil {
+		var updated int64
+		if updated, err = res.RowsAffected(); err == nil && updated == 0 {
+			_, err = s.db.Exec(
+				`INSERT INTO journey_stats (journey_id, length, point_count, min_x, min_y, max_x, max_y, characters)
+                        VALUES ($1, $2, $3, $4, $5, $6, $7, $8);`,
+				journeyId, stats.Length, stats.PointCount, stats.MinX, stats.MinY, stats.MaxX, stats.MaxY, stats.Characters,
+			)
+		}
+	}
+	err = classify(s.dialect.classify, err)
+	if err != nil {
+		log.Printf(
+			"Failed to store journey stats: %s; (startId: %d, destinationId: %d)\n",
+			err,
+			stats.StartId,
+			stats.DestinationId,
+		)
+		return err
+	}
+	return nil
+}
+
+// journeyPath replaces the locations of a journey, it can be retried as a whole
+func (s *store) journeyPath(path queue.JourneyPath) error {
+	err := s.deleteLocations(JourneyId(path.World, path.StartId, path.DestinationId))
+	for seq := 0; err == nil && seq < len(path.Points); seq++ {
+		point := path.Points[seq]
+		err = s.newLocation(path.World, path.StartId, path.DestinationId, point.X, point.Y, seq)
+	}
+	if err != nil {
+		log.Printf(
+			"Failed to replace the path of journey: %s; (startId: %d, destinationId: %d)\n",
+			err,
+			path.StartId,
+			path.DestinationId,
+		)
+		return err
+	}
+	return nil
+}
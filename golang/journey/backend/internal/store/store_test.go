@@ -2,9 +2,16 @@ package store
 
 import (
 	"database/sql"
+	"errors"
+	"fiurgeist/journey/internal/cache"
+	"fiurgeist/journey/internal/export"
 	"fiurgeist/journey/internal/queue"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/undefinedlabs/go-mpatch"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -13,7 +20,7 @@ import (
 func TestNewStore(t *testing.T) { // TODO: split this test
 	quitSubroutine := false
 	mockQueue := &queue.MockQueue{}
-	store, err := NewStore(mockQueue)
+	store, err := NewStore(Config{Driver: "ramsql", DSN: "TestNewStore"}, mockQueue)
 	require.NoError(t, err)
 	defer func() {
 		if quitSubroutine {
@@ -52,7 +59,7 @@ func TestNewStore(t *testing.T) { // TODO: split this test
 	assertLocationRows(
 		t,
 		rows,
-		[]locationRow{{journeyId: "23-42", x: 1, y: 2, hackyUnique: "23-42-1-2"}},
+		[]locationRow{{journeyId: "23-42", x: 1, y: 2, hackyUnique: "23-42-1-2", seq: 0}},
 	)
 
 	channel <- queue.JourneyFullyMapped{StartId: 23, DestinationId: 42}
@@ -82,6 +89,88 @@ func TestNewStore(t *testing.T) { // TODO: split this test
 	assertJourneyRows(t, rows, expectedJourneys)
 }
 
+func TestNewStoreUnsupportedDriver(t *testing.T) {
+	store, err := NewStore(Config{Driver: "foo", DSN: "TestNewStoreUnsupportedDriver"}, nil)
+	require.Error(t, err)
+	require.Nil(t, store)
+	require.Equal(t, "Unsupported store driver \"foo\"", err.Error())
+}
+
+func TestNewStoreSQLite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dsn := filepath.Join(dir, "journey.db")
+
+	mockQueue := &queue.MockQueue{}
+	channel := make(chan interface{})
+	mockQueue.On("GetChannel").Return(channel)
+
+	store, err := NewStore(Config{Driver: "sqlite3", DSN: dsn}, mockQueue)
+	require.NoError(t, err)
+	err = store.newJourney("", 23, 42, time.Time{})
+	require.NoError(t, err)
+	// duplicates are ignored
+	err = store.newJourney("", 23, 42, time.Time{})
+	require.NoError(t, err)
+	err = store.newLocation("", 23, 42, 1, 2, 0)
+	require.NoError(t, err)
+	err = store.newLocation("", 23, 42, 1, 2, 0)
+	require.NoError(t, err)
+	err = store.journeyStats(queue.JourneyStats{StartId: 23, DestinationId: 42, Length: 0.5, Characters: 1})
+	require.NoError(t, err)
+	err = store.journeyStats(queue.JourneyStats{StartId: 23, DestinationId: 42, Length: 1.5, Characters: 2})
+	require.NoError(t, err)
+	store.Close()
+
+	// existing tables are kept on restart
+	store, err = NewStore(Config{Driver: "sqlite3", DSN: dsn}, mockQueue)
+	require.NoError(t, err)
+	defer store.Close()
+	rows, err := store.db.Query("SELECT * FROM journey WHERE 1;")
+	require.NoError(t, err)
+	assertJourneyRows(t, rows, []journeyRow{{id: "23-42", start: 23, end: 42, fullyMapped: false}})
+	journeys, err := store.LoadJourneys()
+	require.NoError(t, err)
+	require.Equal(t, cache.Stats{Length: 1.5, Characters: 2}, journeys[0].Stats)
+}
+
+func TestNewStoreSQLiteMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dsn := filepath.Join(dir, "journey.db")
+
+	// a journey of a version without discovered_at
+	db, err := sql.Open("sqlite3", dsn)
+	require.NoError(t, err)
+	_, err = db.Exec(`CREATE TABLE journey (id TEXT UNIQUE NOT NULL, start_id INT , destination_id INT, fully_mapped BOOLEAN);`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO journey (id, start_id, destination_id, fully_mapped) VALUES ('23-42', 23, 42, 'FALSE');`)
+	require.NoError(t, err)
+	db.Close()
+
+	store, err := Open(Config{Driver: "sqlite3", DSN: dsn})
+	require.NoError(t, err)
+	require.NoError(t, store.newJourney("", 42, 23, mockNow()))
+	store.Close()
+
+	// the column is added once
+	store, err = Open(Config{Driver: "sqlite3", DSN: dsn})
+	require.NoError(t, err)
+	defer store.Close()
+	journeys, err := store.LoadJourneys()
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		[]cache.Journey{
+			{Id: "23->42", StartId: 23, DestinationId: 42},
+			{Id: "42->23", StartId: 42, DestinationId: 23, DiscoveredAt: mockNow()},
+		},
+		journeys,
+	)
+}
+
 func TestClose(t *testing.T) {
 	dbClosed := uint32(0)
 	db, err := sql.Open("ramsql", "TestClose")
@@ -97,6 +186,7 @@ func TestClose(t *testing.T) {
 	require.NoError(t, err)
 
 	// simulate subroutine
+	store.consuming = true
 	store.subroutineWG.Add(1)
 	// assert DB open
 	require.Equal(t, nil, store.db.Ping())
@@ -125,6 +215,116 @@ func TestClose(t *testing.T) {
 	require.Equal(t, "sql: database is closed", err.Error())
 }
 
+func TestOpen(t *testing.T) {
+	store, err := Open(Config{Driver: "ramsql", DSN: "TestOpen"})
+	require.NoError(t, err)
+	require.False(t, store.consuming)
+	require.NoError(t, store.Ping())
+
+	// nothing to quit
+	store.Close()
+	require.Error(t, store.Ping())
+}
+
+func TestWrite(t *testing.T) {
+	db, err := sql.Open("ramsql", "TestWrite")
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := newStore(db, nil)
+	err = store.init()
+	require.NoError(t, err)
+
+	require.NoError(t, store.Write(queue.NewJourney{StartId: 23, DestinationId: 42}))
+	require.NoError(t, store.Write(queue.NewLocation{StartId: 23, DestinationId: 42, X: 1, Y: 2}))
+	require.NoError(t, store.Write(queue.JourneyFullyMapped{StartId: 23, DestinationId: 42}))
+
+	rows, err := store.db.Query("SELECT * FROM journey WHERE 1;")
+	require.NoError(t, err)
+	assertJourneyRows(t, rows, []journeyRow{{id: "23-42", start: 23, end: 42, fullyMapped: true}})
+	rows, err = store.db.Query("SELECT * FROM location WHERE 1;")
+	require.NoError(t, err)
+	assertLocationRows(t, rows, []locationRow{{journeyId: "23-42", x: 1, y: 2, hackyUnique: "23-42-1-2"}})
+}
+
+func TestWriteDeadLetter(t *testing.T) {
+	patchNow, err := mpatch.PatchMethod(time.Now, mockNow)
+	require.NoError(t, err)
+	defer patchNow.Unpatch()
+
+	dir, err := ioutil.TempDir("", "store")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dead-letters.jsonl")
+
+	db, err := sql.Open("ramsql", "TestWriteDeadLetter")
+	require.NoError(t, err)
+
+	// missing tables aren't retried
+	store := newStore(db, nil)
+	store.retry = RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	store.deadLetters = newDeadLetterFile(path)
+
+	err = store.Write(queue.NewJourney{StartId: 23, DestinationId: 42, DiscoveredAt: mockNow()})
+	require.Error(t, err)
+	require.True(t, errors.Is(err, ErrSchema))
+	require.Equal(t, "table journey does not exists", err.Error())
+
+	// a lost connection fails every attempt
+	db.Close()
+	err = store.Write(queue.NewJourney{StartId: 23, DestinationId: 42, DiscoveredAt: mockNow()})
+	require.Error(t, err)
+	require.True(t, errors.Is(err, ErrConnectionLost))
+
+	letters, err := ReadDeadLetters(path)
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		[]DeadLetter{
+			{
+				Type:     queue.TYPE_NEW_JOURNEY,
+				Message:  []byte(`{"StartId":23,"DestinationId":42,"DiscoveredAt":"2021-01-01T00:00:00Z"}`),
+				Error:    "table journey does not exists",
+				Attempts: 1,
+				FailedAt: mockNow(),
+			},
+			{
+				Type:     queue.TYPE_NEW_JOURNEY,
+				Message:  []byte(`{"StartId":23,"DestinationId":42,"DiscoveredAt":"2021-01-01T00:00:00Z"}`),
+				Error:    "sql: database is closed",
+				Attempts: 3,
+				FailedAt: mockNow(),
+			},
+		},
+		letters,
+	)
+}
+
+func TestWriteInterrupted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dead-letters.jsonl")
+
+	db, err := sql.Open("ramsql", "TestWriteInterrupted")
+	require.NoError(t, err)
+	db.Close()
+
+	store := newStore(db, nil)
+	store.retry = RetryConfig{MaxAttempts: 3, InitialBackoff: time.Hour, MaxBackoff: time.Hour}
+	store.deadLetters = newDeadLetterFile(path)
+
+	go func() { store.subroutineQuit <- true }()
+	err = store.Write(queue.NewJourney{StartId: 23, DestinationId: 42})
+	require.Equal(t, errInterrupted, err)
+
+	// the message is kept for later
+	letters, err := ReadDeadLetters(path)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	require.Equal(t, 1, letters[0].Attempts)
+}
+
 func TestNewJourney(t *testing.T) {
 	db, err := sql.Open("ramsql", "TestNewJourney")
 	require.NoError(t, err)
@@ -134,7 +334,7 @@ func TestNewJourney(t *testing.T) {
 	err = store.init()
 	require.NoError(t, err)
 
-	err = store.newJourney(23, 42)
+	err = store.newJourney("", 23, 42, time.Time{})
 	require.NoError(t, err)
 
 	rows, err := store.db.Query("SELECT * FROM journey WHERE 1;")
@@ -152,7 +352,7 @@ func TestNewJourneyHandleDuplicate(t *testing.T) {
 	err = store.init()
 	require.NoError(t, err)
 
-	err = store.newJourney(23, 42)
+	err = store.newJourney("", 23, 42, time.Time{})
 	require.NoError(t, err)
 
 	rows, err := store.db.Query("SELECT * FROM journey WHERE 1;")
@@ -161,7 +361,7 @@ func TestNewJourneyHandleDuplicate(t *testing.T) {
 	assertJourneyRows(t, rows, journeyData)
 
 	// no error but same data
-	err = store.newJourney(23, 42)
+	err = store.newJourney("", 23, 42, time.Time{})
 	require.NoError(t, err)
 
 	rows, err = store.db.Query("SELECT * FROM journey WHERE 1;")
@@ -176,7 +376,7 @@ func TestNewJourneyErrorInsertJourney(t *testing.T) {
 
 	store := newStore(db, nil)
 
-	err = store.newJourney(23, 42)
+	err = store.newJourney("", 23, 42, time.Time{})
 	require.Error(t, err)
 	require.Equal(t, "table journey does not exists", err.Error())
 }
@@ -191,13 +391,13 @@ func TestJourneyFullyMapped(t *testing.T) {
 	require.NoError(t, err)
 
 	// add some journeys
-	err = store.newJourney(23, 42)
+	err = store.newJourney("", 23, 42, time.Time{})
 	require.NoError(t, err)
-	err = store.newJourney(42, 23)
+	err = store.newJourney("", 42, 23, time.Time{})
 	require.NoError(t, err)
 
 	// update one journey
-	err = store.journeyFullyMapped(23, 42)
+	err = store.journeyFullyMapped("", 23, 42)
 	require.NoError(t, err)
 
 	rows, err := store.db.Query("SELECT * FROM journey WHERE 1;")
@@ -216,7 +416,7 @@ func TestTestJourneyFullyMappedError(t *testing.T) {
 
 	store := newStore(db, nil)
 
-	err = store.journeyFullyMapped(23, 42)
+	err = store.journeyFullyMapped("", 23, 42)
 	require.Error(t, err)
 	require.Equal(t, "Table journey does not exists", err.Error())
 }
@@ -230,18 +430,18 @@ func TestNewLocation(t *testing.T) {
 	err = store.init()
 	require.NoError(t, err)
 
-	err = store.newLocation(23, 42, 1, 2)
+	err = store.newLocation("", 23, 42, 1, 2, 0)
 	require.NoError(t, err)
 
 	// several trade journeys can share the same coordinate
-	err = store.newLocation(42, 23, 1, 2)
+	err = store.newLocation("", 42, 23, 1, 2, 0)
 	require.NoError(t, err)
 
 	rows, err := store.db.Query("SELECT * FROM location WHERE 1;")
 	require.NoError(t, err)
 	locationData := []locationRow{
-		{journeyId: "23-42", x: 1, y: 2, hackyUnique: "23-42-1-2"},
-		{journeyId: "42-23", x: 1, y: 2, hackyUnique: "42-23-1-2"},
+		{journeyId: "23-42", x: 1, y: 2, hackyUnique: "23-42-1-2", seq: 0},
+		{journeyId: "42-23", x: 1, y: 2, hackyUnique: "42-23-1-2", seq: 0},
 	}
 	assertLocationRows(t, rows, locationData)
 }
@@ -255,16 +455,16 @@ func TestNewLocationHandleDuplicate(t *testing.T) {
 	err = store.init()
 	require.NoError(t, err)
 
-	err = store.newLocation(23, 42, 1, 2)
+	err = store.newLocation("", 23, 42, 1, 2, 0)
 	require.NoError(t, err)
 
 	rows, err := store.db.Query("SELECT * FROM location WHERE 1;")
 	require.NoError(t, err)
-	locationData := []locationRow{{journeyId: "23-42", x: 1, y: 2, hackyUnique: "23-42-1-2"}}
+	locationData := []locationRow{{journeyId: "23-42", x: 1, y: 2, hackyUnique: "23-42-1-2", seq: 0}}
 	assertLocationRows(t, rows, locationData)
 
 	// no error but same data
-	err = store.newLocation(23, 42, 1, 2)
+	err = store.newLocation("", 23, 42, 1, 2, 0)
 	require.NoError(t, err)
 
 	rows, err = store.db.Query("SELECT * FROM location WHERE 1;")
@@ -279,11 +479,258 @@ func TestNewLocationError(t *testing.T) {
 
 	store := newStore(db, nil)
 
-	err = store.newLocation(23, 42, 1, 2)
+	err = store.newLocation("", 23, 42, 1, 2, 0)
 	require.Error(t, err)
 	require.Equal(t, "table location does not exists", err.Error())
 }
 
+func TestCheckConsumer(t *testing.T) {
+	store := newStore(nil, nil)
+
+	// never started
+	err := store.CheckConsumer(time.Second)
+	require.Error(t, err)
+
+	store.beat()
+	err = store.CheckConsumer(time.Second)
+	require.NoError(t, err)
+
+	atomic.StoreInt64(&store.consumerHeartbeat, time.Now().Add(-2*time.Second).UnixNano())
+	err = store.CheckConsumer(time.Second)
+	require.Error(t, err)
+	require.Equal(t, "Store consumer unresponsive for 2s", err.Error())
+}
+
+func TestLoadJourneys(t *testing.T) {
+	db, err := sql.Open("ramsql", "TestLoadJourneys")
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := newStore(db, nil)
+	err = store.init()
+	require.NoError(t, err)
+
+	// empty store
+	journeys, err := store.LoadJourneys()
+	require.NoError(t, err)
+	require.Empty(t, journeys)
+
+	require.NoError(t, store.newJourney("", 23, 42, mockNow()))
+	require.NoError(t, store.newJourney("", 42, 23, mockNow()))
+	require.NoError(t, store.journeyFullyMapped("", 23, 42))
+	require.NoError(t, store.newLocation("", 23, 42, 2, 2, 1))
+	require.NoError(t, store.newLocation("", 23, 42, 1, 2, 0))
+	require.NoError(t, store.newLocation("", 42, 23, 11, 12, 0))
+
+	// points are ordered by their position in the journey
+	journeys, err = store.LoadJourneys()
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		[]cache.Journey{
+			{
+				Id:            "23->42",
+				Points:        []cache.Point{{X: 1, Y: 2}, {X: 2, Y: 2}},
+				StartId:       23,
+				DestinationId: 42,
+				FullyMapped:   true,
+				DiscoveredAt:  mockNow(),
+			},
+			{Id: "42->23", Points: []cache.Point{{X: 11, Y: 12}}, StartId: 42, DestinationId: 23, DiscoveredAt: mockNow()},
+		},
+		journeys,
+	)
+}
+
+func TestLoadJourneysOfWorlds(t *testing.T) {
+	db, err := sql.Open("ramsql", "TestLoadJourneysOfWorlds")
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := newStore(db, nil)
+	err = store.init()
+	require.NoError(t, err)
+
+	// the same journey in two worlds
+	msgs := []interface{}{
+		queue.NewJourney{StartId: 23, DestinationId: 42, DiscoveredAt: mockNow()},
+		queue.NewJourney{World: "moon", StartId: 23, DestinationId: 42, DiscoveredAt: mockNow().Add(time.Hour)},
+		queue.NewLocation{World: "moon", StartId: 23, DestinationId: 42, X: 1, Y: 2},
+		queue.JourneyFullyMapped{World: "moon", StartId: 23, DestinationId: 42},
+		queue.JourneyStats{World: "moon", StartId: 23, DestinationId: 42, Characters: 1},
+	}
+	for _, msg := range msgs {
+		require.NoError(t, store.process(msg))
+	}
+
+	rows, err := store.db.Query("SELECT * FROM journey WHERE 1;")
+	require.NoError(t, err)
+	assertJourneyRows(
+		t,
+		rows,
+		[]journeyRow{
+			{id: "23-42", start: 23, end: 42, fullyMapped: false},
+			{id: "moon:23-42", start: 23, end: 42, fullyMapped: true},
+		},
+	)
+
+	journeys, err := store.LoadJourneys()
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		[]cache.Journey{
+			{Id: "23->42", StartId: 23, DestinationId: 42, DiscoveredAt: mockNow()},
+			{
+				Id:            "23->42",
+				Points:        []cache.Point{{X: 1, Y: 2}},
+				StartId:       23,
+				DestinationId: 42,
+				FullyMapped:   true,
+				DiscoveredAt:  mockNow().Add(time.Hour),
+				Stats:         cache.Stats{Characters: 1},
+				World:         "moon",
+			},
+		},
+		journeys,
+	)
+}
+
+func TestJourneyPath(t *testing.T) {
+	db, err := sql.Open("ramsql", "TestJourneyPath")
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := newStore(db, nil)
+	err = store.init()
+	require.NoError(t, err)
+
+	require.NoError(t, store.newJourney("moon", 23, 42, mockNow()))
+	require.NoError(t, store.newLocation("moon", 23, 42, 1, 2, -1))
+	require.NoError(t, store.newLocation("moon", 23, 42, 5, 5, 0))
+	require.NoError(t, store.newLocation("moon", 23, 42, 3, 4, 1))
+	path := queue.JourneyPath{World: "moon", StartId: 23, DestinationId: 42, Points: []queue.Location{{X: 1, Y: 2}, {X: 3, Y: 4}}}
+	require.NoError(t, store.process(path))
+	// replaced again, e.g. from the dead letters
+	require.NoError(t, store.process(path))
+
+	points, firstSeq, err := store.loadPoints("moon:23-42")
+	require.NoError(t, err)
+	require.Equal(t, []cache.Point{{X: 1, Y: 2}, {X: 3, Y: 4}}, points)
+	require.Equal(t, 0, firstSeq)
+}
+
+func TestJourneyStats(t *testing.T) {
+	db, err := sql.Open("ramsql", "TestJourneyStats")
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := newStore(db, nil)
+	err = store.init()
+	require.NoError(t, err)
+
+	require.NoError(t, store.newJourney("", 23, 42, mockNow()))
+	require.NoError(t, store.newLocation("", 23, 42, 1, 2, 0))
+	stats := queue.JourneyStats{StartId: 23, DestinationId: 42, Length: 1.5, PointCount: 1, MinX: 1, MinY: 2, MaxX: 1, MaxY: 2, Characters: 1}
+	require.NoError(t, store.Write(stats))
+
+	// written again, e.g. from the dead letters
+	stats.Characters = 2
+	require.NoError(t, store.Write(stats))
+
+	journeys, err := store.LoadJourneys()
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		[]cache.Journey{{
+			Id:            "23->42",
+			Points:        []cache.Point{{X: 1, Y: 2}},
+			StartId:       23,
+			DestinationId: 42,
+			DiscoveredAt:  mockNow(),
+			Stats: cache.Stats{
+				Length:      1.5,
+				PointCount:  1,
+				BoundingBox: cache.BoundingBox{MinX: 1, MinY: 2, MaxX: 1, MaxY: 2},
+				Characters:  2,
+			},
+		}},
+		journeys,
+	)
+}
+
+func TestLoadJourneysError(t *testing.T) {
+	db, err := sql.Open("ramsql", "TestLoadJourneysError")
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := newStore(db, nil)
+
+	_, err = store.LoadJourneys()
+	require.Error(t, err)
+}
+
+func mockNow() time.Time {
+	return time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func TestEachJourneyAndLocation(t *testing.T) {
+	db, err := sql.Open("ramsql", "TestEachJourneyAndLocation")
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := newStore(db, nil)
+	err = store.init()
+	require.NoError(t, err)
+
+	require.NoError(t, store.newJourney("", 23, 42, mockNow()))
+	require.NoError(t, store.newJourney("", 42, 23, mockNow()))
+	require.NoError(t, store.journeyFullyMapped("", 23, 42))
+	require.NoError(t, store.newLocation("", 42, 23, 11, 12, 0))
+	require.NoError(t, store.newLocation("", 23, 42, 2, 2, 1))
+	require.NoError(t, store.newLocation("", 23, 42, 1, 2, 0))
+
+	var journeys []export.JourneyRow
+	err = store.EachJourney(func(row export.JourneyRow) error {
+		journeys = append(journeys, row)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		[]export.JourneyRow{
+			{Id: "23-42", StartId: 23, DestinationId: 42, FullyMapped: true},
+			{Id: "42-23", StartId: 42, DestinationId: 23},
+		},
+		journeys,
+	)
+
+	// grouped by journey and in order of the journey
+	var locations []export.LocationRow
+	err = store.EachLocation(func(row export.LocationRow) error {
+		locations = append(locations, row)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		[]export.LocationRow{
+			{JourneyId: "23-42", Seq: 0, X: 1, Y: 2},
+			{JourneyId: "23-42", Seq: 1, X: 2, Y: 2},
+			{JourneyId: "42-23", Seq: 0, X: 11, Y: 12},
+		},
+		locations,
+	)
+
+	// errors of the callback stop the iteration
+	calls := 0
+	err = store.EachLocation(func(row export.LocationRow) error {
+		calls++
+		return errors.New("broken")
+	})
+	require.Error(t, err)
+	require.Equal(t, 1, calls)
+}
+
 type journeyRow struct {
 	id          string
 	start       uint16
@@ -296,6 +743,7 @@ type locationRow struct {
 	x           uint16
 	y           uint16
 	hackyUnique string
+	seq         int
 }
 
 func assertJourneyRows(t *testing.T, rows *sql.Rows, expected []journeyRow) {
@@ -304,8 +752,10 @@ func assertJourneyRows(t *testing.T, rows *sql.Rows, expected []journeyRow) {
 		var gotId string
 		var gotStart, gotDest uint16
 		var gotFullyMapped bool
-		err := rows.Scan(&gotId, &gotStart, &gotDest, &gotFullyMapped)
+		var gotDiscoveredAt sql.NullInt64
+		err := rows.Scan(&gotId, &gotStart, &gotDest, &gotFullyMapped, &gotDiscoveredAt)
 		require.NoError(t, err)
+		require.NotZero(t, gotDiscoveredAt.Int64)
 		require.LessOrEqual(t, nb, len(expected))
 		require.Equal(t, expected[nb].id, gotId)
 		require.Equal(t, expected[nb].start, gotStart)
@@ -321,13 +771,15 @@ func assertLocationRows(t *testing.T, rows *sql.Rows, expected []locationRow) {
 	for rows.Next() {
 		var gotJourneyId, gotHackyUnique string
 		var gotX, gotY uint16
-		err := rows.Scan(&gotJourneyId, &gotX, &gotY, &gotHackyUnique)
+		var gotSeq int
+		err := rows.Scan(&gotJourneyId, &gotX, &gotY, &gotHackyUnique, &gotSeq)
 		require.NoError(t, err)
 		require.LessOrEqual(t, nb, len(expected))
 		require.Equal(t, expected[nb].journeyId, gotJourneyId)
 		require.Equal(t, expected[nb].x, gotX)
 		require.Equal(t, expected[nb].y, gotY)
 		require.Equal(t, expected[nb].hackyUnique, gotHackyUnique)
+		require.Equal(t, expected[nb].seq, gotSeq)
 		nb++
 	}
 	require.Equal(t, len(expected), nb)
@@ -0,0 +1,193 @@
+package stream
+
+import (
+	"fiurgeist/journey/internal/cache"
+	"fiurgeist/journey/internal/queue"
+	"sync"
+)
+
+const (
+	DEFAULT_HISTORY_SIZE = 1024
+	DEFAULT_BUFFER_SIZE  = 64
+)
+
+const (
+	EventJourneyDiscovered  = "journeyDiscovered"
+	EventPointAdded         = "pointAdded"
+	EventJourneyFullyMapped = "journeyFullyMapped"
+	EventCharacterMoved     = "characterMoved"
+	EventJourneyPath        = "journeyPath"
+)
+
+type Event struct {
+	Id   uint64
+	Type string
+	Data interface{}
+}
+
+// The events carry the world of the journey, which is omitted for the default world
+
+type JourneyDiscovered struct {
+	World         string `json:"world,omitempty"`
+	Id            string `json:"id"`
+	StartId       uint16 `json:"startId"`
+	DestinationId uint16 `json:"destinationId"`
+}
+
+// PointAdded is a point at the end of the journey, or in front of it if Seq is lower than the
+// Seq of all points so far (undirected journeys walked in reverse)
+type PointAdded struct {
+	World string `json:"world,omitempty"`
+	Id    string `json:"id"`
+	X     uint16 `json:"x"`
+	Y     uint16 `json:"y"`
+	Seq   int    `json:"seq"`
+}
+
+type JourneyFullyMapped struct {
+	World string `json:"world,omitempty"`
+	Id    string `json:"id"`
+}
+
+// JourneyPath replaces the points of a journey once it is fully mapped
+type JourneyPath struct {
+	World  string        `json:"world,omitempty"`
+	Id     string        `json:"id"`
+	Points []cache.Point `json:"points"`
+}
+
+// CharacterMoved isn't a queue message, the server publishes it after each accepted movement
+// if enabled
+type CharacterMoved struct {
+	World       string `json:"world,omitempty"`
+	CharacterId string `json:"characterId"`
+	X           uint16 `json:"x"`
+	Y           uint16 `json:"y"`
+}
+
+type Hub interface {
+	Publish(msg interface{})
+	Subscribe(lastEventId uint64) (*Subscription, []Event)
+	Unsubscribe(sub *Subscription)
+}
+
+// Subscription receives the events of a single client. The channel is closed when the
+// client can't keep up with its buffer, it is expected to reconnect with its last event id.
+type Subscription struct {
+	Events chan Event
+}
+
+type hub struct {
+	mu          sync.Mutex
+	lastId      uint64
+	history     []Event
+	historySize int
+	bufferSize  int
+	subscribers map[*Subscription]bool
+}
+
+// NewHub keeps the last historySize events for resuming subscribers, none if it isn't positive.
+// bufferSize is the number of events a subscriber may fall behind.
+func NewHub(historySize, bufferSize int) *hub {
+	if historySize < 0 {
+		historySize = 0
+	}
+	return &hub{
+		history:     make([]Event, 0, historySize),
+		historySize: historySize,
+		bufferSize:  bufferSize,
+		subscribers: make(map[*Subscription]bool),
+	}
+}
+
+// Publish converts a queue message into an event and sends it to all subscribers
+func (h *hub) Publish(msg interface{}) {
+	event, ok := newEvent(msg)
+	if !ok {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastId++
+	event.Id = h.lastId
+	if h.historySize > 0 {
+		if len(h.history) == h.historySize {
+			copy(h.history, h.history[1:])
+			h.history = h.history[:len(h.history)-1]
+		}
+		h.history = append(h.history, event)
+	}
+
+	for sub := range h.subscribers {
+		select {
+		case sub.Events <- event:
+		default:
+			// slow client, drop it instead of blocking the cache
+			delete(h.subscribers, sub)
+			close(sub.Events)
+		}
+	}
+}
+
+// Subscribe returns a new subscription and all events after lastEventId which are still known
+func (h *hub) Subscribe(lastEventId uint64) (*Subscription, []Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var missed []Event
+	for _, event := range h.history {
+		if event.Id > lastEventId {
+			missed = append(missed, event)
+		}
+	}
+
+	sub := &Subscription{Events: make(chan Event, h.bufferSize)}
+	h.subscribers[sub] = true
+	return sub, missed
+}
+
+func (h *hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subscribers[sub] {
+		delete(h.subscribers, sub)
+		close(sub.Events)
+	}
+}
+
+func newEvent(msg interface{}) (Event, bool) {
+	switch data := msg.(type) {
+	case queue.NewJourney:
+		return Event{
+			Type: EventJourneyDiscovered,
+			Data: JourneyDiscovered{
+				World:         data.World,
+				Id:            cache.JourneyId(data.StartId, data.DestinationId),
+				StartId:       data.StartId,
+				DestinationId: data.DestinationId,
+			},
+		}, true
+	case queue.NewLocation:
+		return Event{
+			Type: EventPointAdded,
+			Data: PointAdded{World: data.World, Id: cache.JourneyId(data.StartId, data.DestinationId), X: data.X, Y: data.Y, Seq: data.Seq},
+		}, true
+	case queue.JourneyFullyMapped:
+		return Event{
+			Type: EventJourneyFullyMapped,
+			Data: JourneyFullyMapped{World: data.World, Id: cache.JourneyId(data.StartId, data.DestinationId)},
+		}, true
+	case queue.JourneyPath:
+		path := JourneyPath{World: data.World, Id: cache.JourneyId(data.StartId, data.DestinationId), Points: []cache.Point{}}
+		for _, location := range data.Points {
+			path.Points = append(path.Points, cache.Point{X: location.X, Y: location.Y})
+		}
+		return Event{Type: EventJourneyPath, Data: path}, true
+	case CharacterMoved:
+		return Event{Type: EventCharacterMoved, Data: data}, true
+	}
+	return Event{}, false
+}
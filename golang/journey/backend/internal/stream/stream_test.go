@@ -0,0 +1,122 @@
+package stream
+
+import (
+	"fiurgeist/journey/internal/cache"
+	"fiurgeist/journey/internal/queue"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestPublish(t *testing.T) {
+	hub := NewHub(10, 10)
+	sub, missed := hub.Subscribe(0)
+	require.Empty(t, missed)
+
+	hub.Publish(queue.NewJourney{StartId: 23, DestinationId: 42})
+	// in front of the first point
+	hub.Publish(queue.NewLocation{StartId: 23, DestinationId: 42, X: 1, Y: 2, Seq: -1})
+	hub.Publish(queue.JourneyFullyMapped{World: "moon", StartId: 23, DestinationId: 42})
+	hub.Publish(CharacterMoved{CharacterId: "character1", X: 1, Y: 2})
+	hub.Publish(queue.JourneyPath{StartId: 23, DestinationId: 42, Points: []queue.Location{{X: 1, Y: 2}}})
+
+	require.Equal(
+		t,
+		Event{
+			Id:   1,
+			Type: EventJourneyDiscovered,
+			Data: JourneyDiscovered{Id: "23->42", StartId: 23, DestinationId: 42},
+		},
+		<-sub.Events,
+	)
+	require.Equal(
+		t,
+		Event{Id: 2, Type: EventPointAdded, Data: PointAdded{Id: "23->42", X: 1, Y: 2, Seq: -1}},
+		<-sub.Events,
+	)
+	require.Equal(
+		t,
+		Event{Id: 3, Type: EventJourneyFullyMapped, Data: JourneyFullyMapped{World: "moon", Id: "23->42"}},
+		<-sub.Events,
+	)
+	require.Equal(
+		t,
+		Event{Id: 4, Type: EventCharacterMoved, Data: CharacterMoved{CharacterId: "character1", X: 1, Y: 2}},
+		<-sub.Events,
+	)
+	require.Equal(
+		t,
+		Event{Id: 5, Type: EventJourneyPath, Data: JourneyPath{Id: "23->42", Points: []cache.Point{{X: 1, Y: 2}}}},
+		<-sub.Events,
+	)
+}
+
+func TestPublishIgnoreUnknownMessage(t *testing.T) {
+	hub := NewHub(10, 10)
+	sub, _ := hub.Subscribe(0)
+
+	hub.Publish(struct{}{})
+
+	require.Equal(t, 0, len(sub.Events))
+	require.Equal(t, 0, len(hub.history))
+}
+
+func TestPublishDropSlowSubscriber(t *testing.T) {
+	hub := NewHub(10, 1)
+	sub, _ := hub.Subscribe(0)
+
+	hub.Publish(queue.NewJourney{StartId: 23, DestinationId: 42})
+	hub.Publish(queue.NewJourney{StartId: 42, DestinationId: 23})
+
+	// the buffered event is still delivered, then the channel is closed
+	event, ok := <-sub.Events
+	require.True(t, ok)
+	require.Equal(t, uint64(1), event.Id)
+	_, ok = <-sub.Events
+	require.False(t, ok)
+	require.Equal(t, 0, len(hub.subscribers))
+}
+
+func TestSubscribeResume(t *testing.T) {
+	hub := NewHub(2, 10)
+
+	hub.Publish(queue.NewJourney{StartId: 23, DestinationId: 42})
+	// in front of the first point
+	hub.Publish(queue.NewLocation{StartId: 23, DestinationId: 42, X: 1, Y: 2, Seq: -1})
+	hub.Publish(queue.NewLocation{StartId: 23, DestinationId: 42, X: 2, Y: 2})
+
+	// only events after the last seen id
+	_, missed := hub.Subscribe(2)
+	require.Equal(t, 1, len(missed))
+	require.Equal(t, uint64(3), missed[0].Id)
+
+	// the history is limited
+	_, missed = hub.Subscribe(0)
+	require.Equal(t, 2, len(missed))
+	require.Equal(t, uint64(2), missed[0].Id)
+	require.Equal(t, uint64(3), missed[1].Id)
+}
+
+func TestSubscribeWithoutHistory(t *testing.T) {
+	hub := NewHub(0, 10)
+	sub, _ := hub.Subscribe(0)
+
+	hub.Publish(queue.NewJourney{StartId: 23, DestinationId: 42})
+
+	event := <-sub.Events
+	require.Equal(t, uint64(1), event.Id)
+	_, missed := hub.Subscribe(0)
+	require.Empty(t, missed)
+}
+
+func TestUnsubscribe(t *testing.T) {
+	hub := NewHub(10, 10)
+	sub, _ := hub.Subscribe(0)
+
+	hub.Unsubscribe(sub)
+	_, ok := <-sub.Events
+	require.False(t, ok)
+	require.Equal(t, 0, len(hub.subscribers))
+
+	// unsubscribing twice is a no-op
+	hub.Unsubscribe(sub)
+}